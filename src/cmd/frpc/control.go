@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,43 +28,72 @@ import (
 	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
 	"github.com/fatedier/frp/src/utils/pcrypto"
+	"github.com/fatedier/frp/src/utils/trace"
+	"github.com/fatedier/frp/src/utils/verify"
 )
 
 func ControlProcess(cli *client.ProxyClient, wait *sync.WaitGroup) {
 	defer wait.Done()
-
-	msgSendChan := make(chan interface{}, 1024)
+	defer cli.SetStatus(consts.Closed)
+	log.AddProxyLogs(cli.Name)
 
 	c, err := loginToServer(cli)
 	if err != nil {
 		log.Error("ProxyName [%s], connect to server failed!", cli.Name)
+		if c != nil {
+			c.Close()
+		}
 		return
 	}
-	defer c.Close()
-
-	go heartbeatSender(c, msgSendChan)
-
-	go msgSender(cli, c, msgSendChan)
-	msgReader(cli, c, msgSendChan)
+	cli.SetStatus(consts.Working)
+	msgReader(cli, c)
+}
 
-	close(msgSendChan)
+// start goroutines for sending messages by the control connection c, they stop after stop is called
+func startControl(cli *client.ProxyClient, c *conn.Conn) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	msgSendChan := make(chan interface{}, 1024)
+	var wait sync.WaitGroup
+	wait.Add(2)
+	go func() {
+		defer wait.Done()
+		heartbeatSender(ctx, msgSendChan)
+	}()
+	go func() {
+		defer wait.Done()
+		msgSender(ctx, cli, c, msgSendChan)
+	}()
+	return func() {
+		cancel()
+		wait.Wait()
+	}
 }
 
-// loop for reading messages from frpc after control connection is established
-func msgReader(cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface{}) error {
-	// for heartbeat
-	var heartbeatTimeout bool = false
-	timer := time.AfterFunc(time.Duration(client.HeartBeatTimeout)*time.Second, func() {
-		heartbeatTimeout = true
+// the control connection c is closed if no heartbeat response is received in time
+func newHeartbeatTimer(cli *client.ProxyClient, c *conn.Conn) *time.Timer {
+	return time.AfterFunc(time.Duration(client.HeartBeatTimeout)*time.Second, func() {
 		c.Close()
 		log.Error("ProxyName [%s], heartbeatRes from frps timeout", cli.Name)
 	})
-	defer timer.Stop()
+}
+
+// loop for reading messages from frpc after control connection is established
+func msgReader(cli *client.ProxyClient, c *conn.Conn) error {
+	stop := startControl(cli, c)
+	timer := newHeartbeatTimer(cli, c)
+	defer func() {
+		timer.Stop()
+		stop()
+		c.Close()
+	}()
 
 	for {
 		buf, err := c.ReadLine()
-		if err == io.EOF || c == nil || c.IsClosed() {
+		if err == io.EOF || c.IsClosed() {
+			timer.Stop()
+			stop()
 			c.Close()
+			cli.SetStatus(consts.Idle)
 			log.Warn("ProxyName [%s], frps close this control conn!", cli.Name)
 			var delayTime time.Duration = 1
 
@@ -72,12 +102,14 @@ func msgReader(cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface
 				log.Info("ProxyName [%s], try to reconnect to frps [%s:%d]...", cli.Name, client.ServerAddr, client.ServerPort)
 				c, err = loginToServer(cli)
 				if err == nil {
-					close(msgSendChan)
-					msgSendChan = make(chan interface{}, 1024)
-					go heartbeatSender(c, msgSendChan)
-					go msgSender(cli, c, msgSendChan)
+					cli.SetStatus(consts.Working)
+					stop = startControl(cli, c)
+					timer = newHeartbeatTimer(cli, c)
 					break
 				}
+				if c != nil {
+					c.Close()
+				}
 
 				if delayTime < 60 {
 					delayTime = delayTime * 2
@@ -102,21 +134,21 @@ func msgReader(cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface
 			timer.Reset(time.Duration(client.HeartBeatTimeout) * time.Second)
 		case consts.NoticeUserConn:
 			log.Debug("ProxyName [%s], new user connection", cli.Name)
-			// join local and remote connections, async
-			go cli.StartTunnel(client.ServerAddr, client.ServerPort)
+			cli.HandleUserConn(ctlRes.Traceparent)
 		default:
 			log.Warn("ProxyName [%s}, unsupport msgType [%d]", cli.Name, ctlRes.Type)
 		}
 	}
-	return nil
 }
 
 // loop for sending messages from channel to frps
-func msgSender(cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface{}) {
+func msgSender(ctx context.Context, cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface{}) {
 	for {
-		msg, ok := <-msgSendChan
-		if !ok {
-			break
+		var msg interface{}
+		select {
+		case msg = <-msgSendChan:
+		case <-ctx.Done():
+			return
 		}
 
 		buf, _ := json.Marshal(msg)
@@ -124,21 +156,37 @@ func msgSender(cli *client.ProxyClient, c *conn.Conn, msgSendChan chan interface
 		if err != nil {
 			log.Warn("ProxyName [%s], write to server error, proxy exit", cli.Name)
 			c.Close()
-			break
+			return
 		}
 	}
 }
 
 func loginToServer(cli *client.ProxyClient) (c *conn.Conn, err error) {
-	if client.HttpProxy == "" {
-		c, err = conn.ConnectServer(fmt.Sprintf("%s:%d", client.ServerAddr, client.ServerPort))
-	} else {
-		c, err = conn.ConnectServerByHttpProxy(client.HttpProxy, fmt.Sprintf("%s:%d", client.ServerAddr, client.ServerPort))
+	span := trace.Start("frpc.login", "")
+	span.SetAttribute("proxy_name", cli.Name)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	addrs, err := client.GetServerAddrs()
+	if err != nil {
+		log.Error("ProxyName [%s], get server address error, %v", cli.Name, err)
+		return
+	}
+	// try addresses in order until one is connected
+	var serverAddr string
+	for _, serverAddr = range addrs {
+		c, err = conn.ConnectServerByTransport(client.Transport, serverAddr)
+		if err == nil {
+			break
+		}
+		log.Error("ProxyName [%s], connect to server [%s] error, %v", cli.Name, serverAddr, err)
 	}
 	if err != nil {
-		log.Error("ProxyName [%s], connect to server [%s:%d] error, %v", cli.Name, client.ServerAddr, client.ServerPort, err)
 		return
 	}
+	cli.SetServerAddr(serverAddr)
 
 	nowTime := time.Now().Unix()
 	req := &msg.ControlReq{
@@ -153,12 +201,21 @@ func loginToServer(cli *client.ProxyClient) (c *conn.Conn, err error) {
 		HttpUserName:      cli.HttpUserName,
 		HttpPassWord:      cli.HttpPassWord,
 		SubDomain:         cli.SubDomain,
+		OidcLogin:         cli.OidcLogin,
+		OidcAllowedEmails: cli.OidcAllowedEmails,
+		OidcAllowedGroups: cli.OidcAllowedGroups,
+		HttpCompression:   cli.HttpCompression,
 		Timestamp:         nowTime,
+		Traceparent:       span.Traceparent(),
+		HalfClose:         true,
 	}
 	if cli.PrivilegeMode {
 		privilegeKey := pcrypto.GetAuthKey(cli.Name + client.PrivilegeToken + fmt.Sprintf("%d", nowTime))
 		req.RemotePort = cli.RemotePort
 		req.CustomDomains = cli.CustomDomains
+		if client.DomainSecret != "" {
+			req.DomainId = verify.Identity(client.DomainSecret)
+		}
 		req.PrivilegeKey = privilegeKey
 	} else {
 		authKey := pcrypto.GetAuthKey(cli.Name + cli.AuthToken + fmt.Sprintf("%d", nowTime))
@@ -185,34 +242,41 @@ func loginToServer(cli *client.ProxyClient) (c *conn.Conn, err error) {
 		return
 	}
 
-	if ctlRes.Code != 0 {
+	if ctlRes.Code == consts.LoginPolicyDenied {
+		log.Error("ProxyName [%s], denied by the policy of frps, %s", cli.Name, ctlRes.Msg)
+		return c, fmt.Errorf("%s", ctlRes.Msg)
+	} else if ctlRes.Code != 0 {
 		log.Error("ProxyName [%s], start proxy error, %s", cli.Name, ctlRes.Msg)
 		return c, fmt.Errorf("%s", ctlRes.Msg)
 	}
 
-	log.Info("ProxyName [%s], connect to server [%s:%d] success!", cli.Name, client.ServerAddr, client.ServerPort)
+	// old frps doesn't reply it, EOF of tunnels closes both directions then
+	cli.HalfClose = ctlRes.HalfClose
+	log.Info("ProxyName [%s], connect to server [%s] success!", cli.Name, serverAddr)
 
-	if cli.Type == "udp" {
-		// we only need one udp work connection
-		// all udp messages will be forwarded throngh this connection
-		go cli.StartUdpTunnelOnce(client.ServerAddr, client.ServerPort)
-	}
+	cli.OnStart()
 	return
 }
 
-func heartbeatSender(c *conn.Conn, msgSendChan chan interface{}) {
+func heartbeatSender(ctx context.Context, msgSendChan chan interface{}) {
 	heartbeatReq := &msg.ControlReq{
 		Type: consts.HeartbeatReq,
 	}
 	log.Info("Start to send heartbeat to frps")
+	defer log.Info("Heartbeat goroutine exit")
+	ticker := time.NewTicker(time.Duration(client.HeartBeatInterval) * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(time.Duration(client.HeartBeatInterval) * time.Second)
-		if c != nil && !c.IsClosed() {
+		select {
+		case <-ticker.C:
 			log.Debug("Send heartbeat to server")
-			msgSendChan <- heartbeatReq
-		} else {
-			break
+			select {
+			case msgSendChan <- heartbeatReq:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
-	log.Info("Heartbeat goroutine exit")
 }
@@ -20,11 +20,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	docopt "github.com/docopt/docopt-go"
 
 	"github.com/fatedier/frp/src/models/client"
 	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/trace"
 	"github.com/fatedier/frp/src/utils/version"
 )
 
@@ -36,6 +38,7 @@ var usage string = `frpc is the client of frp
 
 Usage: 
     frpc [-c config_file] [-L log_file] [--log-level=<log_level>] [--server-addr=<server_addr>]
+    frpc top [-c config_file] [--server=<admin_addr>] [--interval=<seconds>] [--sort=<column>]
     frpc -h | --help
     frpc -v | --version
 
@@ -44,6 +47,9 @@ Options:
     -L log_file                 set output log file, including console
     --log-level=<log_level>     set log level: debug, info, warn, error
     --server-addr=<server_addr> addr which frps is listening for, example: 0.0.0.0:7000
+    --server=<admin_addr>       admin addr of frpc for top, default is admin_addr:admin_port in config file
+    --interval=<seconds>        refresh interval of top, default is 2 seconds
+    --sort=<column>             sort top by column number or name, example: Conns
     -h --help                   show this screen
     --version                   show version
 `
@@ -61,6 +67,38 @@ func main() {
 		os.Exit(-1)
 	}
 
+	// show status of all proxies in terminal
+	if args["top"] != nil && args["top"].(bool) {
+		adminAddr := fmt.Sprintf("%s:%d", client.AdminAddr, client.AdminPort)
+		if args["--server"] != nil {
+			adminAddr = args["--server"].(string)
+		} else if client.AdminPort == 0 {
+			fmt.Println("frpc top error: admin_port is not set")
+			os.Exit(1)
+		}
+
+		var interval int64 = 2
+		if args["--interval"] != nil {
+			interval, err = strconv.ParseInt(args["--interval"].(string), 10, 64)
+			if err != nil || interval <= 0 {
+				fmt.Println("--interval format error, example: 2")
+				os.Exit(1)
+			}
+		}
+
+		var sortCol string
+		if args["--sort"] != nil {
+			sortCol = args["--sort"].(string)
+		}
+
+		err = runTop(adminAddr, time.Duration(interval)*time.Second, sortCol)
+		if err != nil {
+			fmt.Printf("frpc top error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if args["-L"] != nil {
 		if args["-L"].(string) == "console" {
 			client.LogWay = "console"
@@ -98,10 +136,35 @@ func main() {
 
 	log.InitLog(client.LogWay, client.LogFile, client.LogLevel, client.LogMaxDays)
 
+	if client.TraceEndpoint != "" {
+		trace.DefaultTracer = trace.NewTracer(trace.NewOtlpExporter(client.TraceEndpoint, client.TraceServiceName), func(err error) {
+			log.Warn("Export spans error, %v", err)
+		})
+	}
+
+	// create admin web server if AdminPort is set
+	if client.AdminPort != 0 {
+		err := client.RunAdminServer(client.AdminAddr, client.AdminPort)
+		if err != nil {
+			log.Error("Create admin web server error, %v", err)
+			os.Exit(1)
+		}
+	}
+
 	// wait until all control goroutine exit
 	var wait sync.WaitGroup
 	wait.Add(len(client.ProxyClients))
 
+	// proxies of downstream frpc are relayed until frpc exits
+	if client.RelayBindPort != 0 {
+		err := client.RunRelay(client.RelayBindAddr, client.RelayBindPort)
+		if err != nil {
+			log.Error("Create relay listener error, %v", err)
+			os.Exit(1)
+		}
+		wait.Add(1)
+	}
+
 	for _, client := range client.ProxyClients {
 		go ControlProcess(client, &wait)
 	}
@@ -0,0 +1,95 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/src/models/client"
+	"github.com/fatedier/frp/src/utils/top"
+)
+
+var topColumns = []top.Column{
+	{Title: "Name", Width: 20},
+	{Title: "Type", Width: 6},
+	{Title: "Local", Width: 22},
+	{Title: "Remote", Width: 24},
+	{Title: "Status", Width: 8},
+	{Title: "Conns", Width: 6, Numeric: true},
+	{Title: "Encrypt", Width: 7},
+	{Title: "Gzip", Width: 5},
+}
+
+// poll /api/status of frpc admin server
+func runTop(adminAddr string, interval time.Duration, sortCol string) error {
+	url := "http://" + adminAddr + "/api/status"
+	events := top.NewEventLog(8)
+	var lastStatus map[string]string
+
+	fetch := func() (*top.Snapshot, error) {
+		res := &client.StatusResponse{}
+		err := top.GetJson(url, client.AdminUsername, client.AdminPassword, res)
+		if err != nil {
+			return nil, err
+		}
+		if res.Code != 0 {
+			return nil, fmt.Errorf("%s", res.Msg)
+		}
+
+		snapshot := &top.Snapshot{
+			Title:   fmt.Sprintf("frpc %s -> frps %s", adminAddr, res.ServerAddr),
+			Columns: topColumns,
+			Rows:    make([]top.Row, 0, len(res.Proxies)),
+		}
+		status := make(map[string]string)
+		for _, p := range res.Proxies {
+			status[p.Name] = p.Status
+			if lastStatus != nil && lastStatus[p.Name] != p.Status {
+				events.Add("ProxyName [%s], status %s -> %s", p.Name, lastStatus[p.Name], p.Status)
+			}
+
+			remote := fmt.Sprintf("%d", p.RemotePort)
+			if p.Type == "http" || p.Type == "https" {
+				remote = strings.Join(p.CustomDomains, ",")
+				if p.SubDomain != "" {
+					remote = strings.TrimPrefix(remote+","+p.SubDomain, ",")
+				}
+			}
+			snapshot.Rows = append(snapshot.Rows, top.Row{
+				Cells: []string{
+					p.Name, p.Type, p.LocalAddr, remote, p.Status,
+					fmt.Sprintf("%d", p.CurrentConns),
+					fmt.Sprintf("%v", p.UseEncryption),
+					fmt.Sprintf("%v", p.UseGzip),
+				},
+				Values: []float64{0, 0, 0, 0, 0, float64(p.CurrentConns), 0, 0},
+			})
+		}
+		lastStatus = status
+		snapshot.Events = events.Events()
+		return snapshot, nil
+	}
+
+	view := top.NewView(fetch, interval, os.Stdout)
+	if sortCol != "" {
+		if err := view.SortBy(sortCol, topColumns); err != nil {
+			return err
+		}
+	}
+	return view.Run(os.Stdin)
+}
@@ -15,10 +15,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatedier/frp/src/models/consts"
@@ -28,6 +29,7 @@ import (
 	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
 	"github.com/fatedier/frp/src/utils/pcrypto"
+	"github.com/fatedier/frp/src/utils/trace"
 )
 
 func ProcessControlConn(l *conn.Listener) {
@@ -50,7 +52,7 @@ func controlWorker(c *conn.Conn) {
 		if closeFlag {
 			c.Close()
 			if s != nil {
-				s.Close()
+				s.Disconnect(c)
 			}
 		}
 	}()
@@ -69,14 +71,32 @@ func controlWorker(c *conn.Conn) {
 		return
 	}
 
+	// the connection is used as the link from an edge node until it's broken
+	if cliReq.Type == consts.NewEdgeConn {
+		closeFlag = false
+		server.ServeEdgeLink(c, cliReq)
+		return
+	}
+
 	// login when type is NewCtlConn or NewWorkConn
-	ret, info := doLogin(cliReq, c)
+	var span *trace.Span
+	if cliReq.Type == consts.NewCtlConn {
+		span = trace.Start("frps.login", cliReq.Traceparent)
+		span.SetAttribute("proxy_name", cliReq.ProxyName)
+		span.SetAttribute("client_addr", c.GetRemoteAddr())
+	}
+	ret, info := doLogin(cliReq, c, span)
+	if ret > 0 {
+		span.SetError(fmt.Errorf("%s", info))
+	}
+	span.End()
 	// if login type is NewWorkConn, nothing will be send to frpc
 	if cliReq.Type == consts.NewCtlConn {
 		cliRes := &msg.ControlRes{
-			Type: consts.NewCtlConnRes,
-			Code: ret,
-			Msg:  info,
+			Type:      consts.NewCtlConnRes,
+			Code:      ret,
+			Msg:       info,
+			HalfClose: cliReq.HalfClose,
 		}
 		byteBuf, _ := json.Marshal(cliRes)
 		err = c.WriteString(string(byteBuf) + "\n")
@@ -100,42 +120,56 @@ func controlWorker(c *conn.Conn) {
 		return
 	}
 
-	// create a channel for sending messages
+	// goroutines for the control connection stop after ctx is canceled
+	ctx, cancel := context.WithCancel(context.Background())
 	msgSendChan := make(chan interface{}, 1024)
-	go msgSender(s, c, msgSendChan)
-	go noticeUserConn(s, msgSendChan)
+	var wait sync.WaitGroup
+	wait.Add(2)
+	go func() {
+		defer wait.Done()
+		msgSender(ctx, s, c, msgSendChan)
+	}()
+	go func() {
+		defer wait.Done()
+		noticeUserConn(ctx, s, c, msgSendChan)
+	}()
 
 	// loop for reading control messages from frpc and deal with different types
-	msgReader(s, c, msgSendChan)
+	msgReader(ctx, s, c, msgSendChan)
 
-	close(msgSendChan)
+	cancel()
+	wait.Wait()
 	log.Info("ProxyName [%s], I'm dead!", s.Name)
 	return
 }
 
 // when frps get one new user connection, send NoticeUserConn message to frpc and accept one new WorkConn later
-func noticeUserConn(s *server.ProxyServer, msgSendChan chan interface{}) {
+func noticeUserConn(ctx context.Context, s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}) {
+	defer log.Debug("ProxyName [%s], goroutine for noticing user conn is closed", s.Name)
 	for {
-		closeFlag := s.WaitUserConn()
+		// WaitUserConn returns after the proxy is disconnected from c, which happens before ctx is canceled
+		traceparent, closeFlag := s.WaitUserConn(c)
 		if closeFlag {
-			log.Debug("ProxyName [%s], goroutine for noticing user conn is closed", s.Name)
-			break
+			return
 		}
 		notice := &msg.ControlRes{
-			Type: consts.NoticeUserConn,
+			Type:        consts.NoticeUserConn,
+			Traceparent: traceparent,
+		}
+		select {
+		case msgSendChan <- notice:
+			log.Debug("ProxyName [%s], notice client to add work conn", s.Name)
+		case <-ctx.Done():
+			return
 		}
-		msgSendChan <- notice
-		log.Debug("ProxyName [%s], notice client to add work conn", s.Name)
 	}
 }
 
 // loop for reading messages from frpc after control connection is established
-func msgReader(s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}) error {
+func msgReader(ctx context.Context, s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}) error {
 	// for heartbeat
-	var heartbeatTimeout bool = false
 	timer := time.AfterFunc(time.Duration(server.HeartBeatTimeout)*time.Second, func() {
-		heartbeatTimeout = true
-		s.Close()
+		s.Disconnect(c)
 		log.Error("ProxyName [%s], client heartbeat timeout", s.Name)
 	})
 	defer timer.Stop()
@@ -145,11 +179,11 @@ func msgReader(s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}
 		if err != nil {
 			if err == io.EOF {
 				log.Warn("ProxyName [%s], client is dead!", s.Name)
-				s.Close()
+				s.Disconnect(c)
 				return err
 			} else if c == nil || c.IsClosed() {
 				log.Warn("ProxyName [%s], client connection is closed", s.Name)
-				s.Close()
+				s.Disconnect(c)
 				return err
 			}
 			log.Warn("ProxyName [%s], read error: %v", s.Name, err)
@@ -169,27 +203,32 @@ func msgReader(s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}
 			heartbeatRes := &msg.ControlRes{
 				Type: consts.HeartbeatRes,
 			}
-			msgSendChan <- heartbeatRes
+			select {
+			case msgSendChan <- heartbeatRes:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		default:
 			log.Warn("ProxyName [%s}, unsupport msgType [%d]", s.Name, cliReq.Type)
 		}
 	}
-	return nil
 }
 
 // loop for sending messages from channel to frpc
-func msgSender(s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}) {
+func msgSender(ctx context.Context, s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}) {
 	for {
-		msg, ok := <-msgSendChan
-		if !ok {
-			break
+		var msg interface{}
+		select {
+		case msg = <-msgSendChan:
+		case <-ctx.Done():
+			return
 		}
 
 		buf, _ := json.Marshal(msg)
 		err := c.WriteString(string(buf) + "\n")
 		if err != nil {
 			log.Warn("ProxyName [%s], write to client error, proxy exit", s.Name)
-			s.Close()
+			s.Disconnect(c)
 			break
 		}
 	}
@@ -199,7 +238,8 @@ func msgSender(s *server.ProxyServer, c *conn.Conn, msgSendChan chan interface{}
 // NewCtlConn
 // NewWorkConn
 // NewWorkConnUdp
-func doLogin(req *msg.ControlReq, c *conn.Conn) (ret int64, info string) {
+// span is the login span of a control connection, nil for work connections
+func doLogin(req *msg.ControlReq, c *conn.Conn, span *trace.Span) (ret int64, info string) {
 	ret = 1
 	// check if PrivilegeMode is enabled
 	if req.PrivilegeMode && !server.PrivilegeMode {
@@ -213,6 +253,12 @@ func doLogin(req *msg.ControlReq, c *conn.Conn) (ret int64, info string) {
 		ok bool
 	)
 	s, ok = server.GetProxyServer(req.ProxyName)
+	// standalone proxies have no auth_token, they can't be used by any kind of connections from frpc
+	if ok && s.IsStandalone() {
+		info = fmt.Sprintf("ProxyName [%s], type [%s] is served by frps and can't be used by frpc", req.ProxyName, s.Type)
+		log.Warn(info)
+		return
+	}
 	if req.PrivilegeMode && req.Type == consts.NewCtlConn {
 		log.Debug("ProxyName [%s], doLogin and privilege mode is enabled", req.ProxyName)
 	} else {
@@ -254,111 +300,149 @@ func doLogin(req *msg.ControlReq, c *conn.Conn) (ret int64, info string) {
 
 	// control conn
 	if req.Type == consts.NewCtlConn {
+		// options from frpc are checked on a new proxy, the existing one isn't changed before they are valid
+		var n *server.ProxyServer
 		if req.PrivilegeMode {
-			s = server.NewProxyServerFromCtlMsg(req)
-			// we check listen_port if privilege_allow_ports are set
-			// and PrivilegeMode is enabled
-			if s.Type == "tcp" {
-				if len(server.PrivilegeAllowPorts) != 0 {
-					_, ok := server.PrivilegeAllowPorts[s.ListenPort]
-					if !ok {
-						info = fmt.Sprintf("ProxyName [%s], remote_port [%d] isn't allowed", req.ProxyName, s.ListenPort)
-						log.Warn(info)
-						return
-					}
-				}
-			} else if s.Type == "http" || s.Type == "https" {
-				for _, domain := range s.CustomDomains {
-					if server.SubDomainHost != "" && strings.Contains(domain, server.SubDomainHost) {
-						info = fmt.Sprintf("ProxyName [%s], custom domain [%s] should not belong to subdomain_host [%s]", req.ProxyName, domain, server.SubDomainHost)
-						log.Warn(info)
-						return
-					}
-				}
-			}
-			err := server.CreateProxy(s)
+			var err error
+			n, err = server.NewProxyServerFromCtlMsg(req)
 			if err != nil {
 				info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
 				log.Warn(info)
 				return
 			}
+		} else {
+			n = s.CopyConf()
 		}
 
-		// check if vhost_port is set
-		if s.Type == "http" && server.VhostHttpMuxer == nil {
-			info = fmt.Sprintf("ProxyName [%s], type [http] not support when vhost_http_port is not set", req.ProxyName)
+		// proxies created in privilege mode follow the default policy
+		if err := n.Policy.Check(req.UseEncryption, req.UseGzip); err != nil {
+			ret = consts.LoginPolicyDenied
+			info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
 			log.Warn(info)
 			return
 		}
-		if s.Type == "https" && server.VhostHttpsMuxer == nil {
-			info = fmt.Sprintf("ProxyName [%s], type [https] not support when vhost_https_port is not set", req.ProxyName)
+
+		// set infomations from frpc
+		n.UseEncryption = req.UseEncryption
+		n.UseGzip = req.UseGzip
+		if err := n.LoadCtlMsg(req); err != nil {
+			info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
 			log.Warn(info)
 			return
 		}
 
-		// set infomations from frpc
-		s.UseEncryption = req.UseEncryption
-		s.UseGzip = req.UseGzip
-		s.HostHeaderRewrite = req.HostHeaderRewrite
-		s.HttpUserName = req.HttpUserName
-		s.HttpPassWord = req.HttpPassWord
-
-		// package URL
-		if req.SubDomain != "" {
-			if strings.Contains(req.SubDomain, ".") || strings.Contains(req.SubDomain, "*") {
-				info = fmt.Sprintf("ProxyName [%s], '.' or '*' is not supported in subdomain", req.ProxyName)
+		// check listen port, domains and if the type is supported by frps
+		if err := n.Check(); err != nil {
+			info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
+			log.Warn(info)
+			return
+		}
+
+		// package URL, the type of proxies not created in privilege mode is set in frps.ini
+		if err := n.LoadSubDomain(req.SubDomain); err != nil {
+			info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
+			log.Warn(info)
+			return
+		}
+
+		if req.PoolCount > server.MaxPoolCount {
+			n.PoolCount = server.MaxPoolCount
+		} else if req.PoolCount < 0 {
+			n.PoolCount = 0
+		} else {
+			n.PoolCount = req.PoolCount
+		}
+
+		if req.PrivilegeMode {
+			// the proxy waiting for reconnection is taken over if nothing is changed
+			old, ok := server.GetProxyServer(req.ProxyName)
+			takeOver := ok && old.GetStatus() == consts.Reconnecting && old.PrivilegeMode && old.CompareLogin(n)
+			if ok && !takeOver && old.GetStatus() == consts.Working {
+				info = fmt.Sprintf("ProxyName [%s], already in use", req.ProxyName)
 				log.Warn(info)
 				return
 			}
-			if server.SubDomainHost == "" {
-				info = fmt.Sprintf("ProxyName [%s], subdomain in not supported because this feature is not enabled by remote server", req.ProxyName)
+			// domains are verified after the cheap checks above, also for the proxy taken over
+			// because it may be restored from a state file exported without its identity
+			if err := n.VerifyDomains(); err != nil {
+				info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
 				log.Warn(info)
 				return
 			}
-			s.SubDomain = req.SubDomain + "." + server.SubDomainHost
-		}
-		if req.PoolCount > server.MaxPoolCount {
-			s.PoolCount = server.MaxPoolCount
-		} else if req.PoolCount < 0 {
-			s.PoolCount = 0
+			if takeOver {
+				s = old
+			} else {
+				if err := server.CreateProxy(n); err != nil {
+					info = fmt.Sprintf("ProxyName [%s], %v", req.ProxyName, err)
+					log.Warn(info)
+					return
+				}
+				s = n
+			}
 		} else {
-			s.PoolCount = req.PoolCount
+			switch s.GetStatus() {
+			case consts.Working:
+				info = fmt.Sprintf("ProxyName [%s], already in use", req.ProxyName)
+				log.Warn(info)
+				return
+			case consts.Reconnecting:
+				// listeners are created with the old options, so the proxy is restarted if they are changed
+				if !s.CompareLogin(n) {
+					log.Info("ProxyName [%s], options are changed by frpc, restart", req.ProxyName)
+					s.Close()
+				}
+			}
+			if s.GetStatus() != consts.Reconnecting {
+				s.UseEncryption = n.UseEncryption
+				s.UseGzip = n.UseGzip
+				s.LoadCtlMsg(req)
+				s.SubDomain = n.SubDomain
+				s.PoolCount = n.PoolCount
+			}
 		}
 
-		if s.Status == consts.Working {
-			info = fmt.Sprintf("ProxyName [%s], already in use", req.ProxyName)
-			log.Warn(info)
+		// half-close is used in new tunnels only if frpc supports it
+		s.HalfClose = req.HalfClose
+		status := s.GetStatus()
+
+		// frpc reconnects in the grace period, listeners are kept and queued user conns are served
+		startSpan := span.Child("frps.proxy_start")
+		defer startSpan.End()
+		if status == consts.Reconnecting {
+			startSpan.SetAttribute("resumed", "true")
+			if err := s.Resume(c); err != nil {
+				startSpan.SetError(err)
+				info = fmt.Sprintf("ProxyName [%s], resume proxy error: %v", req.ProxyName, err)
+				log.Warn(info)
+				return
+			}
+			metric.SetClientAddr(s.Name, c.GetRemoteAddr())
+			log.Info("ProxyName [%s], resume proxy success", req.ProxyName)
+			ret = 0
 			return
 		}
 
 		// update metric's proxy status
-		metric.SetProxyInfo(s.Name, s.Type, s.BindAddr, s.UseEncryption, s.UseGzip, s.PrivilegeMode, s.CustomDomains, s.ListenPort)
+		s.SetMetrics()
 
 		// start proxy and listen for user connections, no block
 		err := s.Start(c)
 		if err != nil {
+			startSpan.SetError(err)
 			info = fmt.Sprintf("ProxyName [%s], start proxy error: %v", req.ProxyName, err)
 			log.Warn(info)
 			return
 		}
+		metric.SetClientAddr(s.Name, c.GetRemoteAddr())
 		log.Info("ProxyName [%s], start proxy success", req.ProxyName)
 		if req.PrivilegeMode {
 			log.Info("ProxyName [%s], created by PrivilegeMode", req.ProxyName)
 		}
 	} else if req.Type == consts.NewWorkConn {
-		// work conn
-		if s.Status != consts.Working {
-			log.Warn("ProxyName [%s], is not working when it gets one new work connnection", req.ProxyName)
-			return
-		}
-		// the connection will close after join over
+		// work conn, the connection will close after join over
 		s.RegisterNewWorkConn(c)
 	} else if req.Type == consts.NewWorkConnUdp {
 		// work conn for udp
-		if s.Status != consts.Working {
-			log.Warn("ProxyName [%s], is not working when it gets one new work connnection for udp", req.ProxyName)
-			return
-		}
 		s.RegisterNewWorkConnUdp(c)
 	} else {
 		info = fmt.Sprintf("Unsupport login message type [%d]", req.Type)
@@ -28,9 +28,11 @@ import (
 	docopt "github.com/docopt/docopt-go"
 
 	"github.com/fatedier/frp/src/assets"
+	"github.com/fatedier/frp/src/models/metric"
 	"github.com/fatedier/frp/src/models/server"
 	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/trace"
 	"github.com/fatedier/frp/src/utils/version"
 	"github.com/fatedier/frp/src/utils/vhost"
 )
@@ -40,6 +42,9 @@ var usage string = `frps is the server of frp
 Usage: 
     frps [-c config_file] [-L log_file] [--log-level=<log_level>] [--addr=<bind_addr>]
     frps [-c config_file] --reload
+    frps top [-c config_file] [--server=<dashboard_addr>] [--interval=<seconds>] [--sort=<column>]
+    frps state export [-c config_file] [--server=<dashboard_addr>] [--file=<state_file>]
+    frps state import [-c config_file] [--server=<dashboard_addr>] --file=<state_file>
     frps -h | --help
     frps -v | --version
 
@@ -49,6 +54,10 @@ Options:
     --log-level=<log_level>   set log level: debug, info, warn, error
     --addr=<bind_addr>        listen addr for client, example: 0.0.0.0:7000
     --reload                  reload ini file and configures in common section won't be changed
+    --server=<dashboard_addr> dashboard addr of frps for top and state, default is bind_addr:dashboard_port in config file
+    --interval=<seconds>      refresh interval of top, default is 2 seconds
+    --sort=<column>           sort top by column number or name, example: Conns
+    --file=<state_file>       file of runtime state, state is printed if it's not set when exporting
     -h --help                 show this screen
     -v --version              show version
 `
@@ -106,6 +115,65 @@ func main() {
 		}
 	}
 
+	// show status of all proxies in terminal
+	if args["top"] != nil && args["top"].(bool) {
+		dashboardAddr := fmt.Sprintf("%s:%d", server.BindAddr, server.DashboardPort)
+		if args["--server"] != nil {
+			dashboardAddr = args["--server"].(string)
+		} else if server.DashboardPort == 0 {
+			fmt.Println("frps top error: dashboard_port is not set")
+			os.Exit(1)
+		}
+
+		var interval int64 = 2
+		if args["--interval"] != nil {
+			interval, err = strconv.ParseInt(args["--interval"].(string), 10, 64)
+			if err != nil || interval <= 0 {
+				fmt.Println("--interval format error, example: 2")
+				os.Exit(1)
+			}
+		}
+
+		var sortCol string
+		if args["--sort"] != nil {
+			sortCol = args["--sort"].(string)
+		}
+
+		err = runTop(dashboardAddr, time.Duration(interval)*time.Second, sortCol)
+		if err != nil {
+			fmt.Printf("frps top error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// export or import runtime state of a running frps
+	if args["state"] != nil && args["state"].(bool) {
+		dashboardAddr := fmt.Sprintf("%s:%d", server.BindAddr, server.DashboardPort)
+		if args["--server"] != nil {
+			dashboardAddr = args["--server"].(string)
+		} else if server.DashboardPort == 0 {
+			fmt.Println("frps state error: dashboard_port is not set")
+			os.Exit(1)
+		}
+
+		var file string
+		if args["--file"] != nil {
+			file = args["--file"].(string)
+		}
+
+		if args["export"].(bool) {
+			err = runStateExport(dashboardAddr, file)
+		} else {
+			err = runStateImport(dashboardAddr, file)
+		}
+		if err != nil {
+			fmt.Printf("frps state error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if args["-L"] != nil {
 		if args["-L"].(string) == "console" {
 			server.LogWay = "console"
@@ -143,6 +211,12 @@ func main() {
 
 	log.InitLog(server.LogWay, server.LogFile, server.LogLevel, server.LogMaxDays)
 
+	if server.TraceEndpoint != "" {
+		trace.DefaultTracer = trace.NewTracer(trace.NewOtlpExporter(server.TraceEndpoint, server.TraceServiceName), func(err error) {
+			log.Warn("Export spans error, %v", err)
+		})
+	}
+
 	// init assets
 	err = assets.Load(server.AssetsDir)
 	if err != nil {
@@ -150,7 +224,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	l, err := conn.Listen(server.BindAddr, server.BindPort)
+	l, err := conn.ListenByTransport(server.Transport, fmt.Sprintf("%s:%d", server.BindAddr, server.BindPort))
 	if err != nil {
 		log.Error("Create server listener error, %v", err)
 		os.Exit(1)
@@ -166,6 +240,8 @@ func main() {
 		server.VhostHttpMuxer, err = vhost.NewHttpMuxer(vhostListener, 30*time.Second)
 		if err != nil {
 			log.Error("Create vhost httpMuxer error, %v", err)
+		} else {
+			server.VhostHttpMuxer.SetStatsFunc(metric.AddHttpRequest)
 		}
 	}
 
@@ -179,9 +255,18 @@ func main() {
 		server.VhostHttpsMuxer, err = vhost.NewHttpsMuxer(vhostListener, 30*time.Second)
 		if err != nil {
 			log.Error("Create vhost httpsMuxer error, %v", err)
+		} else {
+			server.VhostHttpsMuxer.SetStatsFunc(metric.AddHttpRequest)
 		}
 	}
 
+	server.StartStandaloneProxies()
+
+	// relay proxies of the core frps if it's an edge node
+	if server.EdgeCoreAddr != "" {
+		go server.RunEdgeLink()
+	}
+
 	// create dashboard web server if DashboardPort is set, so it won't be 0
 	if server.DashboardPort != 0 {
 		err := server.RunDashboardServer(server.BindAddr, server.DashboardPort)
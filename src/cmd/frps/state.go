@@ -0,0 +1,93 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/src/models/server"
+)
+
+// save runtime state of a running frps to file, it's printed if file is empty
+func runStateExport(dashboardAddr string, file string) error {
+	res, err := requestState(dashboardAddr, "GET", nil)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(res.State, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if file == "" {
+		_, err = os.Stdout.Write(buf)
+		return err
+	}
+	err = ioutil.WriteFile(file, buf, 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d proxies are exported to %s\n", len(res.State.Proxies), file)
+	return nil
+}
+
+// restore runtime state from file into a running frps
+func runStateImport(dashboardAddr string, file string) error {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	res, err := requestState(dashboardAddr, "POST", buf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("state is imported, %d proxies are restored\n", res.Restored)
+	return nil
+}
+
+func requestState(dashboardAddr string, method string, body []byte) (*server.StateResponse, error) {
+	req, err := http.NewRequest(method, "http://"+dashboardAddr+"/api/state", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	authStr := "Basic " + base64.StdEncoding.EncodeToString([]byte(server.DashboardUsername+":"+server.DashboardPassword))
+	req.Header.Add("Authorization", authStr)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &server.StateResponse{}
+	if err = json.Unmarshal(respBody, res); err != nil {
+		return nil, fmt.Errorf("http response error: %s", strings.TrimSpace(string(respBody)))
+	}
+	if res.Code != 0 {
+		return nil, fmt.Errorf("%s", res.Msg)
+	}
+	return res, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/server"
+	"github.com/fatedier/frp/src/utils/top"
+)
+
+var topColumns = []top.Column{
+	{Title: "Name", Width: 20},
+	{Title: "Type", Width: 6},
+	{Title: "Port", Width: 6, Numeric: true},
+	{Title: "Status", Width: 8},
+	{Title: "Client", Width: 22},
+	{Title: "Conns", Width: 6, Numeric: true},
+	{Title: "Conn/s", Width: 7, Numeric: true},
+	{Title: "In/s", Width: 10, Numeric: true},
+	{Title: "Out/s", Width: 10, Numeric: true},
+	{Title: "FlowIn", Width: 10, Numeric: true},
+	{Title: "FlowOut", Width: 10, Numeric: true},
+}
+
+type topFlow struct {
+	flowIn  int64
+	flowOut int64
+	status  string
+}
+
+// poll /api/proxies of frps dashboard, rates are moving averages kept by frps
+func runTop(dashboardAddr string, interval time.Duration, sortCol string) error {
+	url := "http://" + dashboardAddr + "/api/proxies"
+	events := top.NewEventLog(8)
+	lastFlows := make(map[string]*topFlow)
+	first := true
+
+	fetch := func() (*top.Snapshot, error) {
+		res := &server.ProxiesResponse{}
+		err := top.GetJson(url, server.DashboardUsername, server.DashboardPassword, res)
+		if err != nil {
+			return nil, err
+		}
+		if res.Code != 0 {
+			return nil, fmt.Errorf("%s", res.Msg)
+		}
+
+		snapshot := &top.Snapshot{
+			Title: fmt.Sprintf("frps %s  in %s/s  out %s/s  %.1f conn/s", dashboardAddr,
+				top.FormatBytes(res.Rates.BytesIn), top.FormatBytes(res.Rates.BytesOut), res.Rates.Conns),
+			Columns: topColumns,
+			Rows:    make([]top.Row, 0, len(res.Proxies)),
+		}
+		flows := make(map[string]*topFlow)
+		for _, p := range res.Proxies {
+			flow := &topFlow{status: p.Status}
+			if len(p.Daily) > 0 {
+				today := p.Daily[len(p.Daily)-1]
+				flow.flowIn, flow.flowOut = today.FlowIn, today.FlowOut
+			}
+			flows[p.Name] = flow
+
+			last, ok := lastFlows[p.Name]
+			if ok {
+				if last.status != flow.status {
+					events.Add("ProxyName [%s], status %s -> %s", p.Name, last.status, flow.status)
+				}
+			} else if !first {
+				events.Add("ProxyName [%s], new proxy, status %s", p.Name, flow.status)
+			}
+			snapshot.Rows = append(snapshot.Rows, topRow(p, flow))
+		}
+		for name := range lastFlows {
+			if _, ok := flows[name]; !ok {
+				events.Add("ProxyName [%s], proxy deleted", name)
+			}
+		}
+		lastFlows = flows
+		first = false
+		snapshot.Events = events.Events()
+		return snapshot, nil
+	}
+
+	view := top.NewView(fetch, interval, os.Stdout)
+	if sortCol != "" {
+		if err := view.SortBy(sortCol, topColumns); err != nil {
+			return err
+		}
+	}
+	return view.Run(os.Stdin)
+}
+
+func topRow(p *metric.ServerMetric, flow *topFlow) top.Row {
+	port := fmt.Sprintf("%d", p.ListenPort)
+	if len(p.CustomDomains) > 0 {
+		port += " " + strings.Join(p.CustomDomains, ",")
+	}
+	return top.Row{
+		Cells: []string{
+			p.Name, p.Type, port, p.Status, p.ClientAddr,
+			fmt.Sprintf("%d", p.CurrentConns),
+			fmt.Sprintf("%.1f", p.Rates.Conns),
+			top.FormatBytes(p.Rates.BytesIn) + "/s",
+			top.FormatBytes(p.Rates.BytesOut) + "/s",
+			top.FormatBytes(float64(flow.flowIn)),
+			top.FormatBytes(float64(flow.flowOut)),
+		},
+		Values: []float64{
+			0, 0, float64(p.ListenPort), 0, 0,
+			float64(p.CurrentConns), p.Rates.Conns, p.Rates.BytesIn, p.Rates.BytesOut,
+			float64(flow.flowIn), float64(flow.flowOut),
+		},
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/srv"
+)
+
+type ProxyStatus struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Status        string   `json:"status"`
+	LocalAddr     string   `json:"local_addr"`
+	RemotePort    int64    `json:"remote_port"`
+	CustomDomains []string `json:"custom_domains"`
+	SubDomain     string   `json:"subdomain"`
+	UseEncryption bool     `json:"use_encryption"`
+	UseGzip       bool     `json:"use_gzip"`
+	PrivilegeMode bool     `json:"privilege_mode"`
+	CurrentConns  int64    `json:"current_conns"`
+	ServerAddr    string   `json:"server_addr"` // frps connected by the proxy
+}
+
+type StatusResponse struct {
+	Code       int64          `json:"code"`
+	Msg        string         `json:"msg"`
+	ServerAddr string         `json:"server_addr"`
+	Proxies    []*ProxyStatus `json:"proxies"`
+}
+
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	res := &StatusResponse{}
+	defer func() {
+		log.Info("Http response [/api/status]: code [%d]", res.Code)
+	}()
+
+	log.Info("Http request: [/api/status]")
+	if srv.IsSrvName(ServerAddr) {
+		res.ServerAddr = ServerAddr
+	} else {
+		res.ServerAddr = fmt.Sprintf("%s:%d", ServerAddr, ServerPort)
+	}
+	res.Proxies = make([]*ProxyStatus, 0, len(ProxyClients))
+	for _, pc := range ProxyClients {
+		res.Proxies = append(res.Proxies, &ProxyStatus{
+			Name:          pc.Name,
+			Type:          pc.Type,
+			Status:        consts.StatusStr[pc.GetStatus()],
+			LocalAddr:     fmt.Sprintf("%s:%d", pc.LocalIp, pc.LocalPort),
+			RemotePort:    pc.RemotePort,
+			CustomDomains: pc.CustomDomains,
+			SubDomain:     pc.SubDomain,
+			UseEncryption: pc.UseEncryption,
+			UseGzip:       pc.UseGzip,
+			PrivilegeMode: pc.PrivilegeMode,
+			CurrentConns:  pc.GetCurrentConns(),
+			ServerAddr:    pc.GetServerAddr(),
+		})
+	}
+	sort.Sort(ProxyStatusList(res.Proxies))
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+// for sort
+type ProxyStatusList []*ProxyStatus
+
+func (l ProxyStatusList) Len() int           { return len(l) }
+func (l ProxyStatusList) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l ProxyStatusList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+type LogResponse struct {
+	Code         int64    `json:"code"`
+	Msg          string   `json:"msg"`
+	Level        string   `json:"level"`
+	DebugProxies []string `json:"debug_proxies"`
+}
+
+// GET returns the log level, POST with parameter level changes it
+func apiLog(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	res := &LogResponse{}
+	defer func() {
+		log.Info("Http response [/api/log]: code [%d]", res.Code)
+	}()
+
+	log.Info("Http request: [/api/log]")
+	if r.Method == "POST" {
+		level := r.URL.Query().Get("level")
+		if log.IsValidLevel(level) {
+			log.SetLogLevel(level)
+			log.Warn("Log level is changed to [%s]", level)
+		} else {
+			res.Code = 1
+			res.Msg = "level must be one of error, warn, info and debug"
+		}
+	}
+	res.Level = log.GetLogLevel()
+	res.DebugProxies = log.GetDebugProxies()
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+type ProxyLogResponse struct {
+	Code  int64    `json:"code"`
+	Msg   string   `json:"msg"`
+	Name  string   `json:"name"`
+	Debug bool     `json:"debug"`
+	Lines []string `json:"lines"`
+}
+
+// GET returns recent log lines of the proxy, the number of lines is limited by parameter limit,
+// POST with parameter debug=true or debug=false enables or disables debug logs of the proxy
+func apiProxyLog(w http.ResponseWriter, r *http.Request, proxyName string) {
+	var buf []byte
+	res := &ProxyLogResponse{Name: proxyName}
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+	}()
+
+	log.Info("Http request: [%s]", r.URL.Path)
+	if r.Method == "POST" {
+		switch r.URL.Query().Get("debug") {
+		case "true":
+			log.SetProxyDebug(proxyName, true)
+		case "false":
+			log.SetProxyDebug(proxyName, false)
+		default:
+			res.Code = 1
+			res.Msg = "debug must be true or false"
+		}
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v <= 0 {
+			res.Code = 1
+			res.Msg = "limit must be a positive integer"
+		} else {
+			limit = v
+		}
+	}
+	res.Debug = log.IsProxyDebug(proxyName)
+	res.Lines = log.GetProxyLogs(proxyName, limit)
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+func apiProxy(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/proxy/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch parts[1] {
+	case "log":
+		apiProxyLog(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
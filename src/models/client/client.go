@@ -15,19 +15,29 @@
 package client
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/src/models/config"
 	"github.com/fatedier/frp/src/models/consts"
 	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/activate"
 	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
 	"github.com/fatedier/frp/src/utils/pcrypto"
+	"github.com/fatedier/frp/src/utils/plugin"
+	"github.com/fatedier/frp/src/utils/trace"
 )
 
+// max time of tls handshakes with local services
+const localTlsHandshakeTimeout = 10 * time.Second
+
 type ProxyClient struct {
 	config.BaseConf
 	LocalIp   string
@@ -36,82 +46,155 @@ type ProxyClient struct {
 	RemotePort    int64
 	CustomDomains []string
 
-	udpTunnel *conn.Conn
-	once      sync.Once
+	// if activator is not nil, the local service is started on demand
+	activator *activate.Activator
+	// if localTls is not nil, connections to the local service are encrypted by tls
+	localTls *tls.Config
+	// if plugin is not nil, connections are served by it instead of the local service
+	plugin      plugin.Plugin
+	pluginConns int64
+
+	once sync.Once
+
+	// address of frps the control connection is connected to, work connections are connected to the same one
+	serverAddr string
+
+	status       int64
+	currentConns int64
+	mutex        sync.RWMutex
+}
+
+func (pc *ProxyClient) SetStatus(status int64) {
+	pc.mutex.Lock()
+	pc.status = status
+	pc.mutex.Unlock()
+}
+
+func (pc *ProxyClient) GetStatus() int64 {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	return pc.status
+}
+
+func (pc *ProxyClient) SetServerAddr(addr string) {
+	pc.mutex.Lock()
+	pc.serverAddr = addr
+	pc.mutex.Unlock()
+}
+
+func (pc *ProxyClient) GetServerAddr() string {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	return pc.serverAddr
+}
+
+func (pc *ProxyClient) GetCurrentConns() int64 {
+	return atomic.LoadInt64(&pc.currentConns)
 }
 
 // if proxy type is udp, keep a tcp connection for transferring udp packages
-func (pc *ProxyClient) StartUdpTunnelOnce(addr string, port int64) {
+func (pc *ProxyClient) StartUdpTunnelOnce() {
 	pc.once.Do(func() {
-		var err error
-		var c *conn.Conn
-		udpProcessor := NewUdpProcesser(nil, pc.LocalIp, pc.LocalPort)
+		udpProcessor := NewUdpProcesser(pc.LocalIp, pc.LocalPort)
 		for {
-			if pc.udpTunnel == nil || pc.udpTunnel.IsClosed() {
-				if HttpProxy == "" {
-					c, err = conn.ConnectServer(fmt.Sprintf("%s:%d", addr, port))
-				} else {
-					c, err = conn.ConnectServerByHttpProxy(HttpProxy, fmt.Sprintf("%s:%d", addr, port))
-				}
-				if err != nil {
-					log.Error("ProxyName [%s], udp tunnel connect to server [%s:%d] error, %v", pc.Name, addr, port, err)
-					time.Sleep(10 * time.Second)
-					continue
-				}
-				log.Info("ProxyName [%s], udp tunnel reconnect to server [%s:%d] success", pc.Name, addr, port)
-
-				nowTime := time.Now().Unix()
-				req := &msg.ControlReq{
-					Type:          consts.NewWorkConnUdp,
-					ProxyName:     pc.Name,
-					PrivilegeMode: pc.PrivilegeMode,
-					Timestamp:     nowTime,
-				}
-				if pc.PrivilegeMode == true {
-					req.PrivilegeKey = pcrypto.GetAuthKey(pc.Name + PrivilegeToken + fmt.Sprintf("%d", nowTime))
-				} else {
-					req.AuthKey = pcrypto.GetAuthKey(pc.Name + pc.AuthToken + fmt.Sprintf("%d", nowTime))
-				}
-
-				buf, _ := json.Marshal(req)
-				err = c.WriteString(string(buf) + "\n")
-				if err != nil {
-					log.Error("ProxyName [%s], udp tunnel write to server error, %v", pc.Name, err)
-					c.Close()
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				pc.udpTunnel = c
-				udpProcessor.UpdateTcpConn(pc.udpTunnel)
-				udpProcessor.Run()
+			addr := pc.GetServerAddr()
+			c, err := conn.ConnectServerByTransport(Transport, addr)
+			if err != nil {
+				log.Error("ProxyName [%s], udp tunnel connect to server [%s] error, %v", pc.Name, addr, err)
+				time.Sleep(10 * time.Second)
+				continue
+			}
+			log.Info("ProxyName [%s], udp tunnel reconnect to server [%s] success", pc.Name, addr)
+
+			nowTime := time.Now().Unix()
+			req := &msg.ControlReq{
+				Type:          consts.NewWorkConnUdp,
+				ProxyName:     pc.Name,
+				PrivilegeMode: pc.PrivilegeMode,
+				Timestamp:     nowTime,
 			}
+			if pc.PrivilegeMode == true {
+				req.PrivilegeKey = pcrypto.GetAuthKey(pc.Name + PrivilegeToken + fmt.Sprintf("%d", nowTime))
+			} else {
+				req.AuthKey = pcrypto.GetAuthKey(pc.Name + pc.AuthToken + fmt.Sprintf("%d", nowTime))
+			}
+
+			buf, _ := json.Marshal(req)
+			err = c.WriteString(string(buf) + "\n")
+			if err != nil {
+				log.Error("ProxyName [%s], udp tunnel write to server error, %v", pc.Name, err)
+				c.Close()
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
+			// block until the tunnel is broken
+			udpProcessor.Serve(c)
+			c.Close()
 			time.Sleep(1 * time.Second)
 		}
 	})
 }
 
 func (pc *ProxyClient) GetLocalConn() (c *conn.Conn, err error) {
-	c, err = conn.ConnectServer(fmt.Sprintf("%s:%d", pc.LocalIp, pc.LocalPort))
-	if err != nil {
-		log.Error("ProxyName [%s], connect to local port error, %v", pc.Name, err)
+	if pc.plugin != nil {
+		localConn, pluginConn := net.Pipe()
+		info := &plugin.ConnInfo{
+			ProxyName:  pc.Name,
+			ProxyType:  pc.Type,
+			ServerAddr: pc.GetServerAddr(),
+			ConnId:     atomic.AddInt64(&pc.pluginConns, 1),
+		}
+		go func() {
+			if err := pc.plugin.Handle(pluginConn, info); err != nil {
+				log.Warn("ProxyName [%s], plugin error, %v", pc.Name, err)
+			}
+		}()
+		return conn.NewConn(localConn), nil
 	}
-	return
+	var localConn net.Conn
+	if pc.activator != nil {
+		localConn, err = pc.activator.Dial()
+		if err != nil {
+			log.Error("ProxyName [%s], activate local service error, %v", pc.Name, err)
+			return
+		}
+	} else {
+		localConn, err = net.Dial("tcp", net.JoinHostPort(pc.LocalIp, strconv.FormatInt(pc.LocalPort, 10)))
+		if err != nil {
+			log.Error("ProxyName [%s], connect to local port error, %v", pc.Name, err)
+			return
+		}
+	}
+
+	if pc.localTls != nil {
+		tlsConn := tls.Client(localConn, pc.localTls)
+		tlsConn.SetDeadline(time.Now().Add(localTlsHandshakeTimeout))
+		err = tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Error("ProxyName [%s], tls handshake with local service error, %v", pc.Name, err)
+			localConn.Close()
+			if pc.activator != nil {
+				pc.activator.Done()
+			}
+			return
+		}
+		localConn = tlsConn
+	}
+	return conn.NewConn(localConn), nil
 }
 
-func (pc *ProxyClient) GetRemoteConn(addr string, port int64) (c *conn.Conn, err error) {
+func (pc *ProxyClient) GetRemoteConn(addr string) (c *conn.Conn, err error) {
 	defer func() {
 		if err != nil && c != nil {
 			c.Close()
 		}
 	}()
 
-	if HttpProxy == "" {
-		c, err = conn.ConnectServer(fmt.Sprintf("%s:%d", addr, port))
-	} else {
-		c, err = conn.ConnectServerByHttpProxy(HttpProxy, fmt.Sprintf("%s:%d", addr, port))
-	}
+	c, err = conn.ConnectServerByTransport(Transport, addr)
 	if err != nil {
-		log.Error("ProxyName [%s], connect to server [%s:%d] error, %v", pc.Name, addr, port, err)
+		log.Error("ProxyName [%s], connect to server [%s] error, %v", pc.Name, addr, err)
 		return
 	}
 
@@ -139,21 +222,45 @@ func (pc *ProxyClient) GetRemoteConn(addr string, port int64) (c *conn.Conn, err
 	return
 }
 
-func (pc *ProxyClient) StartTunnel(serverAddr string, serverPort int64) (err error) {
+func (pc *ProxyClient) StartTunnel(serverAddr string, traceparent string) (err error) {
+	span := trace.Start("frpc.user_conn", traceparent)
+	span.SetAttribute("proxy_name", pc.Name)
+	span.SetAttribute("proxy_type", pc.Type)
+	defer func() {
+		if err != nil {
+			span.SetError(err)
+			span.End()
+		}
+	}()
+
 	localConn, err := pc.GetLocalConn()
 	if err != nil {
 		return
 	}
-	remoteConn, err := pc.GetRemoteConn(serverAddr, serverPort)
+	span.AddEvent("local_dialed")
+	remoteConn, err := pc.GetRemoteConn(serverAddr)
 	if err != nil {
+		localConn.Close()
+		if pc.activator != nil {
+			pc.activator.Done()
+		}
 		return
 	}
+	span.AddEvent("work_conn_connected")
 
 	// l means local, r means remote
 	log.Debug("Join two connections, (l[%s] r[%s]) (l[%s] r[%s])", localConn.GetLocalAddr(), localConn.GetRemoteAddr(),
 		remoteConn.GetLocalAddr(), remoteConn.GetRemoteAddr())
 	needRecord := false
-	go msg.JoinMore(localConn, remoteConn, pc.BaseConf, needRecord)
+	go func() {
+		atomic.AddInt64(&pc.currentConns, 1)
+		msg.JoinMore(trace.WatchFirstByte(localConn, span, "first_byte"), remoteConn, pc.BaseConf, needRecord)
+		span.End()
+		atomic.AddInt64(&pc.currentConns, -1)
+		if pc.activator != nil {
+			pc.activator.Done()
+		}
+	}()
 
 	return nil
 }
@@ -15,12 +15,24 @@
 package client
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/utils/activate"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/plugin"
+	"github.com/fatedier/frp/src/utils/srv"
 )
 
 // common config
@@ -35,10 +47,59 @@ var (
 	PrivilegeToken    string = ""
 	HeartBeatInterval int64  = 20
 	HeartBeatTimeout  int64  = 90
+	AdminAddr         string = "127.0.0.1"
+	AdminPort         int64  = 0 // if AdminPort equals 0, admin api is not available
+	AdminUsername     string = ""
+	AdminPassword     string = ""
+	TraceEndpoint     string = "" // spans are exported to the OTLP/HTTP collector if it's set
+	TraceServiceName  string = "frpc"
+
+	// private identity of this frpc, custom domains verified by frps are bound to it
+	DomainSecret string = ""
+
+	// transport of connections between frpc and frps: tcp, tls, websocket, unix or pipe
+	Transport conn.Transport = &conn.TcpTransport{}
+
+	// if RelayBindPort is set, frpc on networks which can't reach frps log in to this frpc with privilege_token RelayToken,
+	// their proxies are relayed to frps with names prefixed by RelayProxyPrefix
+	RelayBindAddr    string = "0.0.0.0"
+	RelayBindPort    int64  = 0
+	RelayToken       string = ""
+	RelayProxyPrefix string = "relay_"
+
+	RelayAllowTypes map[string]struct{} // all types are allowed by relay if it's nil
+	RelayAllowPorts map[int64]struct{}  // all remote ports are allowed by relay if it's nil
 )
 
 var ProxyClients map[string]*ProxyClient = make(map[string]*ProxyClient)
 
+var (
+	serverResolver      *srv.Resolver
+	serverResolverMutex sync.Mutex
+)
+
+// GetServerAddrs returns addresses of frps to try in order, if ServerAddr is a SRV record name
+// like _frp._tcp.example.com, it's resolved every time and ServerPort is not used
+func GetServerAddrs() ([]string, error) {
+	if !srv.IsSrvName(ServerAddr) {
+		return []string{net.JoinHostPort(ServerAddr, strconv.FormatInt(ServerPort, 10))}, nil
+	}
+
+	serverResolverMutex.Lock()
+	if serverResolver == nil || serverResolver.Name != ServerAddr {
+		serverResolver = srv.NewResolver(ServerAddr)
+	}
+	r := serverResolver
+	serverResolverMutex.Unlock()
+
+	addrs, err := r.Resolve()
+	if err != nil && len(addrs) > 0 {
+		log.Warn("Lookup SRV records of [%s] error, use cached addresses, %v", ServerAddr, err)
+		err = nil
+	}
+	return addrs, err
+}
+
 func LoadConf(confFile string) (err error) {
 	var tmpStr string
 	var ok bool
@@ -67,6 +128,11 @@ func LoadConf(confFile string) (err error) {
 		HttpProxy = os.Getenv("http_proxy")
 	}
 
+	Transport, err = loadTransportConf(conf)
+	if err != nil {
+		return err
+	}
+
 	tmpStr, ok = conf.Get("common", "log_file")
 	if ok {
 		LogFile = tmpStr
@@ -92,6 +158,48 @@ func LoadConf(confFile string) (err error) {
 		PrivilegeToken = tmpStr
 	}
 
+	tmpStr, ok = conf.Get("common", "domain_secret")
+	if ok {
+		DomainSecret = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "admin_addr")
+	if ok {
+		AdminAddr = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "admin_port")
+	if ok {
+		AdminPort, err = strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: admin_port is incorrect")
+		}
+	}
+
+	tmpStr, ok = conf.Get("common", "admin_user")
+	if ok {
+		AdminUsername = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "admin_pwd")
+	if ok {
+		AdminPassword = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "trace_endpoint")
+	if ok {
+		TraceEndpoint = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "trace_service_name")
+	if ok && tmpStr != "" {
+		TraceServiceName = tmpStr
+	}
+
+	if err = loadRelayConf(conf); err != nil {
+		return err
+	}
+
 	var authToken string
 	tmpStr, ok = conf.Get("common", "auth_token")
 	if ok {
@@ -115,14 +223,14 @@ func LoadConf(confFile string) (err error) {
 				proxyClient.LocalIp = "127.0.0.1"
 			}
 
-			// local_port
+			// local_port, it's not used if connections are served by a plugin
 			tmpStr, ok = section["local_port"]
 			if ok {
 				proxyClient.LocalPort, err = strconv.ParseInt(tmpStr, 10, 64)
 				if err != nil {
 					return fmt.Errorf("Parse conf error: proxy [%s] local_port error", proxyClient.Name)
 				}
-			} else {
+			} else if _, ok = section["plugin"]; !ok {
 				return fmt.Errorf("Parse conf error: proxy [%s] local_port not found", proxyClient.Name)
 			}
 
@@ -130,11 +238,12 @@ func LoadConf(confFile string) (err error) {
 			proxyClient.Type = "tcp"
 			tmpStr, ok = section["type"]
 			if ok {
-				if tmpStr != "tcp" && tmpStr != "http" && tmpStr != "https" && tmpStr != "udp" {
-					return fmt.Errorf("Parse conf error: proxy [%s] type error", proxyClient.Name)
-				}
 				proxyClient.Type = tmpStr
 			}
+			proxyType, ok := GetProxyType(proxyClient.Type)
+			if !ok {
+				return fmt.Errorf("Parse conf error: proxy [%s] type error", proxyClient.Name)
+			}
 
 			// use_encryption
 			proxyClient.UseEncryption = false
@@ -150,29 +259,6 @@ func LoadConf(confFile string) (err error) {
 				proxyClient.UseGzip = true
 			}
 
-			if proxyClient.Type == "http" {
-				// host_header_rewrite
-				tmpStr, ok = section["host_header_rewrite"]
-				if ok {
-					proxyClient.HostHeaderRewrite = tmpStr
-				}
-				// http_user
-				tmpStr, ok = section["http_user"]
-				if ok {
-					proxyClient.HttpUserName = tmpStr
-				}
-				// http_pwd
-				tmpStr, ok = section["http_pwd"]
-				if ok {
-					proxyClient.HttpPassWord = tmpStr
-				}
-				// subdomain
-				tmpStr, ok = section["subdomain"]
-				if ok {
-					proxyClient.SubDomain = tmpStr
-				}
-			}
-
 			// privilege_mode
 			proxyClient.PrivilegeMode = false
 			tmpStr, ok = section["privilege_mode"]
@@ -191,6 +277,36 @@ func LoadConf(confFile string) (err error) {
 				proxyClient.PoolCount = tmpInt
 			}
 
+			// on-demand activation of the local service
+			if err = loadActivateConf(proxyClient, section); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] %v", proxyClient.Name, err)
+			}
+			// pooled connections are connected to the local service in advance, it would never be idle
+			if proxyClient.activator != nil && proxyClient.PoolCount > 0 {
+				return fmt.Errorf("Parse conf error: proxy [%s] pool_count can't be used with activation", proxyClient.Name)
+			}
+
+			// tls to the local service
+			if err = loadLocalTlsConf(proxyClient, section); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] %v", proxyClient.Name, err)
+			}
+
+			// plugin
+			tmpStr, ok = section["plugin"]
+			if ok {
+				if proxyClient.activator != nil || proxyClient.localTls != nil {
+					return fmt.Errorf("Parse conf error: proxy [%s] plugin can't be used with activation or local_tls", proxyClient.Name)
+				}
+				// a process would be spawned for every pooled connection
+				if proxyClient.PoolCount > 0 {
+					return fmt.Errorf("Parse conf error: proxy [%s] plugin can't be used with pool_count", proxyClient.Name)
+				}
+				proxyClient.plugin, err = plugin.Create(tmpStr, section)
+				if err != nil {
+					return fmt.Errorf("Parse conf error: proxy [%s] %v", proxyClient.Name, err)
+				}
+			}
+
 			// configures used in privilege mode
 			if proxyClient.PrivilegeMode == true {
 				if PrivilegeToken == "" {
@@ -198,62 +314,238 @@ func LoadConf(confFile string) (err error) {
 				} else {
 					proxyClient.PrivilegeToken = PrivilegeToken
 				}
+			}
 
-				if proxyClient.Type == "tcp" || proxyClient.Type == "udp" {
-					// remote_port
-					tmpStr, ok = section["remote_port"]
-					if ok {
-						proxyClient.RemotePort, err = strconv.ParseInt(tmpStr, 10, 64)
-						if err != nil {
-							return fmt.Errorf("Parse conf error: proxy [%s] remote_port error", proxyClient.Name)
-						}
-					} else {
-						return fmt.Errorf("Parse conf error: proxy [%s] remote_port not found", proxyClient.Name)
-					}
-				} else if proxyClient.Type == "http" {
-					// custom_domains
-					domainStr, ok := section["custom_domains"]
-					if ok {
-						proxyClient.CustomDomains = strings.Split(domainStr, ",")
-						if len(proxyClient.CustomDomains) == 0 {
-							ok = false
-						} else {
-							for i, domain := range proxyClient.CustomDomains {
-								proxyClient.CustomDomains[i] = strings.ToLower(strings.TrimSpace(domain))
-							}
-						}
-					}
-
-					if !ok && proxyClient.SubDomain == "" {
-						return fmt.Errorf("Parse conf error: proxy [%s] custom_domains and subdomain should set at least one of them when type is http", proxyClient.Name)
-					}
-				} else if proxyClient.Type == "https" {
-					// custom_domains
-					domainStr, ok := section["custom_domains"]
-					if ok {
-						proxyClient.CustomDomains = strings.Split(domainStr, ",")
-						if len(proxyClient.CustomDomains) == 0 {
-							ok = false
-						} else {
-							for i, domain := range proxyClient.CustomDomains {
-								proxyClient.CustomDomains[i] = strings.ToLower(strings.TrimSpace(domain))
-							}
-						}
-					}
-
-					if !ok && proxyClient.SubDomain == "" {
-						return fmt.Errorf("Parse conf error: proxy [%s] custom_domains and subdomain should set at least one of them when type is https", proxyClient.Name)
-					}
-				}
+			// options of each proxy type
+			if err = proxyType.LoadConf(proxyClient, section); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] %v", proxyClient.Name, err)
+			}
+			if err = proxyType.Check(proxyClient); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] %v", proxyClient.Name, err)
 			}
 
 			ProxyClients[proxyClient.Name] = proxyClient
 		}
 	}
 
-	if len(ProxyClients) == 0 {
+	if len(ProxyClients) == 0 && RelayBindPort == 0 {
 		return fmt.Errorf("Parse conf error: no proxy config found")
 	}
 
 	return nil
 }
+
+func loadRelayConf(conf ini.File) (err error) {
+	tmpStr, ok := conf.Get("common", "relay_bind_port")
+	if !ok {
+		return nil
+	}
+	RelayBindPort, err = strconv.ParseInt(tmpStr, 10, 64)
+	if err != nil || RelayBindPort <= 0 {
+		return fmt.Errorf("Parse conf error: relay_bind_port is incorrect")
+	}
+
+	tmpStr, ok = conf.Get("common", "relay_bind_addr")
+	if ok {
+		RelayBindAddr = tmpStr
+	}
+
+	RelayToken, _ = conf.Get("common", "relay_token")
+	if RelayToken == "" {
+		return fmt.Errorf("Parse conf error: relay_token must be set when relay_bind_port is set")
+	}
+	// relayed proxies log in to frps in privilege mode
+	if PrivilegeToken == "" {
+		return fmt.Errorf("Parse conf error: privilege_token must be set when relay_bind_port is set")
+	}
+
+	tmpStr, ok = conf.Get("common", "relay_proxy_prefix")
+	if ok {
+		RelayProxyPrefix = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "relay_allow_types")
+	if ok {
+		RelayAllowTypes = make(map[string]struct{})
+		for _, t := range splitList(tmpStr) {
+			if _, ok := GetProxyType(t); !ok {
+				return fmt.Errorf("Parse conf error: relay_allow_types, type [%s] is not supported", t)
+			}
+			RelayAllowTypes[t] = struct{}{}
+		}
+	}
+
+	tmpStr, ok = conf.Get("common", "relay_allow_ports")
+	if ok {
+		RelayAllowPorts, err = parsePorts(tmpStr)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: relay_allow_ports is incorrect, %v", err)
+		}
+	}
+	return nil
+}
+
+// parse ports like 1000-2000,2001,3000-4000
+func parsePorts(str string) (map[int64]struct{}, error) {
+	ports := make(map[int64]struct{})
+	for _, portRange := range splitList(str) {
+		portArray := strings.Split(portRange, "-")
+		if len(portArray) > 2 {
+			return nil, fmt.Errorf("port range [%s] format error", portRange)
+		}
+		min, err := strconv.ParseInt(strings.TrimSpace(portArray[0]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		max := min
+		if len(portArray) == 2 {
+			max, err = strconv.ParseInt(strings.TrimSpace(portArray[1]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if max < min {
+				return nil, fmt.Errorf("port range [%s] is incorrect", portRange)
+			}
+		}
+		for i := min; i <= max; i++ {
+			ports[i] = struct{}{}
+		}
+	}
+	return ports, nil
+}
+
+// split comma separated values and remove empty ones
+func splitList(str string) []string {
+	res := make([]string, 0)
+	for _, v := range strings.Split(str, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// the local service is started by activate_command or activate_request sent to activate_socket
+// when the first user connection comes and it's not listening
+func loadActivateConf(pc *ProxyClient, section ini.Section) error {
+	command, hasCommand := section["activate_command"]
+	socket, hasSocket := section["activate_socket"]
+	if !hasCommand && !hasSocket {
+		return nil
+	}
+	a := &activate.Activator{
+		Addr:        fmt.Sprintf("%s:%d", pc.LocalIp, pc.LocalPort),
+		Command:     command,
+		Socket:      socket,
+		Request:     section["activate_request"],
+		StopRequest: section["activate_stop_request"],
+		Timeout:     30 * time.Second,
+	}
+	tmpStr, ok := section["activate_timeout"]
+	if ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("activate_timeout error")
+		}
+		a.Timeout = time.Duration(v) * time.Second
+	}
+	tmpStr, ok = section["activate_idle_timeout"]
+	if ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("activate_idle_timeout error")
+		}
+		a.IdleTimeout = time.Duration(v) * time.Second
+	}
+	if err := a.Check(); err != nil {
+		return err
+	}
+	pc.activator = a
+	return nil
+}
+
+// frpc originates tls to the local service if local_tls is true
+func loadLocalTlsConf(pc *ProxyClient, section ini.Section) (err error) {
+	if section["local_tls"] != "true" {
+		return nil
+	}
+	serverName := section["local_tls_server_name"]
+	if serverName == "" {
+		serverName = pc.LocalIp
+	}
+	pc.localTls, err = newTlsClientConfig(serverName, section["local_tls_skip_verify"] == "true", section["local_tls_ca"])
+	if err != nil {
+		return fmt.Errorf("local_tls_ca error: %v", err)
+	}
+
+	certFile, keyFile := section["local_tls_cert"], section["local_tls_key"]
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("load local_tls_cert and local_tls_key error: %v", err)
+		}
+		pc.localTls.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// certificates of servers are verified by caFile if it's not empty, otherwise by system root CAs
+func newTlsClientConfig(serverName string, skipVerify bool, caFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	}
+	if caFile != "" {
+		caPem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificate found in [%s]", caFile)
+		}
+	}
+	return config, nil
+}
+
+func loadTransportConf(conf ini.File) (t conn.Transport, err error) {
+	transportType, ok := conf.Get("common", "transport")
+	if !ok || transportType == "" {
+		transportType = "tcp"
+	}
+
+	switch transportType {
+	case "tcp":
+		t = &conn.TcpTransport{HttpProxy: HttpProxy}
+	case "tls":
+		serverName, _ := conf.Get("common", "transport_tls_server_name")
+		tmpStr, _ := conf.Get("common", "transport_tls_skip_verify")
+		caFile, _ := conf.Get("common", "transport_tls_ca")
+		config, err := newTlsClientConfig(serverName, tmpStr == "true", caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Parse conf error: transport_tls_ca error: %v", err)
+		}
+		t = &conn.TlsTransport{
+			Transport: &conn.TcpTransport{HttpProxy: HttpProxy},
+			Config:    config,
+		}
+	case "websocket":
+		path, _ := conf.Get("common", "transport_websocket_path")
+		t = &conn.WebsocketTransport{
+			Transport: &conn.TcpTransport{HttpProxy: HttpProxy},
+			Path:      path,
+		}
+	case "unix":
+		path, _ := conf.Get("common", "transport_unix_path")
+		if path == "" {
+			return nil, fmt.Errorf("Parse conf error: transport_unix_path must be set if transport is unix")
+		}
+		t = &conn.UnixTransport{Path: path}
+	case "pipe":
+		t = conn.DefaultPipeTransport
+	default:
+		return nil, fmt.Errorf("Parse conf error: transport [%s] is not supported", transportType)
+	}
+	return t, nil
+}
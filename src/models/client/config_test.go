@@ -0,0 +1,57 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestConf(t *testing.T, content string) error {
+	f, err := ioutil.TempFile("", "frpc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(content)
+	f.Close()
+	return LoadConf(f.Name())
+}
+
+func TestLoadConfPoolCount(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(loadTestConf(t, "[common]\n[web]\nlocal_port = 80\npool_count = 1\n"))
+	assert.NoError(loadTestConf(t, "[common]\n[web]\nlocal_port = 80\nactivate_command = /bin/true\n"))
+	assert.Error(loadTestConf(t, "[common]\n[web]\nlocal_port = 80\npool_count = 1\nactivate_command = /bin/true\n"))
+	assert.NoError(loadTestConf(t, "[common]\n[uptime]\nplugin = exec\nplugin_command = /usr/bin/uptime\n"))
+	assert.Error(loadTestConf(t, "[common]\n[uptime]\nplugin = exec\nplugin_command = /usr/bin/uptime\npool_count = 1\n"))
+}
+
+func TestLoadConfActivation(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(loadTestConf(t, "[common]\n[web]\ntype = http\nlocal_port = 80\nactivate_command = /bin/true\n"))
+	assert.Error(loadTestConf(t, "[common]\n[dns]\ntype = udp\nlocal_port = 53\nactivate_command = /bin/true\n"))
+}
+
+func TestLoadConfLocalTls(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(loadTestConf(t, "[common]\n[web]\nlocal_port = 443\nlocal_tls = true\n"))
+	assert.Error(loadTestConf(t, "[common]\n[web]\nlocal_tls = true\nplugin = exec\nplugin_command = /usr/bin/uptime\n"))
+	assert.Error(loadTestConf(t, "[common]\n[dns]\ntype = udp\nlocal_port = 53\nlocal_tls = true\n"))
+	assert.Error(loadTestConf(t, "[common]\n[dns]\ntype = udp\nplugin = exec\nplugin_command = /usr/bin/uptime\n"))
+}
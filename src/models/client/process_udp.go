@@ -15,8 +15,8 @@
 package client
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net"
 	"sync"
 	"time"
@@ -27,8 +27,12 @@ import (
 )
 
 type UdpProcesser struct {
+	// udp packets from local services are sent by the latest tcp connection
 	tcpConn *conn.Conn
-	closeCh chan struct{}
+
+	// ctx is canceled when the processer is closed
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	localAddr string
 
@@ -39,41 +43,47 @@ type UdpProcesser struct {
 	tcpConnMutex  sync.RWMutex
 }
 
-func NewUdpProcesser(c *conn.Conn, localIp string, localPort int64) *UdpProcesser {
+func NewUdpProcesser(localIp string, localPort int64) *UdpProcesser {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &UdpProcesser{
-		tcpConn:       c,
-		closeCh:       make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
 		localAddr:     fmt.Sprintf("%s:%d", localIp, localPort),
 		localUdpConns: make(map[string]*net.UDPConn),
 	}
 }
 
-func (up *UdpProcesser) UpdateTcpConn(c *conn.Conn) {
+// Serve forwards udp packets from frps by c to local services,
+// it blocks until c is broken or the processer is closed
+func (up *UdpProcesser) Serve(c *conn.Conn) {
 	up.tcpConnMutex.Lock()
-	defer up.tcpConnMutex.Unlock()
 	up.tcpConn = c
+	up.tcpConnMutex.Unlock()
+
+	stop := conn.CloseWhenDone(up.ctx, c)
+	defer stop()
+	up.ReadLoop(c)
 }
 
-func (up *UdpProcesser) Run() {
-	go up.ReadLoop()
+// Close stops Serve and goroutines forwarding packets from local services
+func (up *UdpProcesser) Close() {
+	up.cancel()
+	up.mutex.Lock()
+	defer up.mutex.Unlock()
+	for addr, c := range up.localUdpConns {
+		c.Close()
+		delete(up.localUdpConns, addr)
+	}
 }
 
-func (up *UdpProcesser) ReadLoop() {
-	var (
-		buf string
-		err error
-	)
+func (up *UdpProcesser) ReadLoop(c *conn.Conn) {
 	for {
 		udpPacket := &msg.UdpPacket{}
 
 		// read udp package from frps
-		buf, err = up.tcpConn.ReadLine()
+		buf, err := c.ReadLine()
 		if err != nil {
-			if err == io.EOF {
-				return
-			} else {
-				continue
-			}
+			return
 		}
 		err = udpPacket.UnPack([]byte(buf))
 		if err != nil {
@@ -92,7 +102,10 @@ func (up *UdpProcesser) ReadLoop() {
 				continue
 			}
 
-			up.SetUdpConn(udpPacket.SrcStr, sendConn)
+			if !up.SetUdpConn(udpPacket.SrcStr, sendConn) {
+				sendConn.Close()
+				return
+			}
 		}
 
 		_, err = sendConn.Write(udpPacket.Content)
@@ -137,10 +150,15 @@ func (up *UdpProcesser) GetUdpConn(addr string) (singleConn *net.UDPConn, ok boo
 	return
 }
 
-func (up *UdpProcesser) SetUdpConn(addr string, conn *net.UDPConn) {
+// SetUdpConn returns false if the processer is closed
+func (up *UdpProcesser) SetUdpConn(addr string, conn *net.UDPConn) bool {
 	up.mutex.Lock()
 	defer up.mutex.Unlock()
+	if up.ctx.Err() != nil {
+		return false
+	}
 	up.localUdpConns[addr] = conn
+	return true
 }
 
 func (up *UdpProcesser) RemoveUdpConn(addr string) {
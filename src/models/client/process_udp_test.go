@@ -0,0 +1,93 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+)
+
+// wait until goroutines started in the test exit
+func checkGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("%d goroutines are leaked\n%s", runtime.NumGoroutine()-n, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUdpProcesser(t *testing.T) {
+	assert := assert.New(t)
+
+	// local udp service echoing packets
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(err)
+	defer local.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := local.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			local.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	n := runtime.NumGoroutine()
+
+	up := NewUdpProcesser("127.0.0.1", int64(local.LocalAddr().(*net.UDPAddr).Port))
+	serve := func() (*conn.Conn, chan struct{}) {
+		tunnel, frpsConn := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			up.Serve(conn.NewConn(tunnel))
+			close(done)
+		}()
+		return conn.NewConn(frpsConn), done
+	}
+
+	// Serve returns after the tunnel is broken
+	frpsConn, done := serve()
+	frpsConn.Close()
+	<-done
+
+	// packets are forwarded by the new tunnel
+	frpsConn, done = serve()
+	src, _ := net.ResolveUDPAddr("udp", "1.1.1.1:1000")
+	dst, _ := net.ResolveUDPAddr("udp", "2.2.2.2:2000")
+	frpsConn.WriteString(string(msg.NewUdpPacket([]byte("hello"), src, dst).Pack()) + "\n")
+	buf, err := frpsConn.ReadLine()
+	assert.NoError(err)
+	packet := &msg.UdpPacket{}
+	assert.NoError(packet.UnPack([]byte(buf)))
+	assert.Equal("hello", string(packet.Content))
+	assert.Equal(src.String(), packet.Dst.String())
+
+	// all goroutines stop after closed
+	up.Close()
+	<-done
+	checkGoroutines(t, n)
+}
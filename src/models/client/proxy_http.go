@@ -0,0 +1,82 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/utils/vhost"
+)
+
+func init() {
+	RegisterProxyType("http", &httpProxy{})
+}
+
+type httpProxy struct{}
+
+func (t *httpProxy) LoadConf(pc *ProxyClient, section ini.Section) error {
+	var tmpStr string
+	var ok bool
+	// host_header_rewrite
+	tmpStr, ok = section["host_header_rewrite"]
+	if ok {
+		pc.HostHeaderRewrite = tmpStr
+	}
+	// http_user
+	tmpStr, ok = section["http_user"]
+	if ok {
+		pc.HttpUserName = tmpStr
+	}
+	// http_pwd
+	tmpStr, ok = section["http_pwd"]
+	if ok {
+		pc.HttpPassWord = tmpStr
+	}
+	// oidc_login
+	tmpStr, ok = section["oidc_login"]
+	if ok && tmpStr == "true" {
+		pc.OidcLogin = true
+	}
+	// oidc_allowed_emails
+	tmpStr, ok = section["oidc_allowed_emails"]
+	if ok {
+		pc.OidcAllowedEmails = splitList(tmpStr)
+	}
+	// oidc_allowed_groups
+	tmpStr, ok = section["oidc_allowed_groups"]
+	if ok {
+		pc.OidcAllowedGroups = splitList(tmpStr)
+	}
+	// http_compression
+	tmpStr, ok = section["http_compression"]
+	if ok {
+		pc.HttpCompression = splitList(tmpStr)
+		if err := vhost.CheckEncodings(pc.HttpCompression); err != nil {
+			return err
+		}
+	}
+	loadSubDomain(pc, section)
+	return loadCustomDomains(pc, section)
+}
+
+func (t *httpProxy) Check(pc *ProxyClient) error {
+	return nil
+}
+
+func (t *httpProxy) OnStart(pc *ProxyClient) {}
+
+func (t *httpProxy) HandleUserConn(pc *ProxyClient, traceparent string) {
+	go pc.StartTunnel(pc.GetServerAddr(), traceparent)
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	ini "github.com/vaughan0/go-ini"
+)
+
+func init() {
+	RegisterProxyType("https", &httpsProxy{})
+}
+
+type httpsProxy struct{}
+
+func (t *httpsProxy) LoadConf(pc *ProxyClient, section ini.Section) error {
+	loadSubDomain(pc, section)
+	return loadCustomDomains(pc, section)
+}
+
+func (t *httpsProxy) Check(pc *ProxyClient) error {
+	return nil
+}
+
+func (t *httpsProxy) OnStart(pc *ProxyClient) {}
+
+func (t *httpsProxy) HandleUserConn(pc *ProxyClient, traceparent string) {
+	go pc.StartTunnel(pc.GetServerAddr(), traceparent)
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	ini "github.com/vaughan0/go-ini"
+)
+
+// ProxyType implements all behaviours which are different between proxy types,
+// a new type is added by calling RegisterProxyType in init function of its own file
+type ProxyType interface {
+	// parse type specific options of a proxy section in frpc.ini, including options used in privilege mode
+	LoadConf(pc *ProxyClient, section ini.Section) error
+
+	// check options shared by all types after LoadConf, some of them are not supported by every type
+	Check(pc *ProxyClient) error
+
+	// called after the proxy is started by frps, it must not block
+	OnStart(pc *ProxyClient)
+
+	// called when frps notices a new user connection, it must not block,
+	// traceparent is the trace context of the user connection in frps, empty if it's not traced
+	HandleUserConn(pc *ProxyClient, traceparent string)
+}
+
+var (
+	proxyTypes      map[string]ProxyType = make(map[string]ProxyType)
+	proxyTypesMutex sync.RWMutex
+)
+
+func RegisterProxyType(name string, t ProxyType) {
+	proxyTypesMutex.Lock()
+	defer proxyTypesMutex.Unlock()
+	if _, ok := proxyTypes[name]; ok {
+		panic(fmt.Sprintf("proxy type [%s] is already registered", name))
+	}
+	proxyTypes[name] = t
+}
+
+func GetProxyType(name string) (t ProxyType, ok bool) {
+	proxyTypesMutex.RLock()
+	defer proxyTypesMutex.RUnlock()
+	t, ok = proxyTypes[name]
+	return
+}
+
+// OnStart is called after the proxy is started by frps
+func (pc *ProxyClient) OnStart() {
+	if t, ok := GetProxyType(pc.Type); ok {
+		t.OnStart(pc)
+	}
+}
+
+// HandleUserConn is called when frps notices a new user connection
+func (pc *ProxyClient) HandleUserConn(traceparent string) {
+	if t, ok := GetProxyType(pc.Type); ok {
+		t.HandleUserConn(pc, traceparent)
+	}
+}
+
+// proxy types listening on remote_port of frps implement it
+type remotePortProxyType interface {
+	remotePort()
+}
+
+// usesRemotePort returns true if proxies of the type listen on remote_port of frps
+func usesRemotePort(typeName string) bool {
+	t, ok := GetProxyType(typeName)
+	if !ok {
+		return false
+	}
+	_, ok = t.(remotePortProxyType)
+	return ok
+}
+
+// remote_port is used by tcp and udp proxies in privilege mode
+func loadRemotePort(pc *ProxyClient, section ini.Section) (err error) {
+	if !pc.PrivilegeMode {
+		return nil
+	}
+	tmpStr, ok := section["remote_port"]
+	if !ok {
+		return fmt.Errorf("remote_port not found")
+	}
+	pc.RemotePort, err = strconv.ParseInt(tmpStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("remote_port error")
+	}
+	return nil
+}
+
+// subdomain is used by http and https proxies, the full domain is composed by frps with its subdomain_host
+func loadSubDomain(pc *ProxyClient, section ini.Section) {
+	tmpStr, ok := section["subdomain"]
+	if ok {
+		pc.SubDomain = tmpStr
+	}
+}
+
+// custom_domains is used by vhost proxies in privilege mode
+func loadCustomDomains(pc *ProxyClient, section ini.Section) error {
+	if !pc.PrivilegeMode {
+		return nil
+	}
+	domainStr, ok := section["custom_domains"]
+	if ok {
+		pc.CustomDomains = strings.Split(domainStr, ",")
+		for i, domain := range pc.CustomDomains {
+			pc.CustomDomains[i] = strings.ToLower(strings.TrimSpace(domain))
+		}
+	}
+
+	if !ok && pc.SubDomain == "" {
+		return fmt.Errorf("custom_domains and subdomain should set at least one of them when type is %s", pc.Type)
+	}
+	return nil
+}
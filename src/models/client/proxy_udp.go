@@ -0,0 +1,55 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+
+	ini "github.com/vaughan0/go-ini"
+)
+
+func init() {
+	RegisterProxyType("udp", &udpProxy{})
+}
+
+type udpProxy struct{}
+
+func (t *udpProxy) remotePort() {}
+
+func (t *udpProxy) LoadConf(pc *ProxyClient, section ini.Section) error {
+	return loadRemotePort(pc, section)
+}
+
+// udp packets are sent to the local service directly, options of tcp local services are not supported
+func (t *udpProxy) Check(pc *ProxyClient) error {
+	switch {
+	case pc.activator != nil:
+		return fmt.Errorf("activation is not supported by udp proxies")
+	case pc.localTls != nil:
+		return fmt.Errorf("local_tls is not supported by udp proxies")
+	case pc.plugin != nil:
+		return fmt.Errorf("plugin is not supported by udp proxies")
+	}
+	return nil
+}
+
+// we only need one udp work connection
+// all udp messages will be forwarded throngh this connection
+func (t *udpProxy) OnStart(pc *ProxyClient) {
+	go pc.StartUdpTunnelOnce()
+}
+
+// frps doesn't notice user connections for udp proxies
+func (t *udpProxy) HandleUserConn(pc *ProxyClient, traceparent string) {}
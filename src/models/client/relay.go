@@ -0,0 +1,273 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/src/models/config"
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/pcrypto"
+)
+
+const (
+	relayLoginTimeout = 10 * time.Second
+	relayAuthTimeout  = 15 * 60 // seconds
+)
+
+// a proxy of downstream frpc which is logged in to frps by this frpc
+type relayedProxy struct {
+	name          string // name in frps
+	serverAddr    string // work connections are connected to the same frps as the control connection
+	useEncryption bool
+	useGzip       bool
+	halfClose     bool // both downstream frpc and frps support half-close
+}
+
+var (
+	relayedProxies = make(map[string]*relayedProxy) // key is the proxy name of downstream frpc
+	relayedMutex   sync.RWMutex
+)
+
+// RunRelay accepts connections from downstream frpc, it returns after listening
+func RunRelay(addr string, port int64) error {
+	l, err := conn.Listen(addr, port)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleRelayConn(c)
+		}
+	}()
+	log.Info("Relay for downstream frpc is listening on [%s:%d]", addr, port)
+	return nil
+}
+
+func handleRelayConn(c *conn.Conn) {
+	c.SetReadDeadline(time.Now().Add(relayLoginTimeout))
+	buf, err := c.ReadLine()
+	if err != nil {
+		log.Warn("Relay read login message from [%s] error, %v", c.GetRemoteAddr(), err)
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+
+	req := &msg.ControlReq{}
+	if err := json.Unmarshal([]byte(buf), &req); err != nil {
+		log.Warn("Relay parse login message from [%s] error, %v", c.GetRemoteAddr(), err)
+		c.Close()
+		return
+	}
+	if err := checkRelayReq(req); err != nil {
+		log.Warn("ProxyName [%s], relay login from [%s] is rejected, %v", req.ProxyName, c.GetRemoteAddr(), err)
+		if req.Type == consts.NewCtlConn {
+			writeRelayRes(c, err)
+		}
+		c.Close()
+		return
+	}
+
+	switch req.Type {
+	case consts.NewCtlConn:
+		relayControl(c, req)
+	case consts.NewWorkConn, consts.NewWorkConnUdp:
+		relayWorkConn(c, req)
+	default:
+		log.Warn("ProxyName [%s], unsupport login message type [%d] for relay", req.ProxyName, req.Type)
+		c.Close()
+	}
+}
+
+// downstream frpc use privilege mode with RelayToken, proxies must be allowed by the policy of relay
+func checkRelayReq(req *msg.ControlReq) error {
+	if !req.PrivilegeMode {
+		return fmt.Errorf("only proxies in privilege mode can be relayed")
+	}
+	if time.Now().Unix()-req.Timestamp > relayAuthTimeout {
+		return fmt.Errorf("authorization timeout")
+	}
+	if req.PrivilegeKey != pcrypto.GetAuthKey(req.ProxyName+RelayToken+fmt.Sprintf("%d", req.Timestamp)) {
+		return fmt.Errorf("authorization failed")
+	}
+	if req.Type != consts.NewCtlConn {
+		return nil
+	}
+
+	if RelayAllowTypes != nil {
+		if _, ok := RelayAllowTypes[req.ProxyType]; !ok {
+			return fmt.Errorf("type [%s] is not allowed by relay", req.ProxyType)
+		}
+	}
+	if RelayAllowPorts != nil && usesRemotePort(req.ProxyType) {
+		if _, ok := RelayAllowPorts[req.RemotePort]; !ok {
+			return fmt.Errorf("remote port [%d] is not allowed by relay", req.RemotePort)
+		}
+	}
+	return nil
+}
+
+func writeRelayRes(c *conn.Conn, err error) {
+	res := &msg.ControlRes{
+		Type: consts.NewCtlConnRes,
+		Code: 1,
+		Msg:  fmt.Sprintf("%v", err),
+	}
+	buf, _ := json.Marshal(res)
+	c.WriteString(string(buf) + "\n")
+}
+
+// log in to frps for the proxy of downstream frpc, the name is prefixed and the privilege key is replaced
+func dialUpstream(req *msg.ControlReq, addrs []string) (c *conn.Conn, serverAddr string, err error) {
+	for _, serverAddr = range addrs {
+		c, err = conn.ConnectServerByTransport(Transport, serverAddr)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	upReq := *req
+	upReq.ProxyName = RelayProxyPrefix + req.ProxyName
+	upReq.AuthKey = ""
+	upReq.Timestamp = time.Now().Unix()
+	upReq.PrivilegeKey = pcrypto.GetAuthKey(upReq.ProxyName + PrivilegeToken + fmt.Sprintf("%d", upReq.Timestamp))
+	buf, _ := json.Marshal(&upReq)
+	if err = c.WriteString(string(buf) + "\n"); err != nil {
+		c.Close()
+		return nil, "", err
+	}
+	return c, serverAddr, nil
+}
+
+// relay the control connection of downstream frpc, messages are passed as they are after login
+func relayControl(c *conn.Conn, req *msg.ControlReq) {
+	defer c.Close()
+	addrs, err := GetServerAddrs()
+	if err != nil {
+		writeRelayRes(c, fmt.Errorf("get server address error, %v", err))
+		return
+	}
+	up, serverAddr, err := dialUpstream(req, addrs)
+	if err != nil {
+		log.Warn("ProxyName [%s], relay connect to server error, %v", req.ProxyName, err)
+		writeRelayRes(c, fmt.Errorf("relay connect to server error, %v", err))
+		return
+	}
+	defer up.Close()
+
+	// the login response of frps is passed to downstream frpc
+	buf, err := up.ReadLine()
+	if err != nil {
+		writeRelayRes(c, fmt.Errorf("relay read from server error, %v", err))
+		return
+	}
+	if err = c.WriteString(buf); err != nil {
+		return
+	}
+	res := &msg.ControlRes{}
+	if err = json.Unmarshal([]byte(buf), res); err != nil || res.Code != 0 {
+		log.Warn("ProxyName [%s], relay login to server [%s] failed, %s", req.ProxyName, serverAddr, res.Msg)
+		return
+	}
+
+	p := &relayedProxy{
+		name:          RelayProxyPrefix + req.ProxyName,
+		serverAddr:    serverAddr,
+		useEncryption: req.UseEncryption,
+		useGzip:       req.UseGzip,
+		halfClose:     res.HalfClose,
+	}
+	relayedMutex.Lock()
+	relayedProxies[req.ProxyName] = p
+	relayedMutex.Unlock()
+	defer func() {
+		relayedMutex.Lock()
+		if relayedProxies[req.ProxyName] == p {
+			delete(relayedProxies, req.ProxyName)
+		}
+		relayedMutex.Unlock()
+	}()
+
+	log.Info("ProxyName [%s], relayed from [%s] to server [%s] as [%s]", req.ProxyName, c.GetRemoteAddr(), serverAddr, p.name)
+	joinRaw(c, up)
+	log.Info("ProxyName [%s], relayed control connection is closed", req.ProxyName)
+}
+
+// relay work connections to the frps which the control connection is connected to
+func relayWorkConn(c *conn.Conn, req *msg.ControlReq) {
+	relayedMutex.RLock()
+	p, ok := relayedProxies[req.ProxyName]
+	relayedMutex.RUnlock()
+	if !ok {
+		log.Warn("ProxyName [%s], work connection is not relayed, the proxy isn't logged in", req.ProxyName)
+		c.Close()
+		return
+	}
+
+	up, _, err := dialUpstream(req, []string{p.serverAddr})
+	if err != nil {
+		log.Warn("ProxyName [%s], relay work connection to server error, %v", req.ProxyName, err)
+		c.Close()
+		return
+	}
+
+	// udp packets aren't encrypted
+	if req.Type == consts.NewWorkConnUdp {
+		joinRaw(c, up)
+		return
+	}
+	downConf := config.BaseConf{
+		Name:           req.ProxyName,
+		UseEncryption:  p.useEncryption,
+		UseGzip:        p.useGzip,
+		PrivilegeMode:  true,
+		PrivilegeToken: RelayToken,
+		HalfClose:      p.halfClose,
+	}
+	upConf := downConf
+	upConf.Name = p.name
+	upConf.PrivilegeToken = PrivilegeToken
+	msg.JoinTunnels(c, downConf, up, upConf)
+}
+
+// bytes are copied as they are, both connections are closed when one direction ends
+func joinRaw(c1 *conn.Conn, c2 *conn.Conn) {
+	var wait sync.WaitGroup
+	pipe := func(to *conn.Conn, from *conn.Conn) {
+		defer wait.Done()
+		defer to.Close()
+		defer from.Close()
+		io.Copy(to, from)
+	}
+	wait.Add(2)
+	go pipe(c1, c2)
+	go pipe(c2, c1)
+	wait.Wait()
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/pcrypto"
+)
+
+func TestCheckRelayReq(t *testing.T) {
+	assert := assert.New(t)
+	RelayToken = "relay"
+	RelayAllowTypes = map[string]struct{}{"tcp": {}}
+	var err error
+	RelayAllowPorts, err = parsePorts("6000-6002, 6010")
+	assert.NoError(err)
+	defer func() {
+		RelayToken, RelayAllowTypes, RelayAllowPorts = "", nil, nil
+	}()
+
+	newReq := func(proxyType string, port int64, token string) *msg.ControlReq {
+		now := time.Now().Unix()
+		return &msg.ControlReq{
+			Type:          consts.NewCtlConn,
+			ProxyName:     "plc",
+			ProxyType:     proxyType,
+			RemotePort:    port,
+			PrivilegeMode: true,
+			PrivilegeKey:  pcrypto.GetAuthKey("plc" + token + fmt.Sprintf("%d", now)),
+			Timestamp:     now,
+		}
+	}
+	assert.NoError(checkRelayReq(newReq("tcp", 6001, "relay")))
+	assert.NoError(checkRelayReq(newReq("tcp", 6010, "relay")))
+	assert.Error(checkRelayReq(newReq("tcp", 6003, "relay")))
+	assert.Error(checkRelayReq(newReq("http", 0, "relay")))
+	assert.Error(checkRelayReq(newReq("tcp", 6001, "wrong")))
+
+	req := newReq("tcp", 6001, "relay")
+	req.PrivilegeMode = false
+	assert.Error(checkRelayReq(req))
+
+	// types and ports are only checked for control connections
+	req = newReq("http", 0, "relay")
+	req.Type = consts.NewWorkConn
+	assert.NoError(checkRelayReq(req))
+
+	// ports are only checked for types listening on remote_port
+	RelayAllowTypes = nil
+	assert.NoError(checkRelayReq(newReq("http", 0, "relay")))
+	assert.NoError(checkRelayReq(newReq("udp", 6002, "relay")))
+	assert.Error(checkRelayReq(newReq("udp", 6003, "relay")))
+
+	_, err = parsePorts("6002-6000")
+	assert.Error(err)
+}
@@ -27,4 +27,16 @@ type BaseConf struct {
 	HttpUserName      string
 	HttpPassWord      string
 	SubDomain         string
+
+	// only for http, users must login by OpenID Connect provider of frps
+	// if emails or groups are set, only these users are allowed
+	OidcLogin         bool
+	OidcAllowedEmails []string
+	OidcAllowedGroups []string
+
+	// only for http, responses are compressed by frps with the first encoding accepted by users
+	HttpCompression []string
+
+	// EOF is passed through tunnels by half-close if both frpc and frps support it, it's negotiated at login
+	HalfClose bool
 }
@@ -19,6 +19,7 @@ const (
 	Idle = iota
 	Working
 	Closed
+	Reconnecting
 )
 
 var (
@@ -26,6 +27,7 @@ var (
 		"idle",
 		"working",
 		"closed",
+		"reconnecting",
 	}
 )
 
@@ -38,4 +40,15 @@ const (
 	HeartbeatReq
 	HeartbeatRes
 	NewWorkConnUdp
+	NewEdgeConn
+)
+
+// code of NewCtlConnRes if use_encryption or use_gzip is denied by the policy of frps,
+// it's 1 for other login errors
+const LoginPolicyDenied = 2
+
+// stream types of links between edge nodes and the core frps
+const (
+	EdgeSync = iota
+	EdgeUserConn
 )
@@ -0,0 +1,102 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// flow of one connection is added to metrics if it exceeds flowFlushBytes or every flowFlushInterval,
+// so rates of long-lived connections are kept up to date
+const flowFlushBytes = 1024 * 1024
+
+var flowFlushInterval = rateTickInterval
+
+// FlowRecorder records flow of one user connection in batches, it's safe for concurrent use
+type FlowRecorder struct {
+	// pending bytes, accessed atomically
+	in  int64
+	out int64
+
+	proxyName string
+	srcIp     string // empty if top talkers aren't recorded
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFlowRecorder(proxyName string, srcIp string) *FlowRecorder {
+	r := &FlowRecorder{
+		proxyName: proxyName,
+		srcIp:     srcIp,
+		closeCh:   make(chan struct{}),
+	}
+	go r.flushLoop()
+	return r
+}
+
+func (r *FlowRecorder) flushLoop() {
+	ticker := time.NewTicker(flowFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.flushIn()
+			r.flushOut()
+		case <-r.closeCh:
+			return
+		}
+	}
+}
+
+func (r *FlowRecorder) AddIn(n int64) {
+	if atomic.AddInt64(&r.in, n) >= flowFlushBytes {
+		r.flushIn()
+	}
+}
+
+func (r *FlowRecorder) AddOut(n int64) {
+	if atomic.AddInt64(&r.out, n) >= flowFlushBytes {
+		r.flushOut()
+	}
+}
+
+func (r *FlowRecorder) flushIn() {
+	if n := atomic.SwapInt64(&r.in, 0); n > 0 {
+		AddFlowIn(r.proxyName, n)
+		if r.srcIp != "" {
+			AddTalkerFlow(r.proxyName, r.srcIp, n)
+		}
+	}
+}
+
+func (r *FlowRecorder) flushOut() {
+	if n := atomic.SwapInt64(&r.out, 0); n > 0 {
+		AddFlowOut(r.proxyName, n)
+		if r.srcIp != "" {
+			AddTalkerFlow(r.proxyName, r.srcIp, n)
+		}
+	}
+}
+
+// Close stops flushing periodically and adds pending bytes to metrics
+func (r *FlowRecorder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+	})
+	r.flushIn()
+	r.flushOut()
+}
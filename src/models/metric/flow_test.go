@@ -0,0 +1,65 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getDailyFlow(proxyName string) (in int64, out int64) {
+	m := GetProxyMetrics(proxyName)
+	if m == nil || len(m.Daily) == 0 {
+		return 0, 0
+	}
+	daily := m.Daily[len(m.Daily)-1]
+	return daily.FlowIn, daily.FlowOut
+}
+
+func TestFlowRecorder(t *testing.T) {
+	assert := assert.New(t)
+	SetProxyInfo("flow_test", "tcp", "", false, false, false)
+	interval := flowFlushInterval
+	flowFlushInterval = 50 * time.Millisecond
+	defer func() {
+		flowFlushInterval = interval
+		smMutex.Lock()
+		delete(ServerMetricInfoMap, "flow_test")
+		smMutex.Unlock()
+	}()
+
+	r := NewFlowRecorder("flow_test", "")
+	r.AddIn(100)
+	r.AddOut(flowFlushBytes)
+	in, out := getDailyFlow("flow_test")
+	assert.Equal(int64(0), in)
+	assert.Equal(int64(flowFlushBytes), out)
+
+	// pending bytes of idle connections are flushed periodically
+	deadline := time.Now().Add(3 * time.Second)
+	for in == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		in, _ = getDailyFlow("flow_test")
+	}
+	assert.Equal(int64(100), in)
+
+	// the rest are flushed when it's closed
+	r.AddIn(10)
+	r.Close()
+	in, _ = getDailyFlow("flow_test")
+	assert.Equal(int64(110), in)
+}
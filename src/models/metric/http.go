@@ -0,0 +1,189 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// latency percentiles are calculated from the latest samples of each domain
+	HttpLatencySamples int = 1000
+	HttpMetricInfoMap  map[string]*HttpMetric
+	hmMutex            sync.RWMutex
+)
+
+// HttpMetric is statistics of http requests for one domain, counted since frps started
+type HttpMetric struct {
+	Domain        string `json:"domain"`
+	Requests      int64  `json:"requests"`
+	Status1xx     int64  `json:"status_1xx"`
+	Status2xx     int64  `json:"status_2xx"`
+	Status3xx     int64  `json:"status_3xx"`
+	Status4xx     int64  `json:"status_4xx"`
+	Status5xx     int64  `json:"status_5xx"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+
+	// time to response header in milliseconds
+	LatencyP50 float64 `json:"latency_p50"`
+	LatencyP90 float64 `json:"latency_p90"`
+	LatencyP99 float64 `json:"latency_p99"`
+
+	latencies []time.Duration // ring buffer
+	next      int
+	mutex     sync.RWMutex
+}
+
+type HttpMetricList []*HttpMetric
+
+func (l HttpMetricList) Len() int           { return len(l) }
+func (l HttpMetricList) Less(i, j int) bool { return l[i].Domain < l[j].Domain }
+func (l HttpMetricList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+func init() {
+	HttpMetricInfoMap = make(map[string]*HttpMetric)
+}
+
+func (h *HttpMetric) clone() *HttpMetric {
+	copy := &HttpMetric{
+		Domain:        h.Domain,
+		Requests:      h.Requests,
+		Status1xx:     h.Status1xx,
+		Status2xx:     h.Status2xx,
+		Status3xx:     h.Status3xx,
+		Status4xx:     h.Status4xx,
+		Status5xx:     h.Status5xx,
+		RequestBytes:  h.RequestBytes,
+		ResponseBytes: h.ResponseBytes,
+	}
+
+	samples := make([]time.Duration, len(h.latencies))
+	for i := range h.latencies {
+		samples[i] = h.latencies[i]
+	}
+	sort.Sort(durationList(samples))
+	copy.LatencyP50 = percentile(samples, 0.5)
+	copy.LatencyP90 = percentile(samples, 0.9)
+	copy.LatencyP99 = percentile(samples, 0.99)
+	return copy
+}
+
+type durationList []time.Duration
+
+func (l durationList) Len() int           { return len(l) }
+func (l durationList) Less(i, j int) bool { return l[i] < l[j] }
+func (l durationList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// samples must be sorted, return value is in milliseconds
+func percentile(samples []time.Duration, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	index := int(float64(len(samples))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(samples) {
+		index = len(samples) - 1
+	}
+	return float64(samples[index]) / float64(time.Millisecond)
+}
+
+func GetAllHttpMetrics() []*HttpMetric {
+	result := make(HttpMetricList, 0)
+	hmMutex.RLock()
+	for _, metric := range HttpMetricInfoMap {
+		metric.mutex.RLock()
+		tmpMetric := metric.clone()
+		metric.mutex.RUnlock()
+		result = append(result, tmpMetric)
+	}
+	hmMutex.RUnlock()
+
+	// sort for result by domain
+	sort.Sort(result)
+	return result
+}
+
+// AddHttpRequest is used as vhost.HttpStatsFunc
+func AddHttpRequest(domain string, statusCode int, requestBytes, responseBytes int64, latency time.Duration) {
+	hmMutex.RLock()
+	metric, ok := HttpMetricInfoMap[domain]
+	hmMutex.RUnlock()
+	if !ok {
+		hmMutex.Lock()
+		metric, ok = HttpMetricInfoMap[domain]
+		if !ok {
+			metric = &HttpMetric{
+				Domain:    domain,
+				latencies: make([]time.Duration, 0),
+			}
+			HttpMetricInfoMap[domain] = metric
+		}
+		hmMutex.Unlock()
+	}
+
+	metric.mutex.Lock()
+	metric.Requests++
+	switch statusCode / 100 {
+	case 1:
+		metric.Status1xx++
+	case 2:
+		metric.Status2xx++
+	case 3:
+		metric.Status3xx++
+	case 4:
+		metric.Status4xx++
+	case 5:
+		metric.Status5xx++
+	}
+	metric.RequestBytes += requestBytes
+	metric.ResponseBytes += responseBytes
+	if len(metric.latencies) < HttpLatencySamples {
+		metric.latencies = append(metric.latencies, latency)
+	} else {
+		metric.latencies[metric.next] = latency
+		metric.next = (metric.next + 1) % len(metric.latencies)
+	}
+	metric.mutex.Unlock()
+}
+
+// ImportHttpMetrics adds request counters exported by another frps, latency samples are not exported
+func ImportHttpMetrics(metrics []*HttpMetric) {
+	hmMutex.Lock()
+	defer hmMutex.Unlock()
+	for _, m := range metrics {
+		metric, ok := HttpMetricInfoMap[m.Domain]
+		if !ok {
+			metric = &HttpMetric{
+				Domain:    m.Domain,
+				latencies: make([]time.Duration, 0),
+			}
+			HttpMetricInfoMap[m.Domain] = metric
+		}
+		metric.mutex.Lock()
+		metric.Requests += m.Requests
+		metric.Status1xx += m.Status1xx
+		metric.Status2xx += m.Status2xx
+		metric.Status3xx += m.Status3xx
+		metric.Status4xx += m.Status4xx
+		metric.Status5xx += m.Status5xx
+		metric.RequestBytes += m.RequestBytes
+		metric.ResponseBytes += m.ResponseBytes
+		metric.mutex.Unlock()
+	}
+}
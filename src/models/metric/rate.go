@@ -0,0 +1,104 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	rateTickInterval = 5 * time.Second
+	rateWindow       = time.Minute
+)
+
+var (
+	rateAlpha = 1 - math.Exp(-rateTickInterval.Seconds()/rateWindow.Seconds())
+
+	globalRateIn    Rate
+	globalRateOut   Rate
+	globalRateConns Rate
+	globalRateMutex sync.Mutex
+)
+
+// Rates are exponentially weighted moving rates per second over about one minute
+type Rates struct {
+	BytesIn  float64 `json:"bytes_in"`
+	BytesOut float64 `json:"bytes_out"`
+	Conns    float64 `json:"conns"`
+}
+
+// Rate is updated every rateTickInterval lazily, it isn't safe for concurrent use
+type Rate struct {
+	uncounted int64
+	rate      float64
+	started   bool
+	lastTick  time.Time
+}
+
+func (r *Rate) Add(n int64, now time.Time) {
+	r.tick(now)
+	r.uncounted += n
+}
+
+// Value returns the rate at now without changing r
+func (r Rate) Value(now time.Time) float64 {
+	r.tick(now)
+	return r.rate
+}
+
+func (r *Rate) tick(now time.Time) {
+	if r.lastTick.IsZero() {
+		r.lastTick = now
+		return
+	}
+	ticks := int64(now.Sub(r.lastTick) / rateTickInterval)
+	if ticks <= 0 {
+		return
+	}
+	r.lastTick = r.lastTick.Add(time.Duration(ticks) * rateTickInterval)
+
+	instant := float64(r.uncounted) / rateTickInterval.Seconds()
+	r.uncounted = 0
+	if r.started {
+		r.rate += rateAlpha * (instant - r.rate)
+	} else {
+		r.rate = instant
+		r.started = true
+	}
+	// nothing was counted in the rest ticks
+	if ticks > 1 {
+		r.rate *= math.Pow(1-rateAlpha, float64(ticks-1))
+	}
+}
+
+// GetGlobalRates returns rates of all proxies
+func GetGlobalRates() Rates {
+	now := time.Now()
+	globalRateMutex.Lock()
+	defer globalRateMutex.Unlock()
+	return Rates{
+		BytesIn:  globalRateIn.Value(now),
+		BytesOut: globalRateOut.Value(now),
+		Conns:    globalRateConns.Value(now),
+	}
+}
+
+func addGlobalRate(r *Rate, n int64, now time.Time) {
+	globalRateMutex.Lock()
+	r.Add(n, now)
+	globalRateMutex.Unlock()
+}
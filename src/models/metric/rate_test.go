@@ -0,0 +1,79 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRate(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Now()
+	r := &Rate{}
+	assert.Equal(0.0, r.Value(start))
+
+	// 100 bytes per second
+	for i := 0; i < 12; i++ {
+		r.Add(500, start.Add(time.Duration(i)*rateTickInterval))
+	}
+	now := start.Add(12 * rateTickInterval)
+	assert.InDelta(100.0, r.Value(now), 0.001)
+	// Value doesn't change r
+	assert.InDelta(100.0, r.Value(now), 0.001)
+
+	// decays without new counts
+	later := now.Add(rateWindow)
+	assert.InDelta(100.0*math.Exp(-1), r.Value(later), 0.001)
+	r.Add(0, later)
+	assert.InDelta(100.0*math.Exp(-1), r.Value(later.Add(time.Second)), 0.001)
+
+	// partial interval isn't counted yet
+	r2 := &Rate{}
+	r2.Add(1000, start)
+	assert.Equal(0.0, r2.Value(start.Add(rateTickInterval-time.Millisecond)))
+	assert.InDelta(200.0, r2.Value(start.Add(rateTickInterval)), 0.001)
+}
+
+func TestProxyRates(t *testing.T) {
+	assert := assert.New(t)
+	SetProxyInfo("rate_test", "tcp", "", false, false, false)
+	defer func() {
+		smMutex.Lock()
+		delete(ServerMetricInfoMap, "rate_test")
+		smMutex.Unlock()
+	}()
+
+	AddFlowIn("rate_test", 5000)
+	OpenConnection("rate_test")
+	CloseConnection("rate_test")
+
+	// move back one interval to count them
+	smMutex.RLock()
+	m := ServerMetricInfoMap["rate_test"]
+	smMutex.RUnlock()
+	m.mutex.Lock()
+	m.rateIn.lastTick = m.rateIn.lastTick.Add(-rateTickInterval)
+	m.rateConns.lastTick = m.rateConns.lastTick.Add(-rateTickInterval)
+	m.mutex.Unlock()
+
+	rates := GetProxyMetrics("rate_test").Rates
+	assert.InDelta(1000.0, rates.BytesIn, 0.001)
+	assert.InDelta(0.2, rates.Conns, 0.001)
+	assert.Equal(0.0, rates.BytesOut)
+}
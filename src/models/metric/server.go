@@ -38,11 +38,21 @@ type ServerMetric struct {
 	UseEncryption bool     `json:"use_encryption"`
 	UseGzip       bool     `json:"use_gzip"`
 	PrivilegeMode bool     `json:"privilege_mode"`
+	ClientAddr    string   `json:"client_addr"` // address of the frpc which is serving this proxy now
+
+	// policy of frps checked when frpc logs in
+	RequireEncryption bool `json:"require_encryption"`
+	AllowGzip         bool `json:"allow_gzip"`
 
 	// statistics
 	CurrentConns int64               `json:"current_conns"`
+	Rates        Rates               `json:"rates"` // only filled in copies returned by GetAllProxyMetrics and GetProxyMetrics
 	Daily        []*DailyServerStats `json:"daily"`
 	mutex        sync.RWMutex
+
+	rateIn    Rate
+	rateOut   Rate
+	rateConns Rate
 }
 
 type DailyServerStats struct {
@@ -76,6 +86,13 @@ func (s *ServerMetric) clone() *ServerMetric {
 		tmpDaily := *s.Daily[i]
 		copy.Daily[i] = &tmpDaily
 	}
+
+	now := time.Now()
+	copy.Rates = Rates{
+		BytesIn:  s.rateIn.Value(now),
+		BytesOut: s.rateOut.Value(now),
+		Conns:    s.rateConns.Value(now),
+	}
 	return &copy
 }
 
@@ -110,9 +127,9 @@ func GetProxyMetrics(proxyName string) *ServerMetric {
 	}
 }
 
+// SetProxyInfo sets information shared by all proxy types, others are set by SetListenPort and SetDomains
 func SetProxyInfo(proxyName string, proxyType, bindAddr string,
-	useEncryption, useGzip, privilegeMode bool, customDomains []string,
-	listenPort int64) {
+	useEncryption, useGzip, privilegeMode bool) {
 	smMutex.Lock()
 	info, ok := ServerMetricInfoMap[proxyName]
 	if !ok {
@@ -125,8 +142,6 @@ func SetProxyInfo(proxyName string, proxyType, bindAddr string,
 	info.UseGzip = useGzip
 	info.PrivilegeMode = privilegeMode
 	info.BindAddr = bindAddr
-	info.ListenPort = listenPort
-	info.CustomDomains = customDomains
 	ServerMetricInfoMap[proxyName] = info
 	smMutex.Unlock()
 }
@@ -142,6 +157,51 @@ func SetStatus(proxyName string, status int64) {
 	}
 }
 
+func SetClientAddr(proxyName string, addr string) {
+	smMutex.RLock()
+	metric, ok := ServerMetricInfoMap[proxyName]
+	smMutex.RUnlock()
+	if ok {
+		metric.mutex.Lock()
+		metric.ClientAddr = addr
+		metric.mutex.Unlock()
+	}
+}
+
+func SetListenPort(proxyName string, listenPort int64) {
+	smMutex.RLock()
+	metric, ok := ServerMetricInfoMap[proxyName]
+	smMutex.RUnlock()
+	if ok {
+		metric.mutex.Lock()
+		metric.ListenPort = listenPort
+		metric.mutex.Unlock()
+	}
+}
+
+func SetDomains(proxyName string, customDomains []string) {
+	smMutex.RLock()
+	metric, ok := ServerMetricInfoMap[proxyName]
+	smMutex.RUnlock()
+	if ok {
+		metric.mutex.Lock()
+		metric.CustomDomains = customDomains
+		metric.mutex.Unlock()
+	}
+}
+
+func SetPolicy(proxyName string, requireEncryption, allowGzip bool) {
+	smMutex.RLock()
+	metric, ok := ServerMetricInfoMap[proxyName]
+	smMutex.RUnlock()
+	if ok {
+		metric.mutex.Lock()
+		metric.RequireEncryption = requireEncryption
+		metric.AllowGzip = allowGzip
+		metric.mutex.Unlock()
+	}
+}
+
 type DealFuncType func(*DailyServerStats)
 
 func DealDailyData(dailyData []*DailyServerStats, fn DealFuncType) (newDailyData []*DailyServerStats) {
@@ -178,8 +238,11 @@ func OpenConnection(proxyName string) {
 	metric, ok := ServerMetricInfoMap[proxyName]
 	smMutex.RUnlock()
 	if ok {
+		now := time.Now()
+		addGlobalRate(&globalRateConns, 1, now)
 		metric.mutex.Lock()
 		metric.CurrentConns++
+		metric.rateConns.Add(1, now)
 		metric.Daily = DealDailyData(metric.Daily, func(stats *DailyServerStats) {
 			stats.TotalAcceptConns++
 		})
@@ -203,7 +266,10 @@ func AddFlowIn(proxyName string, value int64) {
 	metric, ok := ServerMetricInfoMap[proxyName]
 	smMutex.RUnlock()
 	if ok {
+		now := time.Now()
+		addGlobalRate(&globalRateIn, value, now)
 		metric.mutex.Lock()
+		metric.rateIn.Add(value, now)
 		metric.Daily = DealDailyData(metric.Daily, func(stats *DailyServerStats) {
 			stats.FlowIn += value
 		})
@@ -216,10 +282,69 @@ func AddFlowOut(proxyName string, value int64) {
 	metric, ok := ServerMetricInfoMap[proxyName]
 	smMutex.RUnlock()
 	if ok {
+		now := time.Now()
+		addGlobalRate(&globalRateOut, value, now)
 		metric.mutex.Lock()
+		metric.rateOut.Add(value, now)
 		metric.Daily = DealDailyData(metric.Daily, func(stats *DailyServerStats) {
 			stats.FlowOut += value
 		})
 		metric.mutex.Unlock()
 	}
 }
+
+// ImportProxyMetrics adds daily statistics exported by another frps,
+// proxies which don't exist are added as closed ones
+func ImportProxyMetrics(metrics []*ServerMetric) {
+	smMutex.Lock()
+	defer smMutex.Unlock()
+	for _, m := range metrics {
+		info, ok := ServerMetricInfoMap[m.Name]
+		if !ok {
+			info = m.clone()
+			info.Status = consts.StatusStr[consts.Closed]
+			info.ClientAddr = ""
+			info.CurrentConns = 0
+			info.Rates = Rates{}
+			info.rateIn, info.rateOut, info.rateConns = Rate{}, Rate{}, Rate{}
+			info.Daily = make([]*DailyServerStats, 0)
+			ServerMetricInfoMap[m.Name] = info
+		}
+		info.mutex.Lock()
+		info.Daily = mergeDailyData(info.Daily, m.Daily)
+		info.mutex.Unlock()
+	}
+}
+
+type dailyList []*DailyServerStats
+
+func (l dailyList) Len() int           { return len(l) }
+func (l dailyList) Less(i, j int) bool { return l[i].Time < l[j].Time }
+func (l dailyList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// statistics of the same day are summed and only the latest DailyDataKeepDays days are kept
+func mergeDailyData(dailyData []*DailyServerStats, imported []*DailyServerStats) []*DailyServerStats {
+	days := make(map[string]*DailyServerStats)
+	for _, daily := range dailyData {
+		days[daily.Time] = daily
+	}
+	for _, daily := range imported {
+		if old, ok := days[daily.Time]; ok {
+			old.FlowIn += daily.FlowIn
+			old.FlowOut += daily.FlowOut
+			old.TotalAcceptConns += daily.TotalAcceptConns
+		} else {
+			tmpDaily := *daily
+			days[daily.Time] = &tmpDaily
+		}
+	}
+	result := make(dailyList, 0, len(days))
+	for _, daily := range days {
+		result = append(result, daily)
+	}
+	sort.Sort(result)
+	if len(result) > DailyDataKeepDays {
+		result = result[len(result)-DailyDataKeepDays:]
+	}
+	return result
+}
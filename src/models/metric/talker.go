@@ -0,0 +1,147 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/src/utils/topk"
+)
+
+var (
+	// max number of source ips tracked in each bucket of windows
+	TalkersCapacity int = 100
+
+	// windows of top talkers, the first one is the default
+	TalkerWindows = []string{"1m", "10m", "1h"}
+
+	talkersMap    map[string]*Talkers // key is proxy name
+	globalTalkers *Talkers
+	talkersMutex  sync.RWMutex
+)
+
+// Talkers tracks source ips with most bytes and connections over sliding windows
+type Talkers struct {
+	bytes map[string]*topk.Window
+	conns map[string]*topk.Window
+}
+
+type TopTalkers struct {
+	Window string       `json:"window"`
+	Bytes  []*topk.Item `json:"bytes"`
+	Conns  []*topk.Item `json:"conns"`
+}
+
+func init() {
+	talkersMap = make(map[string]*Talkers)
+	globalTalkers = newTalkers()
+}
+
+func newTalkers() *Talkers {
+	t := &Talkers{
+		bytes: make(map[string]*topk.Window),
+		conns: make(map[string]*topk.Window),
+	}
+	for _, window := range TalkerWindows {
+		t.bytes[window] = newTalkerWindow(window)
+		t.conns[window] = newTalkerWindow(window)
+	}
+	return t
+}
+
+func newTalkerWindow(window string) *topk.Window {
+	switch window {
+	case "1m":
+		return topk.NewWindow(TalkersCapacity, 10*time.Second, 6)
+	case "10m":
+		return topk.NewWindow(TalkersCapacity, time.Minute, 10)
+	default:
+		return topk.NewWindow(TalkersCapacity, 5*time.Minute, 12)
+	}
+}
+
+func getTalkers(proxyName string) *Talkers {
+	talkersMutex.RLock()
+	defer talkersMutex.RUnlock()
+	return talkersMap[proxyName]
+}
+
+// AddTalkers is called when the proxy is created, source ips of unknown proxies are only tracked globally,
+// so connections which are still open after the proxy is deleted won't create it again
+func AddTalkers(proxyName string) {
+	talkersMutex.Lock()
+	defer talkersMutex.Unlock()
+	if _, ok := talkersMap[proxyName]; !ok {
+		talkersMap[proxyName] = newTalkers()
+	}
+}
+
+func DeleteTalkers(proxyName string) {
+	talkersMutex.Lock()
+	defer talkersMutex.Unlock()
+	delete(talkersMap, proxyName)
+}
+
+func AddTalkerConn(proxyName string, ip string) {
+	for _, t := range []*Talkers{getTalkers(proxyName), globalTalkers} {
+		if t == nil {
+			continue
+		}
+		for _, w := range t.conns {
+			w.Add(ip, 1)
+		}
+	}
+}
+
+func AddTalkerFlow(proxyName string, ip string, value int64) {
+	if value <= 0 {
+		return
+	}
+	for _, t := range []*Talkers{getTalkers(proxyName), globalTalkers} {
+		if t == nil {
+			continue
+		}
+		for _, w := range t.bytes {
+			w.Add(ip, value)
+		}
+	}
+}
+
+// GetTopTalkers returns top n source ips of one proxy, or all proxies if proxyName is empty
+func GetTopTalkers(proxyName string, window string, n int) (*TopTalkers, error) {
+	if window == "" {
+		window = TalkerWindows[0]
+	}
+	t := globalTalkers
+	if proxyName != "" {
+		t = getTalkers(proxyName)
+	}
+
+	result := &TopTalkers{
+		Window: window,
+		Bytes:  make([]*topk.Item, 0),
+		Conns:  make([]*topk.Item, 0),
+	}
+	if _, ok := globalTalkers.bytes[window]; !ok {
+		return nil, fmt.Errorf("window [%s] is not supported, use one of %v", window, TalkerWindows)
+	}
+	if t != nil {
+		result.Bytes = t.bytes[window].Top(n)
+		result.Conns = t.conns[window].Top(n)
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTalkers(t *testing.T) {
+	assert := assert.New(t)
+	AddTalkers("talker_test")
+	AddTalkerConn("talker_test", "10.0.0.1")
+	AddTalkerFlow("talker_test", "10.0.0.1", 100)
+
+	top, err := GetTopTalkers("talker_test", "", 10)
+	if assert.NoError(err) && assert.Len(top.Bytes, 1) {
+		assert.Equal("10.0.0.1", top.Bytes[0].Key)
+		assert.Equal(int64(100), top.Bytes[0].Count)
+		assert.Equal(int64(1), top.Conns[0].Count)
+	}
+
+	// flows of deleted proxies are only tracked globally
+	DeleteTalkers("talker_test")
+	AddTalkerFlow("talker_test", "10.0.0.1", 100)
+	top, err = GetTopTalkers("talker_test", "", 10)
+	if assert.NoError(err) {
+		assert.Len(top.Bytes, 0)
+	}
+	top, err = GetTopTalkers("", "", 10)
+	if assert.NoError(err) && assert.Len(top.Bytes, 1) {
+		assert.Equal(int64(200), top.Bytes[0].Count)
+	}
+	_, ok := talkersMap["talker_test"]
+	assert.False(ok)
+}
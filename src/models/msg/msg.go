@@ -38,11 +38,33 @@ type ControlReq struct {
 	HttpUserName      string   `json:"http_username"`
 	HttpPassWord      string   `json:"http_password"`
 	SubDomain         string   `json:"subdomain"`
+	OidcLogin         bool     `json:"oidc_login"`
+	OidcAllowedEmails []string `json:"oidc_allowed_emails"`
+	OidcAllowedGroups []string `json:"oidc_allowed_groups"`
+	HttpCompression   []string `json:"http_compression"`
+	DomainId          string   `json:"domain_id,omitempty"` // identity of frpc used to verify custom domains, see verify.Identity
 	Timestamp         int64    `json:"timestamp"`
+	Traceparent       string   `json:"traceparent,omitempty"` // trace context of the login
+	HalfClose         bool     `json:"half_close,omitempty"`  // frpc supports half-close of tunnels
+}
+
+// first message of every stream of links between edge nodes and the core frps
+type EdgeStreamReq struct {
+	Type        int64  `json:"type"`
+	ProxyName   string `json:"proxy_name"`
+	RemoteAddr  string `json:"remote_addr"` // address of the user
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// proxies synchronized from the core frps to edge nodes, sent every time they are changed
+type EdgeProxies struct {
+	Proxies []*ControlReq `json:"proxies"`
 }
 
 type ControlRes struct {
-	Type int64  `json:"type"`
-	Code int64  `json:"code"`
-	Msg  string `json:"msg"`
+	Type        int64  `json:"type"`
+	Code        int64  `json:"code"`
+	Msg         string `json:"msg"`
+	Traceparent string `json:"traceparent,omitempty"` // trace context of the user conn for NoticeUserConn
+	HalfClose   bool   `json:"half_close,omitempty"`  // both frpc and frps support half-close of tunnels
 }
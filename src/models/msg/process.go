@@ -19,7 +19,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fatedier/frp/src/models/config"
 	"github.com/fatedier/frp/src/models/metric"
@@ -52,41 +54,127 @@ func Join(c1 *conn.Conn, c2 *conn.Conn) {
 	return
 }
 
-// join two connections and do some operations
+// errHalfClosed is returned by pipeDecrypt when the other side of the tunnel closes its writing side
+var errHalfClosed = fmt.Errorf("tunnel is half closed")
+
+// join two connections and do some operations,
+// if conf.HalfClose is true, EOF of one connection is passed as a zero length package and the other side
+// only closes its writing side, so both directions end independently and connections are closed after both of them,
+// otherwise both connections are closed when one direction ends because old versions ignore the zero length package
 func JoinMore(c1 io.ReadWriteCloser, c2 io.ReadWriteCloser, conf config.BaseConf, needRecord bool) {
 	var wait sync.WaitGroup
+	// flow is flushed to metrics periodically, source ip of user connection is used for top talkers
+	var flow *metric.FlowRecorder
+	var srcIp string
+	if needRecord {
+		if c, ok := c1.(*conn.Conn); ok {
+			srcIp, _, _ = net.SplitHostPort(c.GetRemoteAddr())
+		}
+		flow = metric.NewFlowRecorder(conf.Name, srcIp)
+	}
+
+	var closeOnce sync.Once
+	closeAll := func() {
+		closeOnce.Do(func() {
+			c1.Close()
+			c2.Close()
+		})
+	}
+	var finished int32
+	finish := func() {
+		if atomic.AddInt32(&finished, 1) == 2 {
+			closeAll()
+		}
+	}
+
 	encryptPipe := func(from io.ReadCloser, to io.WriteCloser) {
-		defer from.Close()
-		defer to.Close()
 		defer wait.Done()
 
-		// we don't care about errors here
-		pipeEncrypt(from, to, conf, needRecord)
+		// we don't care about other errors here
+		err := pipeEncrypt(from, to, conf, flow)
+		if err == io.EOF && conf.HalfClose {
+			if _, err = to.Write(pkgMsg(nil)); err == nil {
+				finish()
+				return
+			}
+		}
+		closeAll()
 	}
 
-	decryptPipe := func(to io.ReadCloser, from io.WriteCloser) {
-		defer from.Close()
-		defer to.Close()
+	decryptPipe := func(from io.ReadCloser, to io.WriteCloser) {
 		defer wait.Done()
 
-		// we don't care about errors here
-		pipeDecrypt(to, from, conf, needRecord)
+		// we don't care about other errors here
+		err := pipeDecrypt(from, to, conf, flow)
+		if err == errHalfClosed && conn.CloseWrite(to) == nil {
+			finish()
+			// nothing is sent after half-close, keep reading to find out if the tunnel is broken
+			from.Read(make([]byte, 1))
+		}
+		closeAll()
 	}
 
 	if needRecord {
 		metric.OpenConnection(conf.Name)
+		if srcIp != "" {
+			metric.AddTalkerConn(conf.Name, srcIp)
+		}
 	}
 	wait.Add(2)
 	go encryptPipe(c1, c2)
 	go decryptPipe(c2, c1)
 	wait.Wait()
 	if needRecord {
+		flow.Close()
 		metric.CloseConnection(conf.Name)
 	}
 	log.Debug("ProxyName [%s], One tunnel stopped", conf.Name)
 	return
 }
 
+// JoinTunnels joins two work connections whose packages are encrypted by different keys,
+// packages from c1 are decrypted by conf1 and encrypted by conf2 before sent to c2, and vice versa
+func JoinTunnels(c1 io.ReadWriteCloser, conf1 config.BaseConf, c2 io.ReadWriteCloser, conf2 config.BaseConf) {
+	p1, p2 := newPlainPipe()
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		JoinMore(p1, c1, conf1, false)
+	}()
+	JoinMore(p2, c2, conf2, false)
+	wait.Wait()
+}
+
+// plainPipe is one end of an in-memory connection which supports half-close
+type plainPipe struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func newPlainPipe() (*plainPipe, *plainPipe) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	return &plainPipe{r: r1, w: w2}, &plainPipe{r: r2, w: w1}
+}
+
+func (p *plainPipe) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *plainPipe) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+func (p *plainPipe) CloseWrite() error {
+	return p.w.Close()
+}
+
+func (p *plainPipe) Close() error {
+	p.r.Close()
+	return p.w.Close()
+}
+
 func pkgMsg(data []byte) []byte {
 	llen := uint32(len(data))
 	buf := new(bytes.Buffer)
@@ -108,8 +196,8 @@ func unpkgMsg(data []byte) (int, []byte, []byte) {
 	return 0, data[4 : llen+4], data[llen+4:]
 }
 
-// decrypt msg from reader, then write into writer
-func pipeDecrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool) (err error) {
+// decrypt msg from reader, then write into writer, flow is recorded if it isn't nil
+func pipeDecrypt(r io.Reader, w io.Writer, conf config.BaseConf, flow *metric.FlowRecorder) (err error) {
 	laes := new(pcrypto.Pcrypto)
 	key := conf.AuthToken
 	if conf.PrivilegeMode {
@@ -127,14 +215,6 @@ func pipeDecrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool
 	var left, res []byte
 	var cnt int = -1
 
-	// record
-	var flowBytes int64 = 0
-	if needRecord {
-		defer func() {
-			metric.AddFlowOut(conf.Name, flowBytes)
-		}()
-	}
-
 	for {
 		// there may be more than 1 package in variable
 		// and we read more bytes if unpkgMsg returns an error
@@ -157,6 +237,10 @@ func pipeDecrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool
 			}
 			continue
 		}
+		// a zero length package means EOF
+		if len(res) == 0 {
+			return errHalfClosed
+		}
 
 		// aes
 		if conf.UseEncryption {
@@ -180,19 +264,15 @@ func pipeDecrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool
 			return err
 		}
 
-		if needRecord {
-			flowBytes += int64(len(res))
-			if flowBytes >= 1024*1024 {
-				metric.AddFlowOut(conf.Name, flowBytes)
-				flowBytes = 0
-			}
+		if flow != nil {
+			flow.AddOut(int64(len(res)))
 		}
 	}
 	return nil
 }
 
-// recvive msg from reader, then encrypt msg into writer
-func pipeEncrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool) (err error) {
+// recvive msg from reader, then encrypt msg into writer, flow is recorded if it isn't nil
+func pipeEncrypt(r io.Reader, w io.Writer, conf config.BaseConf, flow *metric.FlowRecorder) (err error) {
 	laes := new(pcrypto.Pcrypto)
 	key := conf.AuthToken
 	if conf.PrivilegeMode {
@@ -203,29 +283,21 @@ func pipeEncrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool
 		return fmt.Errorf("Pcrypto Init error: %v", err)
 	}
 
-	// record
-	var flowBytes int64 = 0
-	if needRecord {
-		defer func() {
-			metric.AddFlowIn(conf.Name, flowBytes)
-		}()
-	}
-
 	// get []byte from buffer pool
 	buf := pool.GetBuf(5*1024 + 4)
 	defer pool.PutBuf(buf)
 
 	for {
-		n, err := r.Read(buf)
-		if err != nil {
-			return err
-		}
-		if needRecord {
-			flowBytes += int64(n)
-			if flowBytes >= 1024*1024 {
-				metric.AddFlowIn(conf.Name, flowBytes)
-				flowBytes = 0
+		n, readErr := r.Read(buf)
+		// zero length package is reserved for EOF
+		if n == 0 {
+			if readErr != nil {
+				return readErr
 			}
+			continue
+		}
+		if flow != nil {
+			flow.AddIn(int64(n))
 		}
 
 		res := buf[0:n]
@@ -251,6 +323,10 @@ func pipeEncrypt(r io.Reader, w io.Writer, conf config.BaseConf, needRecord bool
 		if err != nil {
 			return err
 		}
+		// data read with an error is sent before returning
+		if readErr != nil {
+			return readErr
+		}
 	}
 
 	return nil
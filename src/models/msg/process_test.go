@@ -0,0 +1,166 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package msg
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/config"
+	"github.com/fatedier/frp/src/utils/conn"
+)
+
+// return both sides of a tcp connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c1.(*net.TCPConn), c2.(*net.TCPConn)
+}
+
+func TestJoinMoreHalfClose(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.BaseConf{
+		Name:          "test",
+		AuthToken:     "123",
+		UseEncryption: true,
+		UseGzip:       true,
+		HalfClose:     true,
+	}
+
+	// user -> frps -> frpc -> local server, frps and frpc are connected by a work connection
+	user, frpsUserConn := tcpPair(t)
+	frpcLocalConn, local := tcpPair(t)
+	workConn1, workConn2 := net.Pipe()
+	done := make(chan struct{}, 2)
+	go func() {
+		JoinMore(conn.NewConn(frpsUserConn), workConn1, conf, false)
+		done <- struct{}{}
+	}()
+	go func() {
+		JoinMore(conn.NewConn(frpcLocalConn), workConn2, conf, false)
+		done <- struct{}{}
+	}()
+
+	// the local server replies after it reads EOF of request
+	user.Write([]byte("request"))
+	assert.NoError(user.CloseWrite())
+	buf, err := ioutil.ReadAll(local)
+	assert.NoError(err)
+	assert.Equal("request", string(buf))
+	local.Write([]byte("response"))
+	local.Close()
+
+	buf, err = ioutil.ReadAll(user)
+	assert.NoError(err)
+	assert.Equal("response", string(buf))
+	user.Close()
+	<-done
+	<-done
+}
+
+func TestJoinMoreWithoutHalfClose(t *testing.T) {
+	assert := assert.New(t)
+	conf := config.BaseConf{
+		Name:      "test",
+		AuthToken: "123",
+	}
+
+	// the peer doesn't support half-close, EOF closes both directions
+	user, frpsUserConn := tcpPair(t)
+	workConn, peer := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		JoinMore(conn.NewConn(frpsUserConn), workConn, conf, false)
+		close(done)
+	}()
+	go ioutil.ReadAll(peer)
+
+	user.Write([]byte("request"))
+	assert.NoError(user.CloseWrite())
+	<-done
+	_, err := peer.Write([]byte("response"))
+	assert.Error(err)
+	buf, err := ioutil.ReadAll(user)
+	assert.NoError(err)
+	assert.Equal("", string(buf))
+	user.Close()
+}
+
+func TestJoinTunnels(t *testing.T) {
+	assert := assert.New(t)
+	frpsConf := config.BaseConf{
+		Name:           "relay_test",
+		UseEncryption:  true,
+		PrivilegeMode:  true,
+		PrivilegeToken: "frps",
+		HalfClose:      true,
+	}
+	frpcConf := config.BaseConf{
+		Name:           "test",
+		UseEncryption:  true,
+		PrivilegeMode:  true,
+		PrivilegeToken: "relay",
+		HalfClose:      true,
+	}
+
+	// user -> frps -> relay -> frpc -> local server, keys of frps and frpc are different
+	user, frpsUserConn := tcpPair(t)
+	frpcLocalConn, local := tcpPair(t)
+	frpsWorkConn, relayUpConn := net.Pipe()
+	relayDownConn, frpcWorkConn := net.Pipe()
+	done := make(chan struct{}, 3)
+	go func() {
+		JoinMore(conn.NewConn(frpsUserConn), frpsWorkConn, frpsConf, false)
+		done <- struct{}{}
+	}()
+	go func() {
+		JoinTunnels(relayUpConn, frpsConf, relayDownConn, frpcConf)
+		done <- struct{}{}
+	}()
+	go func() {
+		JoinMore(conn.NewConn(frpcLocalConn), frpcWorkConn, frpcConf, false)
+		done <- struct{}{}
+	}()
+
+	user.Write([]byte("request"))
+	assert.NoError(user.CloseWrite())
+	buf, err := ioutil.ReadAll(local)
+	assert.NoError(err)
+	assert.Equal("request", string(buf))
+	local.Write([]byte("response"))
+	local.Close()
+
+	buf, err = ioutil.ReadAll(user)
+	assert.NoError(err)
+	assert.Equal("response", string(buf))
+	user.Close()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
@@ -15,16 +15,23 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	ini "github.com/vaughan0/go-ini"
 
 	"github.com/fatedier/frp/src/models/consts"
 	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/oidc"
+	"github.com/fatedier/frp/src/utils/verify"
 	"github.com/fatedier/frp/src/utils/vhost"
 )
 
@@ -48,12 +55,45 @@ var (
 	AuthTimeout       int64  = 900
 	SubDomainHost     string = ""
 
+	// transport of connections between frpc and frps: tcp, tls, websocket, unix or pipe
+	Transport conn.Transport = &conn.TcpTransport{}
+
+	// if OidcProvider is not nil, http proxies can be protected by OpenID Connect login
+	OidcProvider     *oidc.Provider
+	OidcCookieSecret []byte
+	OidcSessionTtl   int64 = 8 * 3600
+
+	// scheme of the callback url, https if frps is behind a proxy terminating TLS
+	OidcRedirectScheme string = "http"
+
+	// used by http proxies with http_compression set in frpc
+	HttpCompressionMinSize int64    = 1024
+	HttpCompressionTypes   []string = vhost.DefaultCompressionTypes
+
+	// if DomainVerifier is not nil, custom domains of privilege proxies must be verified by DNS or HTTP
+	DomainVerifier *verify.DomainVerifier
+
 	// if PrivilegeAllowPorts is not nil, tcp proxies which remote port exist in this map can be connected
 	PrivilegeAllowPorts map[int64]struct{}
 	MaxPoolCount        int64 = 100
 	HeartBeatTimeout    int64 = 90
 	UserConnTimeout     int64 = 10
 
+	// if ReconnectGracePeriod is greater than 0, listeners of proxies are kept open for seconds after
+	// control connections are broken and at most ReconnectQueueSize user conns are queued for each proxy
+	ReconnectGracePeriod int64 = 0
+	ReconnectQueueSize   int64 = 100
+
+	TraceEndpoint    string = "" // spans are exported to the OTLP/HTTP collector if it's set
+	TraceServiceName string = "frps"
+
+	// edge nodes are accepted if EdgeToken is set, if EdgeCoreAddr is set too, frps runs as an edge node
+	// which relays user conns of proxies synchronized from the core frps at EdgeCoreAddr
+	EdgeToken    string = ""
+	EdgeCoreAddr string = ""
+	// EdgeTransport is Transport of frps, except that certificates of the core are verified if it's tls
+	EdgeTransport conn.Transport = &conn.TcpTransport{}
+
 	VhostHttpMuxer    *vhost.HttpMuxer
 	VhostHttpsMuxer   *vhost.HttpsMuxer
 	ProxyServers      map[string]*ProxyServer = make(map[string]*ProxyServer) // all proxy servers info and resources
@@ -224,6 +264,22 @@ func loadCommonConf(confFile string) error {
 			MaxPoolCount = v
 		}
 	}
+	tmpStr, ok = conf.Get("common", "reconnect_grace_period")
+	if ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: reconnect_grace_period is incorrect")
+		}
+		ReconnectGracePeriod = v
+	}
+	tmpStr, ok = conf.Get("common", "reconnect_queue_size")
+	if ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: reconnect_queue_size is incorrect")
+		}
+		ReconnectQueueSize = v
+	}
 	tmpStr, ok = conf.Get("common", "authentication_timeout")
 	if ok {
 		v, err := strconv.ParseInt(tmpStr, 10, 64)
@@ -237,9 +293,197 @@ func loadCommonConf(confFile string) error {
 	if ok {
 		SubDomainHost = strings.ToLower(strings.TrimSpace(SubDomainHost))
 	}
+
+	if err = loadPolicy(&DefaultPolicy, conf["common"]); err != nil {
+		return fmt.Errorf("Parse conf error: %v", err)
+	}
+
+	tmpStr, ok = conf.Get("common", "trace_endpoint")
+	if ok {
+		TraceEndpoint = tmpStr
+	}
+	tmpStr, ok = conf.Get("common", "trace_service_name")
+	if ok && tmpStr != "" {
+		TraceServiceName = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "edge_token")
+	if ok {
+		EdgeToken = tmpStr
+	}
+	tmpStr, ok = conf.Get("common", "edge_core_addr")
+	if ok && tmpStr != "" {
+		if EdgeToken == "" {
+			return fmt.Errorf("Parse conf error: edge_token must be set if edge_core_addr is set")
+		}
+		EdgeCoreAddr = tmpStr
+	}
+
+	tmpStr, ok = conf.Get("common", "oidc_issuer")
+	if ok && tmpStr != "" {
+		clientId, _ := conf.Get("common", "oidc_client_id")
+		clientSecret, _ := conf.Get("common", "oidc_client_secret")
+		if clientId == "" {
+			return fmt.Errorf("Parse conf error: oidc_client_id must be set if oidc_issuer is set")
+		}
+		OidcProvider = oidc.NewProvider(tmpStr, clientId, clientSecret)
+
+		tmpStr, ok = conf.Get("common", "oidc_groups_claim")
+		if ok && tmpStr != "" {
+			OidcProvider.GroupsClaim = tmpStr
+		}
+
+		// sessions are invalid after restarting if oidc_cookie_secret is not set
+		tmpStr, ok = conf.Get("common", "oidc_cookie_secret")
+		if ok && tmpStr != "" {
+			OidcCookieSecret = []byte(tmpStr)
+		} else {
+			OidcCookieSecret = []byte(oidc.RandomString())
+		}
+
+		tmpStr, ok = conf.Get("common", "oidc_session_ttl")
+		if ok {
+			v, err := strconv.ParseInt(tmpStr, 10, 64)
+			if err != nil || v <= 0 {
+				return fmt.Errorf("Parse conf error: oidc_session_ttl is incorrect")
+			}
+			OidcSessionTtl = v
+		}
+
+		tmpStr, ok = conf.Get("common", "oidc_redirect_scheme")
+		if ok {
+			if tmpStr != "http" && tmpStr != "https" {
+				return fmt.Errorf("Parse conf error: oidc_redirect_scheme must be http or https")
+			}
+			OidcRedirectScheme = tmpStr
+		}
+	}
+
+	tmpStr, ok = conf.Get("common", "http_compression_min_size")
+	if ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("Parse conf error: http_compression_min_size is incorrect")
+		}
+		HttpCompressionMinSize = v
+	}
+
+	tmpStr, ok = conf.Get("common", "http_compression_types")
+	if ok && tmpStr != "" {
+		HttpCompressionTypes = make([]string, 0)
+		for _, t := range strings.Split(tmpStr, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				HttpCompressionTypes = append(HttpCompressionTypes, t)
+			}
+		}
+	}
+
+	tmpStr, ok = conf.Get("common", "custom_domain_verification")
+	if ok && tmpStr != "" && PrivilegeMode {
+		methods := strings.Split(tmpStr, ",")
+		for i, method := range methods {
+			methods[i] = strings.TrimSpace(method)
+		}
+		var ttl int64 = 86400
+		tmpStr, ok = conf.Get("common", "custom_domain_verification_ttl")
+		if ok {
+			ttl, err = strconv.ParseInt(tmpStr, 10, 64)
+			if err != nil || ttl < 0 {
+				return fmt.Errorf("Parse conf error: custom_domain_verification_ttl is incorrect")
+			}
+		}
+		// tokens are changed if the secret is changed
+		secret, ok := conf.Get("common", "custom_domain_verification_secret")
+		if !ok || secret == "" {
+			secret = PrivilegeToken
+		}
+		DomainVerifier, err = verify.NewDomainVerifier(secret, methods, time.Duration(ttl)*time.Second)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: custom_domain_verification is incorrect, %v", err)
+		}
+	}
+
+	Transport, err = loadTransportConf(conf)
+	if err != nil {
+		return err
+	}
+	EdgeTransport = Transport
+	if _, ok := Transport.(*conn.TlsTransport); ok && EdgeCoreAddr != "" {
+		serverName, _ := conf.Get("common", "edge_core_tls_server_name")
+		caFile, _ := conf.Get("common", "edge_core_tls_ca")
+		config, err := newEdgeTlsConfig(serverName, caFile)
+		if err != nil {
+			return fmt.Errorf("Parse conf error: %v", err)
+		}
+		EdgeTransport = &conn.TlsTransport{
+			Transport: &conn.TcpTransport{},
+			Config:    config,
+		}
+	}
 	return nil
 }
 
+// certificates of the core frps are verified by caFile if it's not empty, otherwise by system root CAs
+func newEdgeTlsConfig(serverName, caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+	}
+	if caFile != "" {
+		caPem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read edge_core_tls_ca error: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificate found in edge_core_tls_ca [%s]", caFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func loadTransportConf(conf ini.File) (t conn.Transport, err error) {
+	transportType, ok := conf.Get("common", "transport")
+	if !ok || transportType == "" {
+		transportType = "tcp"
+	}
+
+	switch transportType {
+	case "tcp":
+		t = &conn.TcpTransport{}
+	case "tls":
+		certFile, _ := conf.Get("common", "transport_tls_cert")
+		keyFile, _ := conf.Get("common", "transport_tls_key")
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("Parse conf error: transport_tls_cert and transport_tls_key must be set if transport is tls")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Parse conf error: load transport_tls_cert and transport_tls_key error: %v", err)
+		}
+		t = &conn.TlsTransport{
+			Transport: &conn.TcpTransport{},
+			Config:    &tls.Config{Certificates: []tls.Certificate{cert}},
+		}
+	case "websocket":
+		path, _ := conf.Get("common", "transport_websocket_path")
+		t = &conn.WebsocketTransport{
+			Transport: &conn.TcpTransport{},
+			Path:      path,
+		}
+	case "unix":
+		path, _ := conf.Get("common", "transport_unix_path")
+		if path == "" {
+			return nil, fmt.Errorf("Parse conf error: transport_unix_path must be set if transport is unix")
+		}
+		t = &conn.UnixTransport{Path: path}
+	case "pipe":
+		t = conn.DefaultPipeTransport
+	default:
+		return nil, fmt.Errorf("Parse conf error: transport [%s] is not supported", transportType)
+	}
+	return t, nil
+}
+
 func loadProxyConf(confFile string) (proxyServers map[string]*ProxyServer, err error) {
 	var ok bool
 	proxyServers = make(map[string]*ProxyServer)
@@ -254,76 +498,25 @@ func loadProxyConf(confFile string) (proxyServers map[string]*ProxyServer, err e
 			proxyServer.Name = name
 
 			proxyServer.Type, ok = section["type"]
-			if ok {
-				if proxyServer.Type != "tcp" && proxyServer.Type != "http" && proxyServer.Type != "https" && proxyServer.Type != "udp" {
-					return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] type error", proxyServer.Name)
-				}
-			} else {
+			if !ok {
 				proxyServer.Type = "tcp"
 			}
+			proxyType, ok := GetProxyType(proxyServer.Type)
+			if !ok {
+				return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] type error", proxyServer.Name)
+			}
 
 			proxyServer.AuthToken, ok = section["auth_token"]
-			if !ok {
+			if !ok && !proxyServer.IsStandalone() {
 				return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] no auth_token found", proxyServer.Name)
 			}
 
-			// for tcp and udp
-			if proxyServer.Type == "tcp" || proxyServer.Type == "udp" {
-				proxyServer.BindAddr, ok = section["bind_addr"]
-				if !ok {
-					proxyServer.BindAddr = "0.0.0.0"
-				}
-
-				portStr, ok := section["listen_port"]
-				if ok {
-					proxyServer.ListenPort, err = strconv.ParseInt(portStr, 10, 64)
-					if err != nil {
-						return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] listen_port error", proxyServer.Name)
-					}
-				} else {
-					return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] listen_port not found", proxyServer.Name)
-				}
-			} else if proxyServer.Type == "http" {
-				// for http
-				proxyServer.ListenPort = VhostHttpPort
-
-				domainStr, ok := section["custom_domains"]
-				if ok {
-					proxyServer.CustomDomains = strings.Split(domainStr, ",")
-					if len(proxyServer.CustomDomains) == 0 {
-						return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom_domains must be set when type is http", proxyServer.Name)
-					}
-					for i, domain := range proxyServer.CustomDomains {
-						domain = strings.ToLower(strings.TrimSpace(domain))
-						// custom domain should not belong to subdomain_host
-						if SubDomainHost != "" && strings.Contains(domain, SubDomainHost) {
-							return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom domain should not belong to subdomain_host", proxyServer.Name)
-						}
-						proxyServer.CustomDomains[i] = domain
-					}
-				} else {
-					return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom_domains must be set when type is http", proxyServer.Name)
-				}
-			} else if proxyServer.Type == "https" {
-				// for https
-				proxyServer.ListenPort = VhostHttpsPort
-
-				domainStr, ok := section["custom_domains"]
-				if ok {
-					proxyServer.CustomDomains = strings.Split(domainStr, ",")
-					if len(proxyServer.CustomDomains) == 0 {
-						return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom_domains must be set when type is https", proxyServer.Name)
-					}
-					for i, domain := range proxyServer.CustomDomains {
-						domain = strings.ToLower(strings.TrimSpace(domain))
-						if SubDomainHost != "" && strings.Contains(domain, SubDomainHost) {
-							return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom domain should not belong to subdomain_host", proxyServer.Name)
-						}
-						proxyServer.CustomDomains[i] = domain
-					}
-				} else {
-					return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] custom_domains must be set when type is https", proxyServer.Name)
-				}
+			if err = proxyType.LoadConf(proxyServer, section); err != nil {
+				return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] %v", proxyServer.Name, err)
+			}
+			proxyServer.Policy = DefaultPolicy
+			if err = loadPolicy(&proxyServer.Policy, section); err != nil {
+				return proxyServers, fmt.Errorf("Parse conf error: proxy [%s] %v", proxyServer.Name, err)
 			}
 			proxyServers[proxyServer.Name] = proxyServer
 		}
@@ -331,12 +524,38 @@ func loadProxyConf(confFile string) (proxyServers map[string]*ProxyServer, err e
 
 	// set metric statistics of all proxies
 	for name, p := range proxyServers {
-		metric.SetProxyInfo(name, p.Type, p.BindAddr, p.UseEncryption, p.UseGzip,
-			p.PrivilegeMode, p.CustomDomains, p.ListenPort)
+		p.SetMetrics()
+		metric.AddTalkers(name)
+		log.AddProxyLogs(name)
 	}
 	return proxyServers, nil
 }
 
+func newVhostTlsConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls_cert and tls_key error: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile != "" {
+		caPem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client_ca error: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificate found in client_ca [%s]", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+		// users without certificates get 403 response, invalid certificates are rejected during the handshake
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
 // the function can only reload proxy configures
 // common section won't be changed
 func ReloadConf(confFile string) (err error) {
@@ -354,11 +573,13 @@ func ReloadConf(confFile string) (err error) {
 				proxyServer.Init()
 				ProxyServers[name] = proxyServer
 				log.Info("ProxyName [%s] configure change, restart", name)
+				startStandaloneProxy(proxyServer)
 			}
 		} else {
 			proxyServer.Init()
 			ProxyServers[name] = proxyServer
 			log.Info("ProxyName [%s] is new, init it", name)
+			startStandaloneProxy(proxyServer)
 		}
 	}
 
@@ -369,6 +590,8 @@ func ReloadConf(confFile string) (err error) {
 			if !oldProxyServer.PrivilegeMode {
 				oldProxyServer.Close()
 				delete(ProxyServers, name)
+				metric.DeleteTalkers(name)
+				log.ClearProxyLogs(name)
 				log.Info("ProxyName [%s] deleted, close it", name)
 			} else {
 				log.Info("ProxyName [%s] created by PrivilegeMode, won't be closed", name)
@@ -379,30 +602,61 @@ func ReloadConf(confFile string) (err error) {
 	return nil
 }
 
+// StartStandaloneProxies starts proxies served by frps itself, it's called after vhost muxers are created
+func StartStandaloneProxies() {
+	ProxyServersMutex.RLock()
+	defer ProxyServersMutex.RUnlock()
+	for _, p := range ProxyServers {
+		startStandaloneProxy(p)
+	}
+}
+
+func startStandaloneProxy(p *ProxyServer) {
+	if !p.IsStandalone() {
+		return
+	}
+	if err := p.Start(nil); err != nil {
+		log.Warn("ProxyName [%s], start proxy error: %v", p.Name, err)
+		p.Close()
+		return
+	}
+	log.Info("ProxyName [%s], start proxy success", p.Name)
+}
+
+// CreateProxy adds a proxy created in privilege mode, the old one with the same name is replaced
+// if it isn't working, e.g. waiting for reconnection
 func CreateProxy(s *ProxyServer) error {
 	ProxyServersMutex.Lock()
-	defer ProxyServersMutex.Unlock()
 	oldServer, ok := ProxyServers[s.Name]
+	if ok && oldServer.GetStatus() == consts.Working {
+		ProxyServersMutex.Unlock()
+		return fmt.Errorf("this proxy is already working now")
+	}
+	ProxyServers[s.Name] = s
+	ProxyServersMutex.Unlock()
+
+	// the old proxy isn't deleted from ProxyServers when closed since it's replaced
 	if ok {
-		if oldServer.Status == consts.Working {
-			return fmt.Errorf("this proxy is already working now")
-		}
 		oldServer.Close()
-		if oldServer.PrivilegeMode {
-			delete(ProxyServers, s.Name)
-		}
 	}
-	ProxyServers[s.Name] = s
-	metric.SetProxyInfo(s.Name, s.Type, s.BindAddr, s.UseEncryption, s.UseGzip,
-		s.PrivilegeMode, s.CustomDomains, s.ListenPort)
+	s.SetMetrics()
+	metric.AddTalkers(s.Name)
+	log.AddProxyLogs(s.Name)
 	s.Init()
 	return nil
 }
 
-func DeleteProxy(proxyName string) {
+// DeleteProxy is called when a proxy created in privilege mode is closed,
+// nothing is deleted if another proxy with the same name has replaced it
+func DeleteProxy(s *ProxyServer) {
 	ProxyServersMutex.Lock()
 	defer ProxyServersMutex.Unlock()
-	delete(ProxyServers, proxyName)
+	if ProxyServers[s.Name] != s {
+		return
+	}
+	delete(ProxyServers, s.Name)
+	metric.DeleteTalkers(s.Name)
+	log.ClearProxyLogs(s.Name)
 }
 
 func GetProxyServer(proxyName string) (p *ProxyServer, ok bool) {
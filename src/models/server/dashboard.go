@@ -36,6 +36,14 @@ func RunDashboardServer(addr string, port int64) (err error) {
 	// api, see dashboard_api.go
 	mux.HandleFunc("/api/reload", use(apiReload, basicAuth))
 	mux.HandleFunc("/api/proxies", apiProxies)
+	mux.HandleFunc("/api/domains", apiDomains)
+	mux.HandleFunc("/api/top", use(apiGlobalTopTalkers, basicAuth))
+	mux.HandleFunc("/api/proxy/", apiProxy)
+	mux.HandleFunc("/api/log", use(apiLog, basicAuth))
+	mux.HandleFunc("/api/state", use(apiState, basicAuth))
+
+	// prometheus metrics, see dashboard_metrics.go, source ips of users are included
+	mux.HandleFunc("/metrics", use(metricsHandler, basicAuth))
 
 	// view, see dashboard_view.go
 	mux.Handle("/favicon.ico", http.FileServer(assets.FileSystem))
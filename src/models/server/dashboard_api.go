@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/fatedier/frp/src/models/metric"
 	"github.com/fatedier/frp/src/utils/log"
@@ -50,6 +52,7 @@ func apiReload(w http.ResponseWriter, r *http.Request) {
 type ProxiesResponse struct {
 	Code    int64                  `json:"code"`
 	Msg     string                 `json:"msg"`
+	Rates   metric.Rates           `json:"rates"` // rates of all proxies
 	Proxies []*metric.ServerMetric `json:"proxies"`
 }
 
@@ -61,7 +64,208 @@ func apiProxies(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	log.Info("Http request: [/api/proxies]")
+	res.Rates = metric.GetGlobalRates()
 	res.Proxies = metric.GetAllProxyMetrics()
 	buf, _ = json.Marshal(res)
 	w.Write(buf)
 }
+
+type DomainsResponse struct {
+	Code    int64                `json:"code"`
+	Msg     string               `json:"msg"`
+	Domains []*metric.HttpMetric `json:"domains"`
+}
+
+func apiDomains(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	res := &DomainsResponse{}
+	defer func() {
+		log.Info("Http response [/api/domains]: code [%d]", res.Code)
+	}()
+
+	log.Info("Http request: [/api/domains]")
+	res.Domains = metric.GetAllHttpMetrics()
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+type TopTalkersResponse struct {
+	Code int64  `json:"code"`
+	Msg  string `json:"msg"`
+	*metric.TopTalkers
+}
+
+// apiProxy dispatches /api/proxy/{name}/{action}
+func apiProxy(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/proxy/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// source ips of users and logs are only shown to the admin
+	switch parts[1] {
+	case "top":
+		use(func(w http.ResponseWriter, r *http.Request) {
+			apiTopTalkers(w, r, parts[0])
+		}, basicAuth)(w, r)
+	case "log":
+		use(func(w http.ResponseWriter, r *http.Request) {
+			apiProxyLog(w, r, parts[0])
+		}, basicAuth)(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func apiGlobalTopTalkers(w http.ResponseWriter, r *http.Request) {
+	apiTopTalkers(w, r, "")
+}
+
+// query parameters: window is one of metric.TalkerWindows, limit is the max number of source ips, default is 10
+func apiTopTalkers(w http.ResponseWriter, r *http.Request, proxyName string) {
+	var buf []byte
+	res := &TopTalkersResponse{}
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+	}()
+
+	log.Info("Http request: [%s]", r.URL.Path)
+	limit := 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v <= 0 {
+			res.Code = 1
+			res.Msg = "limit must be a positive integer"
+		} else {
+			limit = v
+		}
+	}
+	if res.Code == 0 && proxyName != "" && metric.GetProxyMetrics(proxyName) == nil {
+		res.Code = 1
+		res.Msg = fmt.Sprintf("proxy [%s] not found", proxyName)
+	}
+	if res.Code == 0 {
+		talkers, err := metric.GetTopTalkers(proxyName, r.URL.Query().Get("window"), limit)
+		if err != nil {
+			res.Code = 1
+			res.Msg = err.Error()
+		} else {
+			res.TopTalkers = talkers
+		}
+	}
+
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+type LogResponse struct {
+	Code         int64    `json:"code"`
+	Msg          string   `json:"msg"`
+	Level        string   `json:"level"`
+	DebugProxies []string `json:"debug_proxies"`
+}
+
+// GET returns the log level, POST with parameter level changes it
+func apiLog(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	res := &LogResponse{}
+	defer func() {
+		log.Info("Http response [/api/log]: code [%d]", res.Code)
+	}()
+
+	log.Info("Http request: [/api/log]")
+	if r.Method == "POST" {
+		level := r.URL.Query().Get("level")
+		if log.IsValidLevel(level) {
+			log.SetLogLevel(level)
+			log.Warn("Log level is changed to [%s]", level)
+		} else {
+			res.Code = 1
+			res.Msg = "level must be one of error, warn, info and debug"
+		}
+	}
+	res.Level = log.GetLogLevel()
+	res.DebugProxies = log.GetDebugProxies()
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+type ProxyLogResponse struct {
+	Code  int64    `json:"code"`
+	Msg   string   `json:"msg"`
+	Name  string   `json:"name"`
+	Debug bool     `json:"debug"`
+	Lines []string `json:"lines"`
+}
+
+// GET returns recent log lines of the proxy, the number of lines is limited by parameter limit,
+// POST with parameter debug=true or debug=false enables or disables debug logs of the proxy
+func apiProxyLog(w http.ResponseWriter, r *http.Request, proxyName string) {
+	var buf []byte
+	res := &ProxyLogResponse{Name: proxyName}
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+	}()
+
+	log.Info("Http request: [%s]", r.URL.Path)
+	if r.Method == "POST" {
+		switch r.URL.Query().Get("debug") {
+		case "true":
+			log.SetProxyDebug(proxyName, true)
+		case "false":
+			log.SetProxyDebug(proxyName, false)
+		default:
+			res.Code = 1
+			res.Msg = "debug must be true or false"
+		}
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		v, err := strconv.Atoi(limitStr)
+		if err != nil || v <= 0 {
+			res.Code = 1
+			res.Msg = "limit must be a positive integer"
+		} else {
+			limit = v
+		}
+	}
+	res.Debug = log.IsProxyDebug(proxyName)
+	res.Lines = log.GetProxyLogs(proxyName, limit)
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
+
+type StateResponse struct {
+	Code     int64  `json:"code"`
+	Msg      string `json:"msg"`
+	State    *State `json:"state,omitempty"`
+	Restored int    `json:"restored"`
+}
+
+// GET exports runtime state of frps, POST imports the state in request body
+func apiState(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	res := &StateResponse{}
+	defer func() {
+		log.Info("Http response [/api/state]: code [%d]", res.Code)
+	}()
+
+	log.Info("Http request: [/api/state]")
+	if r.Method == "POST" {
+		state := &State{}
+		err := json.NewDecoder(r.Body).Decode(state)
+		if err != nil {
+			res.Code = 1
+			res.Msg = fmt.Sprintf("state format error: %v", err)
+		} else if res.Restored, err = ImportState(state); err != nil {
+			res.Code = 2
+			res.Msg = fmt.Sprintf("%v", err)
+		}
+	} else {
+		res.State = ExportState()
+	}
+	buf, _ = json.Marshal(res)
+	w.Write(buf)
+}
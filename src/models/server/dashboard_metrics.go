@@ -0,0 +1,164 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/src/models/metric"
+)
+
+// number of top source ips exported for each proxy
+const exportTopTalkers = 5
+
+// metricsHandler exports metrics in prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	buf := bytes.NewBuffer(nil)
+	proxies := metric.GetAllProxyMetrics()
+	writeGlobalRates(buf, metric.GetGlobalRates())
+	writeProxyMetrics(buf, proxies)
+	writeHttpMetrics(buf, metric.GetAllHttpMetrics())
+	writeTalkerMetrics(buf, proxies)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+func writeProxyMetrics(buf *bytes.Buffer, proxies []*metric.ServerMetric) {
+	writeMetricHeader(buf, "frp_proxy_current_connections", "gauge", "Number of current user connections.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_current_connections{name=\"%s\",type=\"%s\"} %d\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), p.CurrentConns)
+	}
+
+	// daily statistics are reset every day, so they are exported as gauges
+	writeMetricHeader(buf, "frp_proxy_today_traffic_in_bytes", "gauge", "Bytes received from users today.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_today_traffic_in_bytes{name=\"%s\",type=\"%s\"} %d\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), todayStats(p).FlowIn)
+	}
+	writeMetricHeader(buf, "frp_proxy_today_traffic_out_bytes", "gauge", "Bytes sent to users today.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_today_traffic_out_bytes{name=\"%s\",type=\"%s\"} %d\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), todayStats(p).FlowOut)
+	}
+
+	writeMetricHeader(buf, "frp_proxy_traffic_in_bytes_per_second", "gauge", "Moving average of bytes received from users per second over one minute.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_traffic_in_bytes_per_second{name=\"%s\",type=\"%s\"} %g\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), p.Rates.BytesIn)
+	}
+	writeMetricHeader(buf, "frp_proxy_traffic_out_bytes_per_second", "gauge", "Moving average of bytes sent to users per second over one minute.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_traffic_out_bytes_per_second{name=\"%s\",type=\"%s\"} %g\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), p.Rates.BytesOut)
+	}
+	writeMetricHeader(buf, "frp_proxy_connections_per_second", "gauge", "Moving average of new user connections per second over one minute.")
+	for _, p := range proxies {
+		fmt.Fprintf(buf, "frp_proxy_connections_per_second{name=\"%s\",type=\"%s\"} %g\n",
+			escapeLabel(p.Name), escapeLabel(p.Type), p.Rates.Conns)
+	}
+}
+
+func writeGlobalRates(buf *bytes.Buffer, rates metric.Rates) {
+	writeMetricHeader(buf, "frp_traffic_in_bytes_per_second", "gauge", "Moving average of bytes received from users of all proxies per second over one minute.")
+	fmt.Fprintf(buf, "frp_traffic_in_bytes_per_second %g\n", rates.BytesIn)
+	writeMetricHeader(buf, "frp_traffic_out_bytes_per_second", "gauge", "Moving average of bytes sent to users of all proxies per second over one minute.")
+	fmt.Fprintf(buf, "frp_traffic_out_bytes_per_second %g\n", rates.BytesOut)
+	writeMetricHeader(buf, "frp_connections_per_second", "gauge", "Moving average of new user connections of all proxies per second over one minute.")
+	fmt.Fprintf(buf, "frp_connections_per_second %g\n", rates.Conns)
+}
+
+func writeHttpMetrics(buf *bytes.Buffer, domains []*metric.HttpMetric) {
+	writeMetricHeader(buf, "frp_http_requests_total", "counter", "Number of http requests by status class.")
+	for _, d := range domains {
+		domain := escapeLabel(d.Domain)
+		counts := []int64{d.Status1xx, d.Status2xx, d.Status3xx, d.Status4xx, d.Status5xx}
+		for i, count := range counts {
+			fmt.Fprintf(buf, "frp_http_requests_total{domain=\"%s\",code=\"%dxx\"} %d\n", domain, i+1, count)
+		}
+	}
+
+	writeMetricHeader(buf, "frp_http_request_bytes_total", "counter", "Bytes of http requests including headers.")
+	for _, d := range domains {
+		fmt.Fprintf(buf, "frp_http_request_bytes_total{domain=\"%s\"} %d\n", escapeLabel(d.Domain), d.RequestBytes)
+	}
+	writeMetricHeader(buf, "frp_http_response_bytes_total", "counter", "Bytes of http responses including headers.")
+	for _, d := range domains {
+		fmt.Fprintf(buf, "frp_http_response_bytes_total{domain=\"%s\"} %d\n", escapeLabel(d.Domain), d.ResponseBytes)
+	}
+
+	writeMetricHeader(buf, "frp_http_response_latency_seconds", "summary", "Time to response header of recent http requests.")
+	for _, d := range domains {
+		domain := escapeLabel(d.Domain)
+		fmt.Fprintf(buf, "frp_http_response_latency_seconds{domain=\"%s\",quantile=\"0.5\"} %g\n", domain, d.LatencyP50/1000)
+		fmt.Fprintf(buf, "frp_http_response_latency_seconds{domain=\"%s\",quantile=\"0.9\"} %g\n", domain, d.LatencyP90/1000)
+		fmt.Fprintf(buf, "frp_http_response_latency_seconds{domain=\"%s\",quantile=\"0.99\"} %g\n", domain, d.LatencyP99/1000)
+		fmt.Fprintf(buf, "frp_http_response_latency_seconds_count{domain=\"%s\"} %d\n", domain, d.Requests)
+	}
+}
+
+// only top source ips in the default window are exported to limit the number of series
+func writeTalkerMetrics(buf *bytes.Buffer, proxies []*metric.ServerMetric) {
+	window := metric.TalkerWindows[0]
+	talkers := make(map[string]*metric.TopTalkers)
+	for _, p := range proxies {
+		talkers[p.Name], _ = metric.GetTopTalkers(p.Name, window, exportTopTalkers)
+	}
+	global, _ := metric.GetTopTalkers("", window, exportTopTalkers)
+
+	writeMetricHeader(buf, "frp_top_talker_bytes", "gauge", "Bytes of source ips with most traffic in the last "+window+".")
+	for _, item := range global.Bytes {
+		fmt.Fprintf(buf, "frp_top_talker_bytes{ip=\"%s\"} %d\n", escapeLabel(item.Key), item.Count)
+	}
+	writeMetricHeader(buf, "frp_top_talker_connections", "gauge", "Connections of source ips with most connections in the last "+window+".")
+	for _, item := range global.Conns {
+		fmt.Fprintf(buf, "frp_top_talker_connections{ip=\"%s\"} %d\n", escapeLabel(item.Key), item.Count)
+	}
+
+	writeMetricHeader(buf, "frp_proxy_top_talker_bytes", "gauge", "Bytes of source ips with most traffic of each proxy in the last "+window+".")
+	for _, p := range proxies {
+		for _, item := range talkers[p.Name].Bytes {
+			fmt.Fprintf(buf, "frp_proxy_top_talker_bytes{name=\"%s\",ip=\"%s\"} %d\n", escapeLabel(p.Name), escapeLabel(item.Key), item.Count)
+		}
+	}
+	writeMetricHeader(buf, "frp_proxy_top_talker_connections", "gauge", "Connections of source ips with most connections of each proxy in the last "+window+".")
+	for _, p := range proxies {
+		for _, item := range talkers[p.Name].Conns {
+			fmt.Fprintf(buf, "frp_proxy_top_talker_connections{name=\"%s\",ip=\"%s\"} %d\n", escapeLabel(p.Name), escapeLabel(item.Key), item.Count)
+		}
+	}
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, metricType, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType)
+}
+
+func todayStats(p *metric.ServerMetric) *metric.DailyServerStats {
+	if len(p.Daily) == 0 || p.Daily[len(p.Daily)-1].Time != time.Now().Format("20060102") {
+		return &metric.DailyServerStats{}
+	}
+	return p.Daily[len(p.Daily)-1]
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
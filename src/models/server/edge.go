@@ -0,0 +1,411 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/mux"
+	"github.com/fatedier/frp/src/utils/pcrypto"
+	"github.com/fatedier/frp/src/utils/trace"
+)
+
+// interval of checking if proxies are changed and sending them to edge nodes
+const edgeSyncInterval = time.Second
+
+// max interval of reconnecting to the core frps
+const edgeMaxRetryInterval = 30 * time.Second
+
+// only these types of proxies are relayed by edge nodes
+var edgeProxyTypes = map[string]bool{"tcp": true, "http": true, "https": true}
+
+// bufferedConn reads from the bufio.Reader of conn.Conn, so bytes buffered after the login message are not lost
+type bufferedConn struct {
+	net.Conn
+	rd io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.rd.Read(p)
+}
+
+// edgeUserConn is a stream relayed by an edge node, its remote address is the address of the user
+type edgeUserConn struct {
+	net.Conn
+	remoteAddr edgeAddr
+}
+
+func (c *edgeUserConn) CloseWrite() error {
+	return conn.CloseWrite(c.Conn)
+}
+
+func (c *edgeUserConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+type edgeAddr string
+
+func (a edgeAddr) Network() string { return "tcp" }
+func (a edgeAddr) String() string  { return string(a) }
+
+// ServeEdgeLink serves the link from an edge node until it's broken, c is closed when it returns
+func ServeEdgeLink(c *conn.Conn, req *msg.ControlReq) {
+	defer c.Close()
+	res := &msg.ControlRes{
+		Type: consts.NewCtlConnRes,
+	}
+	if err := checkEdgeAuth(req); err != nil {
+		log.Warn("Edge node [%s], %v", c.GetRemoteAddr(), err)
+		res.Code = 1
+		res.Msg = err.Error()
+	}
+	buf, _ := json.Marshal(res)
+	if err := c.WriteString(string(buf) + "\n"); err != nil || res.Code != 0 {
+		return
+	}
+	log.Info("Edge node [%s] is connected", c.GetRemoteAddr())
+
+	session := mux.Server(&bufferedConn{Conn: c.TcpConn, rd: c.Reader}, nil)
+	defer session.Close()
+	for {
+		st, err := session.Accept()
+		if err != nil {
+			log.Info("Edge node [%s] is disconnected, %v", c.GetRemoteAddr(), session.Err())
+			return
+		}
+		go handleEdgeStream(session, st)
+	}
+}
+
+func checkEdgeAuth(req *msg.ControlReq) error {
+	if EdgeToken == "" {
+		return fmt.Errorf("edge nodes are not allowed")
+	}
+	if AuthTimeout != 0 && time.Now().Unix()-req.Timestamp > AuthTimeout {
+		return fmt.Errorf("edge authorization timeout")
+	}
+	if req.AuthKey != pcrypto.GetAuthKey(EdgeToken+fmt.Sprintf("%d", req.Timestamp)) {
+		return fmt.Errorf("edge authorization failed")
+	}
+	return nil
+}
+
+func handleEdgeStream(session *mux.Session, st *mux.Stream) {
+	c := conn.NewConn(st)
+	c.SetReadDeadline(time.Now().Add(time.Duration(UserConnTimeout) * time.Second))
+	buf, err := c.ReadLine()
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	req := &msg.EdgeStreamReq{}
+	if err = json.Unmarshal([]byte(buf), req); err != nil {
+		log.Warn("Edge node [%s], parse stream message error: %v", session.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	switch req.Type {
+	case consts.EdgeSync:
+		syncEdgeProxies(session, c)
+	case consts.EdgeUserConn:
+		p, ok := GetProxyServer(req.ProxyName)
+		if !ok {
+			log.Debug("ProxyName [%s], user conn relayed by edge node [%s] is closed, proxy not found", req.ProxyName, session.RemoteAddr())
+			c.Close()
+			return
+		}
+		c.TcpConn = &edgeUserConn{Conn: st, remoteAddr: edgeAddr(req.RemoteAddr)}
+		c.Trace = trace.Start("frps.user_conn", req.Traceparent)
+		c.Trace.SetAttribute("edge", session.RemoteAddr().String())
+		log.Debug("ProxyName [%s], get one new user conn [%s] from edge node [%s]", req.ProxyName, req.RemoteAddr, session.RemoteAddr())
+		p.HandleUserConn(c)
+	default:
+		c.Close()
+	}
+}
+
+// send proxies to the edge node every time they are changed
+func syncEdgeProxies(session *mux.Session, c *conn.Conn) {
+	defer c.Close()
+	var last string
+	for {
+		buf, _ := json.Marshal(getEdgeProxies())
+		if string(buf) != last {
+			if err := c.WriteString(string(buf) + "\n"); err != nil {
+				return
+			}
+			last = string(buf)
+		}
+		select {
+		case <-time.After(edgeSyncInterval):
+		case <-session.CloseChan():
+			return
+		}
+	}
+}
+
+// working proxies which can be relayed by edge nodes
+func getEdgeProxies() *msg.EdgeProxies {
+	res := &msg.EdgeProxies{
+		Proxies: make([]*msg.ControlReq, 0),
+	}
+	ProxyServersMutex.RLock()
+	defer ProxyServersMutex.RUnlock()
+	for _, p := range ProxyServers {
+		status := p.GetStatus()
+		if status != consts.Working && status != consts.Reconnecting {
+			continue
+		}
+		// TLS of https proxies with certificates is terminated by the core frps
+		if !edgeProxyTypes[p.Type] || p.TlsCert != "" {
+			continue
+		}
+		res.Proxies = append(res.Proxies, p.toCtlMsg())
+	}
+	sort.Sort(ctlMsgList(res.Proxies))
+	return res
+}
+
+var (
+	// link to the core frps, nil if it's broken
+	edgeSession      *mux.Session
+	edgeSessionMutex sync.RWMutex
+
+	// login messages of relayed proxies for checking if they are changed
+	edgeRelayedReqs = make(map[string]string)
+)
+
+// RunEdgeLink keeps the link to the core frps and relays proxies synchronized from it, it never returns
+func RunEdgeLink() {
+	delay := time.Second
+	for {
+		connected, err := runEdgeLinkOnce()
+		if connected {
+			delay = time.Second
+		}
+		log.Warn("Edge link to core frps [%s] is broken, %v, reconnect in %v", EdgeCoreAddr, err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > edgeMaxRetryInterval {
+			delay = edgeMaxRetryInterval
+		}
+	}
+}
+
+// block until the link is broken, connected is true if the core frps was connected
+func runEdgeLinkOnce() (connected bool, err error) {
+	c, err := conn.ConnectServerByTransport(EdgeTransport, EdgeCoreAddr)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	nowTime := time.Now().Unix()
+	req := &msg.ControlReq{
+		Type:      consts.NewEdgeConn,
+		AuthKey:   pcrypto.GetAuthKey(EdgeToken + fmt.Sprintf("%d", nowTime)),
+		Timestamp: nowTime,
+	}
+	buf, _ := json.Marshal(req)
+	if err = c.WriteString(string(buf) + "\n"); err != nil {
+		return false, err
+	}
+	line, err := c.ReadLine()
+	if err != nil {
+		return false, err
+	}
+	res := &msg.ControlRes{}
+	if err = json.Unmarshal([]byte(line), res); err != nil {
+		return false, err
+	}
+	if res.Code != 0 {
+		return false, fmt.Errorf("%s", res.Msg)
+	}
+	log.Info("Edge link to core frps [%s] is established", EdgeCoreAddr)
+
+	session := mux.Client(&bufferedConn{Conn: c.TcpConn, rd: c.Reader}, nil)
+	defer session.Close()
+	syncConn, err := openEdgeStream(session, &msg.EdgeStreamReq{Type: consts.EdgeSync})
+	if err != nil {
+		return true, err
+	}
+	defer syncConn.Close()
+
+	edgeSessionMutex.Lock()
+	edgeSession = session
+	edgeSessionMutex.Unlock()
+	defer func() {
+		edgeSessionMutex.Lock()
+		edgeSession = nil
+		edgeSessionMutex.Unlock()
+	}()
+
+	for {
+		line, err = syncConn.ReadLine()
+		if err != nil {
+			return true, err
+		}
+		proxies := &msg.EdgeProxies{}
+		if err = json.Unmarshal([]byte(line), proxies); err != nil {
+			return true, err
+		}
+		applyEdgeProxies(proxies.Proxies)
+	}
+}
+
+func openEdgeStream(session *mux.Session, req *msg.EdgeStreamReq) (*conn.Conn, error) {
+	st, err := session.Open()
+	if err != nil {
+		return nil, err
+	}
+	c := conn.NewConn(st)
+	buf, _ := json.Marshal(req)
+	if err = c.WriteString(string(buf) + "\n"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// user conns of relayed proxies are sent to the core frps instead of frpc
+func (p *ProxyServer) getRelayConn(userConn *conn.Conn, traceparent string) (*conn.Conn, error) {
+	edgeSessionMutex.RLock()
+	session := edgeSession
+	edgeSessionMutex.RUnlock()
+	if session == nil {
+		return nil, fmt.Errorf("ProxyName [%s], edge link to core frps is broken", p.Name)
+	}
+	return openEdgeStream(session, &msg.EdgeStreamReq{
+		Type:        consts.EdgeUserConn,
+		ProxyName:   p.Name,
+		RemoteAddr:  userConn.GetRemoteAddr(),
+		Traceparent: traceparent,
+	})
+}
+
+// bytes are copied as they are, they are packaged between the core frps and frpc,
+// EOF of one side is passed by half-close and connections are closed after both directions are done
+func joinRelay(name string, userConn io.ReadWriteCloser, relayConn io.ReadWriteCloser) {
+	metric.OpenConnection(name)
+	defer metric.CloseConnection(name)
+	flow := metric.NewFlowRecorder(name, "")
+	defer flow.Close()
+
+	var closeOnce sync.Once
+	closeAll := func() {
+		closeOnce.Do(func() {
+			userConn.Close()
+			relayConn.Close()
+		})
+	}
+	var finished int32
+	var wait sync.WaitGroup
+	pipe := func(to io.WriteCloser, from io.ReadCloser, record func(int64)) {
+		defer wait.Done()
+		_, err := io.Copy(to, &flowReader{Reader: from, record: record})
+		if err == nil && conn.CloseWrite(to) == nil {
+			if atomic.AddInt32(&finished, 1) == 2 {
+				closeAll()
+			}
+			return
+		}
+		closeAll()
+	}
+	wait.Add(2)
+	go pipe(relayConn, userConn, flow.AddIn)
+	go pipe(userConn, relayConn, flow.AddOut)
+	wait.Wait()
+	log.Debug("ProxyName [%s], One relayed tunnel stopped", name)
+}
+
+// flowReader records bytes read from the wrapped reader
+type flowReader struct {
+	io.Reader
+	record func(int64)
+}
+
+func (r *flowReader) Read(b []byte) (n int, err error) {
+	n, err = r.Reader.Read(b)
+	r.record(int64(n))
+	return
+}
+
+// start, restart or close relayed proxies to be the same as the core frps
+func applyEdgeProxies(reqs []*msg.ControlReq) {
+	wanted := make(map[string]*msg.ControlReq)
+	for _, req := range reqs {
+		wanted[req.ProxyName] = req
+	}
+
+	for name, old := range edgeRelayedReqs {
+		req, ok := wanted[name]
+		if ok {
+			buf, _ := json.Marshal(req)
+			if string(buf) == old {
+				delete(wanted, name)
+				continue
+			}
+		}
+		delete(edgeRelayedReqs, name)
+		if p, ok := GetProxyServer(name); ok && p.relayed {
+			p.Close()
+			log.Info("ProxyName [%s], relayed proxy is closed", name)
+		}
+	}
+
+	for name, req := range wanted {
+		if p, ok := GetProxyServer(name); ok && !p.relayed {
+			log.Warn("ProxyName [%s], proxy of core frps is not relayed, a proxy with the same name exists", name)
+			continue
+		}
+		buf, _ := json.Marshal(req)
+		req.PrivilegeMode = true
+		p, err := NewProxyServerFromCtlMsg(req)
+		if err != nil {
+			log.Warn("ProxyName [%s], relay proxy error: %v", name, err)
+			continue
+		}
+		p.SubDomain = req.SubDomain
+		p.relayed = true
+		if err = p.Check(); err != nil {
+			log.Warn("ProxyName [%s], relay proxy error: %v", name, err)
+			continue
+		}
+		if err = CreateProxy(p); err != nil {
+			log.Warn("ProxyName [%s], relay proxy error: %v", name, err)
+			continue
+		}
+		if err = p.Start(nil); err != nil {
+			log.Warn("ProxyName [%s], relay proxy error: %v", name, err)
+			p.Close()
+			continue
+		}
+		edgeRelayedReqs[name] = string(buf)
+		log.Info("ProxyName [%s], relay proxy success", name)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/mux"
+	"github.com/fatedier/frp/src/utils/pcrypto"
+)
+
+func newEdgeReq(token string) *msg.ControlReq {
+	nowTime := time.Now().Unix()
+	return &msg.ControlReq{
+		Type:      consts.NewEdgeConn,
+		AuthKey:   pcrypto.GetAuthKey(token + fmt.Sprintf("%d", nowTime)),
+		Timestamp: nowTime,
+	}
+}
+
+// connect to ServeEdgeLink like an edge node, session is nil if the link is refused
+func connectTestCore(t *testing.T, req *msg.ControlReq) (session *mux.Session, res *msg.ControlRes) {
+	coreConn, edgeConn := net.Pipe()
+	go ServeEdgeLink(conn.NewConn(coreConn), req)
+	c := conn.NewConn(edgeConn)
+	line, err := c.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = &msg.ControlRes{}
+	if err = json.Unmarshal([]byte(line), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != 0 {
+		c.Close()
+		return nil, res
+	}
+	return mux.Client(&bufferedConn{Conn: c.TcpConn, rd: c.Reader}, nil), res
+}
+
+func readEdgeProxies(t *testing.T, c *conn.Conn) []*msg.ControlReq {
+	c.SetReadDeadline(time.Now().Add(3 * edgeSyncInterval))
+	line, err := c.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies := &msg.EdgeProxies{}
+	if err = json.Unmarshal([]byte(line), proxies); err != nil {
+		t.Fatal(err)
+	}
+	return proxies.Proxies
+}
+
+func TestEdgeCore(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+	EdgeToken = "edge"
+	defer func() { EdgeToken = "" }()
+
+	_, res := connectTestCore(t, newEdgeReq("other"))
+	assert.NotEqual(int64(0), res.Code)
+
+	p := newTestProxy(t, "tcp")
+	p.Name = "edge_core"
+	p.PrivilegeMode = true
+	assert.NoError(CreateProxy(p))
+	ctlConn, _ := net.Pipe()
+	c := conn.NewConn(ctlConn)
+	assert.NoError(p.Start(c))
+	noticeDone := make(chan struct{})
+	go serveCtlConn(p, c, noticeDone)
+
+	session, res := connectTestCore(t, newEdgeReq("edge"))
+	if !assert.Equal(int64(0), res.Code) {
+		p.Close()
+		return
+	}
+	syncConn, err := openEdgeStream(session, &msg.EdgeStreamReq{Type: consts.EdgeSync})
+	if !assert.NoError(err) {
+		return
+	}
+	proxies := readEdgeProxies(t, syncConn)
+	if assert.Len(proxies, 1) {
+		assert.Equal("edge_core", proxies[0].ProxyName)
+		assert.Equal(p.ListenPort, proxies[0].RemotePort)
+	}
+
+	// user conns relayed by the edge node are joined with work connections of frpc
+	userConn, err := openEdgeStream(session, &msg.EdgeStreamReq{Type: consts.EdgeUserConn, ProxyName: "edge_core", RemoteAddr: "1.2.3.4:5"})
+	if assert.NoError(err) {
+		userConn.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		userConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = userConn.Read(buf)
+		assert.NoError(err)
+		assert.Equal("hello", string(buf))
+		userConn.Close()
+	}
+
+	// streams of unknown proxies are closed
+	userConn, err = openEdgeStream(session, &msg.EdgeStreamReq{Type: consts.EdgeUserConn, ProxyName: "unknown"})
+	if assert.NoError(err) {
+		userConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = userConn.Read(make([]byte, 1))
+		assert.Error(err)
+		userConn.Close()
+	}
+
+	// removal of the proxy is sent to the edge node
+	p.Close()
+	<-noticeDone
+	assert.Len(readEdgeProxies(t, syncConn), 0)
+
+	session.Close()
+	checkGoroutines(t, n)
+}
+
+// serve streams opened by the edge node like the core frps, user conns are echoed
+func serveTestEdgeStreams(session *mux.Session, names chan string) {
+	for {
+		st, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			c := conn.NewConn(st)
+			defer c.Close()
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			req := &msg.EdgeStreamReq{}
+			json.Unmarshal([]byte(line), req)
+			names <- req.ProxyName
+			buf, err := ioutil.ReadAll(c)
+			if err != nil {
+				return
+			}
+			c.Write(buf)
+		}()
+	}
+}
+
+func TestEdgeNode(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+
+	coreConn, edgeConn := net.Pipe()
+	core := mux.Server(coreConn, nil)
+	edgeSession = mux.Client(edgeConn, nil)
+	names := make(chan string, 10)
+	go serveTestEdgeStreams(core, names)
+	defer func() {
+		edgeSession.Close()
+		edgeSession = nil
+		core.Close()
+	}()
+
+	dial := func(port int64) (string, error) {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		c.Write([]byte("hello"))
+		// the end of the user conn is passed to the core frps by half-close
+		c.(*net.TCPConn).CloseWrite()
+		c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf, err := ioutil.ReadAll(c)
+		return string(buf), err
+	}
+
+	// add
+	req := &msg.ControlReq{ProxyName: "edge_relay", ProxyType: "tcp", RemotePort: freePort(t)}
+	applyEdgeProxies([]*msg.ControlReq{req})
+	p, ok := GetProxyServer("edge_relay")
+	if !assert.True(ok) {
+		return
+	}
+	assert.True(p.relayed)
+	assert.Equal(int64(consts.Working), p.GetStatus())
+	res, err := dial(req.RemotePort)
+	assert.NoError(err)
+	assert.Equal("hello", res)
+	assert.Equal("edge_relay", <-names)
+
+	// unchanged proxies are kept
+	applyEdgeProxies([]*msg.ControlReq{{ProxyName: "edge_relay", ProxyType: "tcp", RemotePort: req.RemotePort}})
+	p2, _ := GetProxyServer("edge_relay")
+	assert.True(p == p2)
+
+	// change
+	oldPort := req.RemotePort
+	req = &msg.ControlReq{ProxyName: "edge_relay", ProxyType: "tcp", RemotePort: freePort(t)}
+	applyEdgeProxies([]*msg.ControlReq{req})
+	assert.Equal(int64(consts.Closed), p.GetStatus())
+	p, ok = GetProxyServer("edge_relay")
+	if assert.True(ok) {
+		assert.Equal(req.RemotePort, p.ListenPort)
+	}
+	_, err = dial(oldPort)
+	assert.Error(err)
+	res, err = dial(req.RemotePort)
+	assert.NoError(err)
+	assert.Equal("hello", res)
+	assert.Equal("edge_relay", <-names)
+
+	// remove
+	applyEdgeProxies(nil)
+	_, ok = GetProxyServer("edge_relay")
+	assert.False(ok)
+	_, err = dial(req.RemotePort)
+	assert.Error(err)
+
+	edgeSession.Close()
+	core.Close()
+	checkGoroutines(t, n)
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/oidc"
+)
+
+const (
+	OidcCallbackPath  = "/.frp/oidc/callback"
+	oidcSessionCookie = "frp_oidc_session"
+	oidcStateCookie   = "frp_oidc_state"
+	oidcStateTimeout  = 10 * time.Minute
+)
+
+type oidcSession struct {
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Groups        []string `json:"groups"`
+}
+
+type oidcState struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+	Url   string `json:"url"`
+}
+
+// oidcAuthenticator protects http proxies by OpenID Connect login,
+// the identity is passed to backend in X-Forwarded-User, X-Forwarded-Email and X-Forwarded-Groups
+type oidcAuthenticator struct {
+	proxyName     string
+	allowedEmails []string
+	allowedGroups []string
+}
+
+func newOidcAuthenticator(p *ProxyServer) *oidcAuthenticator {
+	return &oidcAuthenticator{
+		proxyName:     p.Name,
+		allowedEmails: p.OidcAllowedEmails,
+		allowedGroups: p.OidcAllowedGroups,
+	}
+}
+
+func (a *oidcAuthenticator) Authenticate(req *http.Request) (headers map[string]string, res *http.Response) {
+	if req.URL.Path == OidcCallbackPath {
+		return nil, a.callback(req)
+	}
+
+	session := &oidcSession{}
+	cookie, err := req.Cookie(oidcSessionCookie)
+	if err != nil || oidc.Verify(OidcCookieSecret, cookie.Value, session) != nil {
+		return nil, a.login(req)
+	}
+	if !a.allowed(session) {
+		return nil, oidcResponse(403, "Forbidden", nil)
+	}
+
+	headers = map[string]string{
+		"X-Forwarded-User":   session.Subject,
+		"X-Forwarded-Email":  session.Email,
+		"X-Forwarded-Groups": strings.Join(session.Groups, ","),
+	}
+	return headers, nil
+}
+
+// redirect to login page of identity provider
+func (a *oidcAuthenticator) login(req *http.Request) *http.Response {
+	state := &oidcState{
+		State: oidc.RandomString(),
+		Nonce: oidc.RandomString(),
+		Url:   oidcLocalUrl(req.URL.RequestURI()),
+	}
+	authUrl, err := OidcProvider.AuthCodeURL(oidcRedirectUri(req), state.State, state.Nonce)
+	if err != nil {
+		log.Warn("ProxyName [%s], %v", a.proxyName, err)
+		return oidcResponse(502, "Bad Gateway", nil)
+	}
+	stateValue, err := oidc.Sign(OidcCookieSecret, state, time.Now().Add(oidcStateTimeout))
+	if err != nil {
+		return oidcResponse(500, "Internal Server Error", nil)
+	}
+
+	header := make(http.Header)
+	header.Set("Location", authUrl)
+	header.Add("Set-Cookie", oidcCookie(oidcStateCookie, stateValue, oidcStateTimeout))
+	return oidcResponse(302, "Found", header)
+}
+
+// handle redirection from identity provider and issue a session cookie
+func (a *oidcAuthenticator) callback(req *http.Request) *http.Response {
+	query := req.URL.Query()
+	if errStr := query.Get("error"); errStr != "" {
+		log.Info("ProxyName [%s], oidc login error: %s", a.proxyName, errStr)
+		return oidcResponse(401, "Unauthorized", nil)
+	}
+
+	state := &oidcState{}
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || oidc.Verify(OidcCookieSecret, cookie.Value, state) != nil || state.State != query.Get("state") {
+		return oidcResponse(400, "Bad Request", nil)
+	}
+
+	claims, err := OidcProvider.Exchange(query.Get("code"), oidcRedirectUri(req), state.Nonce)
+	if err != nil {
+		log.Warn("ProxyName [%s], %v", a.proxyName, err)
+		return oidcResponse(401, "Unauthorized", nil)
+	}
+	session := &oidcSession{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Groups:        claims.Groups,
+	}
+	if !a.allowed(session) {
+		log.Info("ProxyName [%s], oidc user [%s] is not allowed", a.proxyName, session.Email)
+		return oidcResponse(403, "Forbidden", nil)
+	}
+
+	ttl := time.Duration(OidcSessionTtl) * time.Second
+	sessionValue, err := oidc.Sign(OidcCookieSecret, session, time.Now().Add(ttl))
+	if err != nil {
+		return oidcResponse(500, "Internal Server Error", nil)
+	}
+	header := make(http.Header)
+	header.Set("Location", oidcLocalUrl(state.Url))
+	header.Add("Set-Cookie", oidcCookie(oidcSessionCookie, sessionValue, ttl))
+	header.Add("Set-Cookie", oidcCookie(oidcStateCookie, "", -1))
+	log.Info("ProxyName [%s], oidc user [%s] login success", a.proxyName, session.Email)
+	return oidcResponse(302, "Found", header)
+}
+
+// if no emails and groups are set, all users who can login are allowed,
+// emails are only matched if they are verified by the identity provider
+func (a *oidcAuthenticator) allowed(session *oidcSession) bool {
+	if len(a.allowedEmails) == 0 && len(a.allowedGroups) == 0 {
+		return true
+	}
+	for _, email := range a.allowedEmails {
+		if session.EmailVerified && session.Email != "" && strings.EqualFold(email, session.Email) {
+			return true
+		}
+	}
+	for _, allowed := range a.allowedGroups {
+		for _, group := range session.Groups {
+			if allowed == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the scheme is set in frps.ini, headers like X-Forwarded-Proto are sent by users and can't be trusted
+func oidcRedirectUri(req *http.Request) string {
+	return fmt.Sprintf("%s://%s%s", OidcRedirectScheme, req.Host, OidcCallbackPath)
+}
+
+// users are only redirected to paths of the same host after login,
+// "//host" and "/\host" are treated as other hosts by browsers
+func oidcLocalUrl(u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return "/"
+	}
+	return u
+}
+
+// value of Set-Cookie header, SameSite is added by hand because http.Cookie supports it since go1.11
+func oidcCookie(name, value string, maxAge time.Duration) string {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   OidcRedirectScheme == "https",
+	}
+	return cookie.String() + "; SameSite=Lax"
+}
+
+func oidcResponse(code int, status string, header http.Header) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	body := fmt.Sprintf("%d %s", code, status)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, status),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
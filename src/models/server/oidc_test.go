@@ -0,0 +1,45 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOidcAllowed(t *testing.T) {
+	assert := assert.New(t)
+	a := &oidcAuthenticator{
+		allowedEmails: []string{"alice@example.com"},
+		allowedGroups: []string{"ops"},
+	}
+	assert.True(a.allowed(&oidcSession{Email: "Alice@example.com", EmailVerified: true}))
+	assert.False(a.allowed(&oidcSession{Email: "alice@example.com"}))
+	assert.True(a.allowed(&oidcSession{Email: "alice@example.com", Groups: []string{"ops"}}))
+	assert.False(a.allowed(&oidcSession{Email: "bob@example.com", EmailVerified: true, Groups: []string{"dev"}}))
+
+	a = &oidcAuthenticator{}
+	assert.True(a.allowed(&oidcSession{Email: "bob@example.com"}))
+}
+
+func TestOidcLocalUrl(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("/", oidcLocalUrl(""))
+	assert.Equal("/a/b?c=d", oidcLocalUrl("/a/b?c=d"))
+	assert.Equal("/", oidcLocalUrl("//evil.com/x"))
+	assert.Equal("/", oidcLocalUrl("/\\evil.com/x"))
+	assert.Equal("/", oidcLocalUrl("http://evil.com/x"))
+}
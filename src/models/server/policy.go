@@ -0,0 +1,65 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	ini "github.com/vaughan0/go-ini"
+)
+
+// Policy of tunnels between frpc and frps, logins of frpc which don't follow it are rejected
+type Policy struct {
+	RequireEncryption bool // use_encryption must be true
+	AllowGzip         bool // use_gzip can be true
+}
+
+// DefaultPolicy is set in the common section, it's used by proxies created in privilege mode
+// and proxies which don't set their own in frps.ini
+var DefaultPolicy = Policy{
+	RequireEncryption: false,
+	AllowGzip:         true,
+}
+
+// options of the common section or a proxy section, values other than true and false are
+// rejected to avoid weakening the policy by typos
+func loadPolicy(policy *Policy, section ini.Section) error {
+	tmpStr, ok := section["require_encryption"]
+	if ok {
+		if tmpStr != "true" && tmpStr != "false" {
+			return fmt.Errorf("require_encryption must be true or false")
+		}
+		policy.RequireEncryption = tmpStr == "true"
+	}
+	tmpStr, ok = section["allow_gzip"]
+	if ok {
+		if tmpStr != "true" && tmpStr != "false" {
+			return fmt.Errorf("allow_gzip must be true or false")
+		}
+		policy.AllowGzip = tmpStr == "true"
+	}
+	return nil
+}
+
+// Check returns an error which tells frpc what to change if the options are denied
+func (policy Policy) Check(useEncryption, useGzip bool) error {
+	if policy.RequireEncryption && !useEncryption {
+		return fmt.Errorf("encryption is required by frps, set use_encryption = true")
+	}
+	if !policy.AllowGzip && useGzip {
+		return fmt.Errorf("gzip is not allowed by frps, set use_gzip = false")
+	}
+	return nil
+}
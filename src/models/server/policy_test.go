@@ -0,0 +1,44 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ini "github.com/vaughan0/go-ini"
+)
+
+func TestPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := DefaultPolicy
+	assert.NoError(loadPolicy(&policy, ini.Section{}))
+	assert.Equal(DefaultPolicy, policy)
+	assert.NoError(policy.Check(false, true))
+
+	assert.NoError(loadPolicy(&policy, ini.Section{"require_encryption": "true", "allow_gzip": "false"}))
+	assert.NoError(policy.Check(true, false))
+	assert.Error(policy.Check(false, false))
+	assert.Error(policy.Check(true, true))
+
+	// a proxy can relax the default policy
+	assert.NoError(loadPolicy(&policy, ini.Section{"require_encryption": "false"}))
+	assert.NoError(policy.Check(false, false))
+	assert.Error(policy.Check(false, true))
+
+	assert.Error(loadPolicy(&policy, ini.Section{"require_encryption": "yes"}))
+	assert.Error(loadPolicy(&policy, ini.Section{"allow_gzip": "1"}))
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/vhost"
+)
+
+func init() {
+	RegisterProxyType("http", &httpProxy{})
+}
+
+type httpProxy struct{}
+
+func (t *httpProxy) subDomain() {}
+
+func (t *httpProxy) LoadConf(p *ProxyServer, section ini.Section) error {
+	p.ListenPort = VhostHttpPort
+	return loadCustomDomains(p, section)
+}
+
+func (t *httpProxy) LoadCtlMsg(p *ProxyServer, req *msg.ControlReq) {
+	if p.PrivilegeMode {
+		p.ListenPort = VhostHttpPort
+		p.CustomDomains = req.CustomDomains
+	}
+	p.HostHeaderRewrite = req.HostHeaderRewrite
+	p.HttpUserName = req.HttpUserName
+	p.HttpPassWord = req.HttpPassWord
+	p.OidcLogin = req.OidcLogin
+	p.OidcAllowedEmails = req.OidcAllowedEmails
+	p.OidcAllowedGroups = req.OidcAllowedGroups
+	p.HttpCompression = req.HttpCompression
+}
+
+func (t *httpProxy) Check(p *ProxyServer) error {
+	if err := checkCustomDomains(p); err != nil {
+		return err
+	}
+	if VhostHttpMuxer == nil {
+		return fmt.Errorf("type [http] not support when vhost_http_port is not set")
+	}
+	if p.OidcLogin && OidcProvider == nil {
+		return fmt.Errorf("oidc login is not supported because oidc_issuer is not set in frps")
+	}
+	if err := vhost.CheckEncodings(p.HttpCompression); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *httpProxy) Listen(p *ProxyServer) error {
+	routeConfig := &vhost.VhostRouteConfig{
+		RewriteHost: p.HostHeaderRewrite,
+		Username:    p.HttpUserName,
+		Password:    p.HttpPassWord,
+	}
+	if p.OidcLogin {
+		routeConfig.Authenticator = newOidcAuthenticator(p)
+	}
+	if len(p.HttpCompression) > 0 {
+		routeConfig.Compression = &vhost.HttpCompression{
+			Encodings:    p.HttpCompression,
+			MinSize:      HttpCompressionMinSize,
+			ContentTypes: HttpCompressionTypes,
+		}
+	}
+	for _, domain := range p.CustomDomains {
+		routeConfig.Domain = domain
+		l, err := VhostHttpMuxer.Listen(routeConfig)
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+	}
+	if p.SubDomain != "" {
+		routeConfig.Domain = p.SubDomain
+		l, err := VhostHttpMuxer.Listen(routeConfig)
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+	}
+	return nil
+}
+
+func (t *httpProxy) Serve(p *ProxyServer) {
+	p.serveListeners()
+}
+
+func (t *httpProxy) SetMetrics(p *ProxyServer) {
+	metric.SetDomains(p.Name, p.domains())
+}
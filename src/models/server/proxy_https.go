@@ -0,0 +1,103 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/vhost"
+)
+
+func init() {
+	RegisterProxyType("https", &httpsProxy{})
+}
+
+type httpsProxy struct{}
+
+func (t *httpsProxy) subDomain() {}
+
+func (t *httpsProxy) LoadConf(p *ProxyServer, section ini.Section) (err error) {
+	p.ListenPort = VhostHttpsPort
+	if err = loadCustomDomains(p, section); err != nil {
+		return err
+	}
+
+	// terminate TLS in frps and verify client certificates
+	p.TlsCert, _ = section["tls_cert"]
+	p.TlsKey, _ = section["tls_key"]
+	p.ClientCa, _ = section["client_ca"]
+	if p.ClientCa != "" && p.TlsCert == "" {
+		return fmt.Errorf("tls_cert and tls_key must be set when client_ca is set")
+	}
+	if p.TlsCert != "" {
+		p.tlsConfig, err = newVhostTlsConfig(p.TlsCert, p.TlsKey, p.ClientCa)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *httpsProxy) LoadCtlMsg(p *ProxyServer, req *msg.ControlReq) {
+	if p.PrivilegeMode {
+		p.ListenPort = VhostHttpsPort
+		p.CustomDomains = req.CustomDomains
+	}
+}
+
+func (t *httpsProxy) Check(p *ProxyServer) error {
+	if err := checkCustomDomains(p); err != nil {
+		return err
+	}
+	if VhostHttpsMuxer == nil {
+		return fmt.Errorf("type [https] not support when vhost_https_port is not set")
+	}
+	return nil
+}
+
+func (t *httpsProxy) Listen(p *ProxyServer) error {
+	routeConfig := &vhost.VhostRouteConfig{
+		TlsConfig: p.tlsConfig,
+	}
+	for _, domain := range p.CustomDomains {
+		routeConfig.Domain = domain
+		l, err := VhostHttpsMuxer.Listen(routeConfig)
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+	}
+	if p.SubDomain != "" {
+		routeConfig.Domain = p.SubDomain
+		l, err := VhostHttpsMuxer.Listen(routeConfig)
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+	}
+	return nil
+}
+
+func (t *httpsProxy) Serve(p *ProxyServer) {
+	p.serveListeners()
+}
+
+func (t *httpsProxy) SetMetrics(p *ProxyServer) {
+	metric.SetDomains(p.Name, p.domains())
+}
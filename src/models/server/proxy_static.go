@@ -0,0 +1,128 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/vhost"
+)
+
+func init() {
+	RegisterProxyType("static", &staticProxy{})
+}
+
+// requests for custom_domains are answered by frps with a redirect or a fixed response, frpc is not needed
+type staticProxy struct{}
+
+func (t *staticProxy) standalone() {}
+
+func (t *staticProxy) LoadConf(p *ProxyServer, section ini.Section) error {
+	p.ListenPort = VhostHttpPort
+	if err := loadCustomDomains(p, section); err != nil {
+		return err
+	}
+
+	s := &vhost.StaticResponder{
+		Redirect:         section["redirect"],
+		RedirectCode:     http.StatusMovedPermanently,
+		RedirectKeepPath: section["redirect_keep_path"] != "false",
+		Path:             section["path"],
+		StatusCode:       http.StatusOK,
+		ContentType:      "text/plain; charset=utf-8",
+		Body:             []byte(section["body"]),
+	}
+	if tmpStr, ok := section["redirect_code"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v < 300 || v > 399 {
+			return fmt.Errorf("redirect_code error")
+		}
+		s.RedirectCode = v
+	}
+	if tmpStr, ok := section["status_code"]; ok {
+		v, err := strconv.Atoi(tmpStr)
+		if err != nil || v < 200 || v > 599 {
+			return fmt.Errorf("status_code error")
+		}
+		s.StatusCode = v
+	}
+	if tmpStr, ok := section["content_type"]; ok {
+		s.ContentType = tmpStr
+	}
+	if tmpStr, ok := section["body_file"]; ok {
+		body, err := ioutil.ReadFile(tmpStr)
+		if err != nil {
+			return fmt.Errorf("read body_file error: %v", err)
+		}
+		s.Body = body
+	}
+	p.staticResponder = s
+	return nil
+}
+
+func (t *staticProxy) LoadCtlMsg(p *ProxyServer, req *msg.ControlReq) {}
+
+func (t *staticProxy) Check(p *ProxyServer) error {
+	return fmt.Errorf("type [static] is served by frps and can't be used by frpc")
+}
+
+func (t *staticProxy) Listen(p *ProxyServer) error {
+	if VhostHttpMuxer == nil {
+		return fmt.Errorf("type [static] not support when vhost_http_port is not set")
+	}
+	routeConfig := &vhost.VhostRouteConfig{}
+	for _, domain := range p.CustomDomains {
+		routeConfig.Domain = domain
+		l, err := VhostHttpMuxer.Listen(routeConfig)
+		if err != nil {
+			return err
+		}
+		p.listeners = append(p.listeners, l)
+	}
+	return nil
+}
+
+func (t *staticProxy) Serve(p *ProxyServer) {
+	for _, listener := range p.listeners {
+		go func(l Listener) {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					log.Info("ProxyName [%s], listener is closed", p.Name)
+					return
+				}
+				go func(c *conn.Conn) {
+					c.Trace.SetAttribute("proxy_name", p.Name)
+					c.Trace.SetAttribute("proxy_type", p.Type)
+					p.staticResponder.Serve(c)
+					c.Trace.End()
+				}(c)
+			}
+		}(listener)
+	}
+}
+
+func (t *staticProxy) SetMetrics(p *ProxyServer) {
+	metric.SetDomains(p.Name, p.CustomDomains)
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+)
+
+func init() {
+	RegisterProxyType("tcp", &tcpProxy{})
+}
+
+type tcpProxy struct{}
+
+func (t *tcpProxy) LoadConf(p *ProxyServer, section ini.Section) error {
+	return loadListenConf(p, section)
+}
+
+// bind_addr and listen_port are used by tcp and udp proxies
+func loadListenConf(p *ProxyServer, section ini.Section) (err error) {
+	var ok bool
+	p.BindAddr, ok = section["bind_addr"]
+	if !ok {
+		p.BindAddr = "0.0.0.0"
+	}
+
+	portStr, ok := section["listen_port"]
+	if !ok {
+		return fmt.Errorf("listen_port not found")
+	}
+	p.ListenPort, err = strconv.ParseInt(portStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("listen_port error")
+	}
+	return nil
+}
+
+func (t *tcpProxy) LoadCtlMsg(p *ProxyServer, req *msg.ControlReq) {
+	if p.PrivilegeMode {
+		p.ListenPort = req.RemotePort
+	}
+}
+
+// we check listen_port if privilege_allow_ports are set and PrivilegeMode is enabled
+func (t *tcpProxy) Check(p *ProxyServer) error {
+	if p.PrivilegeMode && len(PrivilegeAllowPorts) != 0 {
+		if _, ok := PrivilegeAllowPorts[p.ListenPort]; !ok {
+			return fmt.Errorf("remote_port [%d] isn't allowed", p.ListenPort)
+		}
+	}
+	return nil
+}
+
+func (t *tcpProxy) Listen(p *ProxyServer) error {
+	l, err := conn.Listen(p.BindAddr, p.ListenPort)
+	if err != nil {
+		return err
+	}
+	p.listeners = append(p.listeners, l)
+	return nil
+}
+
+func (t *tcpProxy) Serve(p *ProxyServer) {
+	p.serveListeners()
+}
+
+func (t *tcpProxy) SetMetrics(p *ProxyServer) {
+	metric.SetListenPort(p.Name, p.ListenPort)
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+)
+
+// ProxyType implements all behaviours which are different between proxy types,
+// a new type is added by calling RegisterProxyType in init function of its own file
+type ProxyType interface {
+	// parse type specific options of a proxy section in frps.ini
+	LoadConf(p *ProxyServer, section ini.Section) error
+
+	// set type specific options from the login message of frpc,
+	// listen port and domains are only used if the proxy is created in privilege mode
+	LoadCtlMsg(p *ProxyServer, req *msg.ControlReq)
+
+	// check if the proxy can be started by frpc
+	Check(p *ProxyServer) error
+
+	// create listeners for user connections, it's called before the proxy is working
+	Listen(p *ProxyServer) error
+
+	// start handling user connections, it's called after the proxy is working and must not block
+	Serve(p *ProxyServer)
+
+	// record type specific information like listen port or domains in metrics,
+	// it's called after the common information is recorded
+	SetMetrics(p *ProxyServer)
+}
+
+var (
+	proxyTypes      map[string]ProxyType = make(map[string]ProxyType)
+	proxyTypesMutex sync.RWMutex
+)
+
+func RegisterProxyType(name string, t ProxyType) {
+	proxyTypesMutex.Lock()
+	defer proxyTypesMutex.Unlock()
+	if _, ok := proxyTypes[name]; ok {
+		panic(fmt.Sprintf("proxy type [%s] is already registered", name))
+	}
+	proxyTypes[name] = t
+}
+
+func GetProxyType(name string) (t ProxyType, ok bool) {
+	proxyTypesMutex.RLock()
+	defer proxyTypesMutex.RUnlock()
+	t, ok = proxyTypes[name]
+	return
+}
+
+// proxy types served by frps itself implement it, these proxies are started
+// when frps.ini is loaded and they can't be used by frpc
+type standaloneProxyType interface {
+	standalone()
+}
+
+func (p *ProxyServer) IsStandalone() bool {
+	t, ok := GetProxyType(p.Type)
+	if !ok {
+		return false
+	}
+	_, ok = t.(standaloneProxyType)
+	return ok
+}
+
+// proxy types routed by domains implement it, the full domain of subdomain is composed with SubDomainHost
+type subDomainProxyType interface {
+	subDomain()
+}
+
+// SetMetrics records the proxy's information shown in dashboard
+func (p *ProxyServer) SetMetrics() {
+	metric.SetProxyInfo(p.Name, p.Type, p.BindAddr, p.UseEncryption, p.UseGzip, p.PrivilegeMode)
+	metric.SetPolicy(p.Name, p.Policy.RequireEncryption, p.Policy.AllowGzip)
+	if t, ok := GetProxyType(p.Type); ok {
+		t.SetMetrics(p)
+	}
+}
+
+func (p *ProxyServer) proxyType() (ProxyType, error) {
+	t, ok := GetProxyType(p.Type)
+	if !ok {
+		return nil, fmt.Errorf("proxy type [%s] is not supported", p.Type)
+	}
+	return t, nil
+}
+
+// custom_domains is required by vhost proxies in frps.ini
+func loadCustomDomains(p *ProxyServer, section ini.Section) error {
+	domainStr, ok := section["custom_domains"]
+	if !ok {
+		return fmt.Errorf("custom_domains must be set when type is %s", p.Type)
+	}
+	p.CustomDomains = strings.Split(domainStr, ",")
+	for i, domain := range p.CustomDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		// custom domain should not belong to subdomain_host
+		if SubDomainHost != "" && strings.Contains(domain, SubDomainHost) {
+			return fmt.Errorf("custom domain should not belong to subdomain_host")
+		}
+		p.CustomDomains[i] = domain
+	}
+	return nil
+}
+
+// domains of vhost proxies created in privilege mode should not belong to subdomain_host
+func checkCustomDomains(p *ProxyServer) error {
+	if !p.PrivilegeMode {
+		return nil
+	}
+	for _, domain := range p.CustomDomains {
+		if SubDomainHost != "" && strings.Contains(domain, SubDomainHost) {
+			return fmt.Errorf("custom domain [%s] should not belong to subdomain_host [%s]", domain, SubDomainHost)
+		}
+	}
+	return nil
+}
+
+// VerifyDomains checks the ownership of custom domains of proxies created in privilege mode
+// if DomainVerifier is set, it may take seconds and should be called after other checks
+func (p *ProxyServer) VerifyDomains() error {
+	if !p.PrivilegeMode || DomainVerifier == nil || len(p.CustomDomains) == 0 {
+		return nil
+	}
+	if p.DomainId == "" {
+		return fmt.Errorf("domain_secret must be set in frpc to verify custom domains")
+	}
+	for _, domain := range p.CustomDomains {
+		if err := DomainVerifier.Verify(p.DomainId, domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSubDomain sets the full domain of subdomain from frpc, it's only supported by types implementing subDomainProxyType
+func (p *ProxyServer) LoadSubDomain(subDomain string) error {
+	if subDomain == "" {
+		return nil
+	}
+	t, ok := GetProxyType(p.Type)
+	if ok {
+		_, ok = t.(subDomainProxyType)
+	}
+	if !ok {
+		return fmt.Errorf("subdomain is not supported when type is %s", p.Type)
+	}
+	if strings.Contains(subDomain, ".") || strings.Contains(subDomain, "*") {
+		return fmt.Errorf("'.' or '*' is not supported in subdomain")
+	}
+	if SubDomainHost == "" {
+		return fmt.Errorf("subdomain in not supported because this feature is not enabled by remote server")
+	}
+	p.SubDomain = subDomain + "." + SubDomainHost
+	return nil
+}
+
+// domains returns custom domains and the full domain of subdomain
+func (p *ProxyServer) domains() []string {
+	if p.SubDomain == "" {
+		return p.CustomDomains
+	}
+	domains := make([]string, 0, len(p.CustomDomains)+1)
+	domains = append(domains, p.CustomDomains...)
+	return append(domains, p.SubDomain)
+}
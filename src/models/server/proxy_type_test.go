@@ -0,0 +1,206 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/verify"
+	"github.com/fatedier/frp/src/utils/vhost"
+)
+
+func TestProxyTypeRegistry(t *testing.T) {
+	assert := assert.New(t)
+	for _, name := range []string{"tcp", "udp", "http", "https", "static"} {
+		_, ok := GetProxyType(name)
+		assert.True(ok, name)
+	}
+	assert.Panics(func() { RegisterProxyType("tcp", &tcpProxy{}) })
+
+	_, ok := GetProxyType("unknown")
+	assert.False(ok)
+	_, err := NewProxyServerFromCtlMsg(&msg.ControlReq{ProxyName: "unknown", ProxyType: "unknown"})
+	assert.Error(err)
+
+	p := NewProxyServer()
+	p.Type = "unknown"
+	assert.False(p.IsStandalone())
+	p.Type = "tcp"
+	assert.False(p.IsStandalone())
+	p.Type = "static"
+	assert.True(p.IsStandalone())
+}
+
+func TestProxyTypeSetMetrics(t *testing.T) {
+	assert := assert.New(t)
+	p := NewProxyServer()
+	p.Name = "metrics_tcp"
+	p.Type = "tcp"
+	p.ListenPort = 6000
+	p.SetMetrics()
+	m := metric.GetProxyMetrics(p.Name)
+	if assert.NotNil(m) {
+		assert.Equal("tcp", m.Type)
+		assert.Equal(int64(6000), m.ListenPort)
+	}
+
+	p = NewProxyServer()
+	p.Name = "metrics_http"
+	p.Type = "http"
+	p.CustomDomains = []string{"example.com"}
+	p.SubDomain = "app.example.org"
+	p.SetMetrics()
+	m = metric.GetProxyMetrics(p.Name)
+	if assert.NotNil(m) {
+		assert.Equal([]string{"example.com", "app.example.org"}, m.CustomDomains)
+		assert.Equal(int64(0), m.ListenPort)
+	}
+	assert.Equal([]string{"example.com"}, p.CustomDomains)
+}
+
+func TestLoadSubDomain(t *testing.T) {
+	assert := assert.New(t)
+	p := newTestProxy(t, "https")
+	assert.Error(p.LoadSubDomain("app"))
+
+	SubDomainHost = "example.com"
+	defer func() { SubDomainHost = "" }()
+	assert.NoError(p.LoadSubDomain(""))
+	assert.Equal("", p.SubDomain)
+	assert.Error(p.LoadSubDomain("a.b"))
+	assert.Error(p.LoadSubDomain("*"))
+	assert.NoError(p.LoadSubDomain("app"))
+	assert.Equal("app.example.com", p.SubDomain)
+
+	for _, proxyType := range []string{"tcp", "udp", "static"} {
+		p = newTestProxy(t, proxyType)
+		assert.Error(p.LoadSubDomain("app"), proxyType)
+	}
+}
+
+// clientHello returns the first message of a tls handshake to serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go tls.Client(c1, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	buf := make([]byte, 4096)
+	n, err := c2.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Close()
+	return buf[:n]
+}
+
+func TestHttpsSubDomain(t *testing.T) {
+	assert := assert.New(t)
+	port := freePort(t)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	l, err := conn.Listen("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	VhostHttpsMuxer, err = vhost.NewHttpsMuxer(l, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SubDomainHost = "example.com"
+	defer func() {
+		VhostHttpsMuxer = nil
+		SubDomainHost = ""
+	}()
+
+	p := newTestProxy(t, "https")
+	p.PrivilegeMode = true
+	assert.NoError(p.LoadSubDomain("app"))
+	assert.NoError(p.Check())
+	ctlConn, _ := net.Pipe()
+	c := conn.NewConn(ctlConn)
+	if !assert.NoError(p.Start(c)) {
+		return
+	}
+	noticeDone := make(chan struct{})
+	go serveCtlConn(p, c, noticeDone)
+
+	// bytes of tls connections are passed to frpc as they are, the local service echoes them
+	user, err := net.Dial("tcp", addr)
+	if assert.NoError(err) {
+		hello := clientHello(t, "app.example.com")
+		user.Write(hello)
+		user.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, len(hello))
+		_, err = io.ReadFull(user, buf)
+		assert.NoError(err)
+		assert.Equal(hello, buf)
+		user.Close()
+	}
+
+	// other names aren't routed to the proxy
+	user, err = net.Dial("tcp", addr)
+	if assert.NoError(err) {
+		user.Write(clientHello(t, "other.example.com"))
+		user.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = user.Read(make([]byte, 1))
+		assert.Error(err)
+		user.Close()
+	}
+
+	p.Close()
+	<-noticeDone
+}
+
+func TestVerifyDomains(t *testing.T) {
+	assert := assert.New(t)
+	v, err := verify.NewDomainVerifier("secret", []string{verify.MethodDns}, time.Hour)
+	if !assert.NoError(err) {
+		return
+	}
+	records := make(map[string][]string)
+	v.LookupTXT = func(name string) ([]string, error) {
+		if r, ok := records[name]; ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+	DomainVerifier = v
+	defer func() { DomainVerifier = nil }()
+
+	p := NewProxyServer()
+	p.Name = "web"
+	p.Type = "http"
+	p.CustomDomains = []string{"a.example.com"}
+	assert.NoError(p.VerifyDomains())
+
+	p.PrivilegeMode = true
+	assert.Error(p.VerifyDomains())
+	p.DomainId = "alice"
+	records["_frp-challenge.a.example.com"] = []string{v.Token("alice", "a.example.com")}
+	assert.NoError(p.VerifyDomains())
+
+	// other clients can't claim the domain with the same proxy name
+	p.DomainId = "mallory"
+	assert.Error(p.VerifyDomains())
+}
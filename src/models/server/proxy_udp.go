@@ -0,0 +1,83 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net"
+
+	ini "github.com/vaughan0/go-ini"
+
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/log"
+	"github.com/fatedier/frp/src/utils/pool"
+)
+
+func init() {
+	RegisterProxyType("udp", &udpProxy{})
+}
+
+// udp packets are forwarded through one work connection registered by RegisterNewWorkConnUdp
+type udpProxy struct{}
+
+func (t *udpProxy) LoadConf(p *ProxyServer, section ini.Section) error {
+	return loadListenConf(p, section)
+}
+
+func (t *udpProxy) LoadCtlMsg(p *ProxyServer, req *msg.ControlReq) {
+	if p.PrivilegeMode {
+		p.ListenPort = req.RemotePort
+	}
+}
+
+func (t *udpProxy) Check(p *ProxyServer) error {
+	return nil
+}
+
+func (t *udpProxy) Listen(p *ProxyServer) (err error) {
+	p.udpConn, err = conn.ListenUDP(p.BindAddr, p.ListenPort)
+	if err != nil {
+		log.Warn("ProxyName [%s], listen udp port error: %v", p.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (t *udpProxy) Serve(p *ProxyServer) {
+	udpConn := p.udpConn
+	go func() {
+		for {
+			buf := pool.GetBuf(2048)
+			n, remoteAddr, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				log.Info("ProxyName [%s], udp listener is closed", p.Name)
+				return
+			}
+			localAddr, _ := net.ResolveUDPAddr("udp", udpConn.LocalAddr().String())
+			udpPacket := msg.NewUdpPacket(buf[0:n], remoteAddr, localAddr)
+			select {
+			case p.udpSenderChan <- udpPacket:
+			default:
+				log.Warn("ProxyName [%s], udp sender channel is full", p.Name)
+			}
+			pool.PutBuf(buf)
+		}
+	}()
+}
+
+func (t *udpProxy) SetMetrics(p *ProxyServer) {
+	metric.SetListenPort(p.Name, p.ListenPort)
+}
@@ -15,8 +15,11 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"reflect"
 	"sync"
 	"time"
 
@@ -26,7 +29,8 @@ import (
 	"github.com/fatedier/frp/src/models/msg"
 	"github.com/fatedier/frp/src/utils/conn"
 	"github.com/fatedier/frp/src/utils/log"
-	"github.com/fatedier/frp/src/utils/pool"
+	"github.com/fatedier/frp/src/utils/trace"
+	"github.com/fatedier/frp/src/utils/vhost"
 )
 
 type Listener interface {
@@ -40,17 +44,48 @@ type ProxyServer struct {
 	ListenPort    int64
 	CustomDomains []string
 
+	// identity of the privilege client which claims CustomDomains, see VerifyDomains,
+	// it's derived from domain_secret of frpc and is exported to state files but not to edge nodes
+	DomainId string
+
+	// only for https proxies configured in frps.ini, TLS is terminated by frps if TlsCert is set
+	// and users must present a client certificate signed by ClientCa if it is set
+	TlsCert   string
+	TlsKey    string
+	ClientCa  string
+	tlsConfig *tls.Config
+
+	// logins of frpc are checked by it, see Policy
+	Policy Policy
+
+	// only for static proxies
+	staticResponder *vhost.StaticResponder
+
+	// user conns are relayed to the core frps if the proxy is synchronized from it by the edge link
+	relayed bool
+
 	Status      int64
 	CtlConn     *conn.Conn // control connection with frpc
 	WorkConnUdp *conn.Conn // work connection for udp
 
+	// stop goroutines of WorkConnUdp
+	workConnUdpCancel context.CancelFunc
+
 	udpConn       *net.UDPConn
 	listeners     []Listener      // accept new connection from remote users
-	ctlMsgChan    chan int64      // every time accept a new user conn, put "1" to the channel
+	ctlMsgChan    chan string     // every time accept a new user conn, put its traceparent to the channel
 	workConnChan  chan *conn.Conn // get new work conns from control goroutine
 	udpSenderChan chan *msg.UdpPacket
 	mutex         sync.RWMutex
-	closeChan     chan struct{} // close this channel for notifying other goroutines that the proxy is closed
+
+	// ctx is canceled when the control connection is broken or the proxy is closed,
+	// goroutines serving the control connection stop then
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// only used when the proxy is waiting for frpc to reconnect
+	reconnectChan chan struct{} // closed when frpc reconnects or the proxy is closed
+	queuedConns   int64         // number of user conns waiting for reconnection
 }
 
 func NewProxyServer() (p *ProxyServer) {
@@ -60,7 +95,7 @@ func NewProxyServer() (p *ProxyServer) {
 	return p
 }
 
-func NewProxyServerFromCtlMsg(req *msg.ControlReq) (p *ProxyServer) {
+func NewProxyServerFromCtlMsg(req *msg.ControlReq) (p *ProxyServer, err error) {
 	p = &ProxyServer{}
 	p.Name = req.ProxyName
 	p.Type = req.ProxyType
@@ -68,36 +103,102 @@ func NewProxyServerFromCtlMsg(req *msg.ControlReq) (p *ProxyServer) {
 	p.UseGzip = req.UseGzip
 	p.PrivilegeMode = req.PrivilegeMode
 	p.PrivilegeToken = PrivilegeToken
+	p.DomainId = req.DomainId
 	p.BindAddr = BindAddr
-	if p.Type == "tcp" || p.Type == "udp" {
-		p.ListenPort = req.RemotePort
-	} else if p.Type == "http" {
-		p.ListenPort = VhostHttpPort
-	} else if p.Type == "https" {
-		p.ListenPort = VhostHttpsPort
-	}
-	p.CustomDomains = req.CustomDomains
-	p.HostHeaderRewrite = req.HostHeaderRewrite
-	p.HttpUserName = req.HttpUserName
-	p.HttpPassWord = req.HttpPassWord
+	p.Policy = DefaultPolicy
+	err = p.LoadCtlMsg(req)
 	return
 }
 
+// CopyConf returns a new proxy with the configure of p,
+// options from frpc are checked on the copy before p is changed
+func (p *ProxyServer) CopyConf() *ProxyServer {
+	return &ProxyServer{
+		BaseConf:        p.BaseConf,
+		BindAddr:        p.BindAddr,
+		ListenPort:      p.ListenPort,
+		CustomDomains:   p.CustomDomains,
+		DomainId:        p.DomainId,
+		TlsCert:         p.TlsCert,
+		TlsKey:          p.TlsKey,
+		ClientCa:        p.ClientCa,
+		tlsConfig:       p.tlsConfig,
+		Policy:          p.Policy,
+		staticResponder: p.staticResponder,
+	}
+}
+
+// set type specific options from the login message of frpc
+func (p *ProxyServer) LoadCtlMsg(req *msg.ControlReq) error {
+	t, err := p.proxyType()
+	if err != nil {
+		return err
+	}
+	t.LoadCtlMsg(p, req)
+	return nil
+}
+
+// toCtlMsg returns the login message of the proxy, SubDomain in it is the full domain
+func (p *ProxyServer) toCtlMsg() *msg.ControlReq {
+	return &msg.ControlReq{
+		ProxyName:         p.Name,
+		ProxyType:         p.Type,
+		UseEncryption:     p.UseEncryption,
+		UseGzip:           p.UseGzip,
+		PoolCount:         p.PoolCount,
+		PrivilegeMode:     p.PrivilegeMode,
+		RemotePort:        p.ListenPort,
+		CustomDomains:     p.CustomDomains,
+		SubDomain:         p.SubDomain,
+		HostHeaderRewrite: p.HostHeaderRewrite,
+		HttpUserName:      p.HttpUserName,
+		HttpPassWord:      p.HttpPassWord,
+		OidcLogin:         p.OidcLogin,
+		OidcAllowedEmails: p.OidcAllowedEmails,
+		OidcAllowedGroups: p.OidcAllowedGroups,
+		HttpCompression:   p.HttpCompression,
+	}
+}
+
+// for sort, login messages are sorted by proxy names
+type ctlMsgList []*msg.ControlReq
+
+func (l ctlMsgList) Len() int           { return len(l) }
+func (l ctlMsgList) Less(i, j int) bool { return l[i].ProxyName < l[j].ProxyName }
+func (l ctlMsgList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// check if the proxy can be started by frpc
+func (p *ProxyServer) Check() error {
+	t, err := p.proxyType()
+	if err != nil {
+		return err
+	}
+	return t.Check(p)
+}
+
 func (p *ProxyServer) Init() {
 	p.Lock()
 	p.Status = consts.Idle
 	metric.SetStatus(p.Name, p.Status)
 	p.workConnChan = make(chan *conn.Conn, p.PoolCount+10)
-	p.ctlMsgChan = make(chan int64, p.PoolCount+10)
+	p.ctlMsgChan = make(chan string, p.PoolCount+10)
 	p.udpSenderChan = make(chan *msg.UdpPacket, 1024)
 	p.listeners = make([]Listener, 0)
-	p.closeChan = make(chan struct{})
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	p.Unlock()
 }
 
+func (p *ProxyServer) GetStatus() int64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.Status
+}
+
 func (p *ProxyServer) Compare(p2 *ProxyServer) bool {
 	if p.Name != p2.Name || p.AuthToken != p2.AuthToken || p.Type != p2.Type ||
-		p.BindAddr != p2.BindAddr || p.ListenPort != p2.ListenPort || p.HostHeaderRewrite != p2.HostHeaderRewrite {
+		p.BindAddr != p2.BindAddr || p.ListenPort != p2.ListenPort || p.HostHeaderRewrite != p2.HostHeaderRewrite ||
+		p.TlsCert != p2.TlsCert || p.TlsKey != p2.TlsKey || p.ClientCa != p2.ClientCa || p.Policy != p2.Policy ||
+		!reflect.DeepEqual(p.staticResponder, p2.staticResponder) {
 		return false
 	}
 	if len(p.CustomDomains) != len(p2.CustomDomains) {
@@ -111,6 +212,28 @@ func (p *ProxyServer) Compare(p2 *ProxyServer) bool {
 	return true
 }
 
+// CompareLogin returns true if p2 is created by a login with the same options as p,
+// then p is resumed by the login instead of being restarted
+func (p *ProxyServer) CompareLogin(p2 *ProxyServer) bool {
+	return p.Compare(p2) && p.SubDomain == p2.SubDomain && p.DomainId == p2.DomainId && p.PoolCount == p2.PoolCount &&
+		p.UseEncryption == p2.UseEncryption && p.UseGzip == p2.UseGzip &&
+		p.HttpUserName == p2.HttpUserName && p.HttpPassWord == p2.HttpPassWord && p.OidcLogin == p2.OidcLogin &&
+		equalStrings(p.OidcAllowedEmails, p2.OidcAllowedEmails) && equalStrings(p.OidcAllowedGroups, p2.OidcAllowedGroups) &&
+		equalStrings(p.HttpCompression, p2.HttpCompression)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *ProxyServer) Lock() {
 	p.mutex.Lock()
 }
@@ -123,127 +246,119 @@ func (p *ProxyServer) Unlock() {
 func (p *ProxyServer) Start(c *conn.Conn) (err error) {
 	p.CtlConn = c
 	p.Init()
-	if p.Type == "tcp" {
-		l, err := conn.Listen(p.BindAddr, p.ListenPort)
-		if err != nil {
-			return err
-		}
-		p.listeners = append(p.listeners, l)
-	} else if p.Type == "http" {
-		for _, domain := range p.CustomDomains {
-			l, err := VhostHttpMuxer.Listen(domain, p.HostHeaderRewrite, p.HttpUserName, p.HttpPassWord)
-			if err != nil {
-				return err
-			}
-			p.listeners = append(p.listeners, l)
-		}
-		if p.SubDomain != "" {
-			l, err := VhostHttpMuxer.Listen(p.SubDomain, p.HostHeaderRewrite, p.HttpUserName, p.HttpPassWord)
-			if err != nil {
-				return err
-			}
-			p.listeners = append(p.listeners, l)
-		}
-
-	} else if p.Type == "https" {
-		for _, domain := range p.CustomDomains {
-			l, err := VhostHttpsMuxer.Listen(domain, p.HostHeaderRewrite, p.HttpUserName, p.HttpPassWord)
-			if err != nil {
-				return err
-			}
-			p.listeners = append(p.listeners, l)
-		}
+	t, err := p.proxyType()
+	if err != nil {
+		return err
+	}
+	if err = t.Listen(p); err != nil {
+		return err
 	}
 
 	p.Lock()
 	p.Status = consts.Working
+	if p.PoolCount > 0 {
+		go p.connectionPoolManager(p.ctx)
+	}
 	p.Unlock()
-	metric.SetStatus(p.Name, p.Status)
+	metric.SetStatus(p.Name, consts.Working)
 
-	if p.Type == "udp" {
-		// udp is special
-		p.udpConn, err = conn.ListenUDP(p.BindAddr, p.ListenPort)
-		if err != nil {
-			log.Warn("ProxyName [%s], listen udp port error: %v", p.Name, err)
-			return err
-		}
-		go func() {
+	t.Serve(p)
+	return nil
+}
+
+// accept user connections from all listeners and join them with work connections
+func (p *ProxyServer) serveListeners() {
+	// start a goroutine for every listener to accept user connection
+	for _, listener := range p.listeners {
+		go func(l Listener) {
 			for {
-				buf := pool.GetBuf(2048)
-				n, remoteAddr, err := p.udpConn.ReadFromUDP(buf)
+				// block
+				// if listener is closed, err returned
+				c, err := l.Accept()
 				if err != nil {
-					log.Info("ProxyName [%s], udp listener is closed", p.Name)
+					log.Info("ProxyName [%s], listener is closed", p.Name)
 					return
 				}
-				localAddr, _ := net.ResolveUDPAddr("udp", p.udpConn.LocalAddr().String())
-				udpPacket := msg.NewUdpPacket(buf[0:n], remoteAddr, localAddr)
-				select {
-				case p.udpSenderChan <- udpPacket:
-				default:
-					log.Warn("ProxyName [%s], udp sender channel is full", p.Name)
-				}
-				pool.PutBuf(buf)
+				log.Debug("ProxyName [%s], get one new user conn [%s]", p.Name, c.GetRemoteAddr())
+				p.HandleUserConn(c)
 			}
-		}()
-	} else {
-		// create connection pool if needed
-		if p.PoolCount > 0 {
-			go p.connectionPoolManager(p.closeChan)
+		}(listener)
+	}
+}
+
+// HandleUserConn joins the user conn with a work connection in a new goroutine,
+// user conns are accepted from listeners or relayed by edge nodes
+func (p *ProxyServer) HandleUserConn(c *conn.Conn) {
+	span := c.Trace
+	if span == nil {
+		span = trace.Start("frps.user_conn", "")
+	}
+	span.SetAttribute("proxy_name", p.Name)
+	span.SetAttribute("proxy_type", p.Type)
+
+	status := p.GetStatus()
+	if status != consts.Working && status != consts.Reconnecting {
+		log.Debug("ProxyName [%s] is not working, new user conn close", p.Name)
+		span.SetError(fmt.Errorf("proxy is not working"))
+		span.End()
+		c.Close()
+		return
+	}
+
+	go func(userConn *conn.Conn) {
+		span.AddEvent("work_conn_requested")
+		var workConn *conn.Conn
+		var err error
+		if p.relayed {
+			workConn, err = p.getRelayConn(userConn, span.Traceparent())
+		} else {
+			workConn, err = p.getWorkConn(span.Traceparent())
+		}
+		if err != nil {
+			log.Debug("%v", err)
+			span.SetError(err)
+			span.End()
+			userConn.Close()
+			return
 		}
+		span.AddEvent("work_conn_ready")
 
-		// start a goroutine for every listener to accept user connection
-		for _, listener := range p.listeners {
-			go func(l Listener) {
-				for {
-					// block
-					// if listener is closed, err returned
-					c, err := l.Accept()
-					if err != nil {
-						log.Info("ProxyName [%s], listener is closed", p.Name)
-						return
-					}
-					log.Debug("ProxyName [%s], get one new user conn [%s]", p.Name, c.GetRemoteAddr())
-
-					if p.Status != consts.Working {
-						log.Debug("ProxyName [%s] is not working, new user conn close", p.Name)
-						c.Close()
-						return
-					}
-
-					go func(userConn *conn.Conn) {
-						workConn, err := p.getWorkConn()
-						if err != nil {
-							return
-						}
-
-						// message will be transferred to another without modifying
-						// l means local, r means remote
-						log.Debug("Join two connections, (l[%s] r[%s]) (l[%s] r[%s])", workConn.GetLocalAddr(), workConn.GetRemoteAddr(),
-							userConn.GetLocalAddr(), userConn.GetRemoteAddr())
-
-						needRecord := true
-						go msg.JoinMore(userConn, workConn, p.BaseConf, needRecord)
-					}(c)
-				}
-			}(listener)
+		// message will be transferred to another without modifying
+		// l means local, r means remote
+		log.Debug("Join two connections, (l[%s] r[%s]) (l[%s] r[%s])", workConn.GetLocalAddr(), workConn.GetRemoteAddr(),
+			userConn.GetLocalAddr(), userConn.GetRemoteAddr())
+
+		if p.relayed {
+			joinRelay(p.Name, userConn, trace.WatchFirstByte(workConn, span, "first_byte"))
+		} else {
+			needRecord := true
+			msg.JoinMore(userConn, trace.WatchFirstByte(workConn, span, "first_byte"), p.BaseConf, needRecord)
 		}
-	}
-	return nil
+		span.End()
+	}(c)
 }
 
 func (p *ProxyServer) Close() {
 	p.Lock()
-	if p.Status != consts.Closed {
+	closed := p.Status != consts.Closed
+	if closed {
+		// queued user conns are failed if the proxy is waiting for reconnection
+		if p.Status == consts.Reconnecting {
+			close(p.reconnectChan)
+		}
+		if p.cancel != nil {
+			p.cancel()
+		}
 		p.Status = consts.Closed
 		for _, l := range p.listeners {
 			if l != nil {
 				l.Close()
 			}
 		}
-		close(p.ctlMsgChan)
-		close(p.workConnChan)
-		close(p.udpSenderChan)
-		close(p.closeChan)
+		// work conns are registered with the lock held, so nothing is sent to the closed channel
+		if p.workConnChan != nil {
+			close(p.workConnChan)
+		}
 		if p.CtlConn != nil {
 			p.CtlConn.Close()
 		}
@@ -256,24 +371,142 @@ func (p *ProxyServer) Close() {
 		}
 	}
 	metric.SetStatus(p.Name, p.Status)
-	// if the proxy created by PrivilegeMode, delete it when closed
-	if p.PrivilegeMode {
-		DeleteProxy(p.Name)
+	p.Unlock()
+
+	// if the proxy created by PrivilegeMode, delete it when closed,
+	// it's done without the lock because ProxyServersMutex is acquired before it
+	if closed && p.PrivilegeMode {
+		DeleteProxy(p)
+	}
+}
+
+// Disconnect is called when the control connection c is broken.
+// If ReconnectGracePeriod is set, listeners are kept open and user conns are queued until frpc reconnects,
+// the proxy is closed if frpc doesn't come back in time.
+func (p *ProxyServer) Disconnect(c *conn.Conn) {
+	p.disconnect(c, time.Duration(ReconnectGracePeriod)*time.Second)
+}
+
+func (p *ProxyServer) disconnect(c *conn.Conn, gracePeriod time.Duration) {
+	p.Lock()
+	// c is an old control connection, the proxy has been resumed or closed
+	if p.CtlConn != c || p.Status == consts.Closed || p.Status == consts.Reconnecting {
+		p.Unlock()
+		return
 	}
+	if gracePeriod <= 0 || p.Status != consts.Working {
+		p.Unlock()
+		p.Close()
+		return
+	}
+
+	p.Status = consts.Reconnecting
+	p.cancel()
+	if p.CtlConn != nil {
+		p.CtlConn.Close()
+	}
+	if p.WorkConnUdp != nil {
+		p.WorkConnUdp.Close()
+	}
+	// work conns and requests for them belong to the broken control connection
+	for len(p.workConnChan) > 0 {
+		(<-p.workConnChan).Close()
+	}
+	for len(p.ctlMsgChan) > 0 {
+		<-p.ctlMsgChan
+	}
+	reconnectCh := make(chan struct{})
+	p.reconnectChan = reconnectCh
+	p.queuedConns = 0
+	metric.SetStatus(p.Name, p.Status)
 	p.Unlock()
+	log.Info("ProxyName [%s], wait %v for frpc to reconnect", p.Name, gracePeriod)
+
+	time.AfterFunc(gracePeriod, func() {
+		p.mutex.RLock()
+		expired := p.Status == consts.Reconnecting && p.reconnectChan == reconnectCh
+		p.mutex.RUnlock()
+		if expired {
+			log.Warn("ProxyName [%s], frpc doesn't reconnect in %v, close it", p.Name, gracePeriod)
+			p.Close()
+		}
+	})
 }
 
-func (p *ProxyServer) WaitUserConn() (closeFlag bool) {
-	closeFlag = false
+// Resume is called when frpc reconnects in the grace period,
+// listeners are taken over by the new control connection and queued user conns are served.
+func (p *ProxyServer) Resume(c *conn.Conn) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.Status != consts.Reconnecting {
+		return fmt.Errorf("proxy is not waiting for reconnection")
+	}
+	p.CtlConn = c
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.Status = consts.Working
+	close(p.reconnectChan)
+	metric.SetStatus(p.Name, p.Status)
+	if p.PoolCount > 0 {
+		go p.connectionPoolManager(p.ctx)
+	}
+	return nil
+}
 
-	_, ok := <-p.ctlMsgChan
-	if !ok {
-		closeFlag = true
+// block until one user conn is coming, closeFlag is true if the control connection c is not used any more,
+// traceparent is empty if the work connection is requested by the pool manager
+func (p *ProxyServer) WaitUserConn(c *conn.Conn) (traceparent string, closeFlag bool) {
+	p.mutex.RLock()
+	if p.CtlConn != c || p.Status == consts.Closed || p.Status == consts.Reconnecting {
+		p.mutex.RUnlock()
+		return "", true
+	}
+	ctlMsgCh, ctx := p.ctlMsgChan, p.ctx
+	p.mutex.RUnlock()
+
+	select {
+	case traceparent = <-ctlMsgCh:
+		return traceparent, false
+	case <-ctx.Done():
+		return "", true
+	}
+}
+
+// block until the proxy is working, user conns are queued here when waiting for frpc to reconnect
+func (p *ProxyServer) waitWorking() (ctx context.Context, err error) {
+	for {
+		p.Lock()
+		switch p.Status {
+		case consts.Working:
+			ctx = p.ctx
+			p.Unlock()
+			return ctx, nil
+		case consts.Reconnecting:
+			if p.queuedConns >= ReconnectQueueSize {
+				p.Unlock()
+				return nil, fmt.Errorf("ProxyName [%s], too many user conns waiting for reconnection", p.Name)
+			}
+			p.queuedConns++
+			reconnectCh := p.reconnectChan
+			p.Unlock()
+			<-reconnectCh
+			p.Lock()
+			p.queuedConns--
+			p.Unlock()
+		default:
+			p.Unlock()
+			return nil, fmt.Errorf("ProxyName [%s], no work connections available, control is closing", p.Name)
+		}
 	}
-	return
 }
 
 func (p *ProxyServer) RegisterNewWorkConn(c *conn.Conn) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.Status != consts.Working {
+		log.Warn("ProxyName [%s], is not working when it gets one new work connnection", p.Name)
+		c.Close()
+		return
+	}
 	select {
 	case p.workConnChan <- c:
 	default:
@@ -282,21 +515,35 @@ func (p *ProxyServer) RegisterNewWorkConn(c *conn.Conn) {
 	}
 }
 
-// create a tcp connection for forwarding udp packages
+// create a tcp connection for forwarding udp packages, the old one is replaced,
+// goroutines of the connection stop when it's broken, replaced or the control connection is broken
 func (p *ProxyServer) RegisterNewWorkConnUdp(c *conn.Conn) {
-	if p.WorkConnUdp != nil && !p.WorkConnUdp.IsClosed() {
+	p.Lock()
+	if p.Status != consts.Working {
+		p.Unlock()
+		log.Warn("ProxyName [%s], is not working when it gets one new work connnection for udp", p.Name)
+		c.Close()
+		return
+	}
+	// packets from users are not sent by the old connection any more
+	if p.workConnUdpCancel != nil {
+		p.workConnUdpCancel()
+	}
+	if p.WorkConnUdp != nil {
 		p.WorkConnUdp.Close()
 	}
-	p.WorkConnUdp = c
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.WorkConnUdp, p.workConnUdpCancel = c, cancel
+	udpConn := p.udpConn
+	p.Unlock()
 
+	stop := conn.CloseWhenDone(ctx, c)
 	// read
 	go func() {
-		var (
-			buf string
-			err error
-		)
+		defer cancel()
+		defer stop()
 		for {
-			buf, err = c.ReadLine()
+			buf, err := c.ReadLine()
 			if err != nil {
 				log.Warn("ProxyName [%s], work connection for udp closed", p.Name)
 				return
@@ -309,7 +556,7 @@ func (p *ProxyServer) RegisterNewWorkConnUdp(c *conn.Conn) {
 			}
 
 			// send to user
-			_, err = p.udpConn.WriteToUDP(udpPacket.Content, udpPacket.Dst)
+			_, err = udpConn.WriteToUDP(udpPacket.Content, udpPacket.Dst)
 			if err != nil {
 				continue
 			}
@@ -318,14 +565,16 @@ func (p *ProxyServer) RegisterNewWorkConnUdp(c *conn.Conn) {
 
 	// write
 	go func() {
-		for {
-			udpPacket, ok := <-p.udpSenderChan
-			if !ok {
-				return
-			}
-			err := c.WriteString(string(udpPacket.Pack()) + "\n")
-			if err != nil {
-				log.Debug("ProxyName [%s], write to work connection for udp error: %v", p.Name, err)
+		defer cancel()
+		for ctx.Err() == nil {
+			select {
+			case udpPacket := <-p.udpSenderChan:
+				err := c.WriteString(string(udpPacket.Pack()) + "\n")
+				if err != nil {
+					log.Debug("ProxyName [%s], write to work connection for udp error: %v", p.Name, err)
+					return
+				}
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -336,19 +585,32 @@ func (p *ProxyServer) RegisterNewWorkConnUdp(c *conn.Conn) {
 // If no workConn available in the pool, send message to frpc to get one or more
 // and wait until it is available.
 // return an error if wait timeout
-func (p *ProxyServer) getWorkConn() (workConn *conn.Conn, err error) {
-	var ok bool
+func (p *ProxyServer) getWorkConn(traceparent string) (workConn *conn.Conn, err error) {
+	var (
+		ok  bool
+		ctx context.Context
+	)
 	// get a work connection from the pool
 	for {
+		ctx, err = p.waitWorking()
+		if err != nil {
+			return
+		}
+
 		select {
 		case workConn, ok = <-p.workConnChan:
 			if !ok {
 				err = fmt.Errorf("ProxyName [%s], no work connections available, control is closing", p.Name)
-				return
+				return nil, err
 			}
 		default:
 			// no work connections available in the poll, send message to frpc to get more
-			p.ctlMsgChan <- 1
+			select {
+			case p.ctlMsgChan <- traceparent:
+			case <-ctx.Done():
+				// control connection is broken, wait for reconnection
+				continue
+			}
 
 			select {
 			case workConn, ok = <-p.workConnChan:
@@ -356,7 +618,8 @@ func (p *ProxyServer) getWorkConn() (workConn *conn.Conn, err error) {
 					err = fmt.Errorf("ProxyName [%s], no work connections available, control is closing", p.Name)
 					return
 				}
-
+			case <-ctx.Done():
+				continue
 			case <-time.After(time.Duration(UserConnTimeout) * time.Second):
 				log.Warn("ProxyName [%s], timeout trying to get work connection", p.Name)
 				err = fmt.Errorf("ProxyName [%s], timeout trying to get work connection", p.Name)
@@ -375,34 +638,40 @@ func (p *ProxyServer) getWorkConn() (workConn *conn.Conn, err error) {
 	return
 }
 
-func (p *ProxyServer) connectionPoolManager(closeCh <-chan struct{}) {
+func (p *ProxyServer) connectionPoolManager(ctx context.Context) {
+	// check if we need more work connections and send messages to frpc to get more
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 	for {
-		// check if we need more work connections and send messages to frpc to get more
-		time.Sleep(time.Duration(2) * time.Second)
 		select {
-		// if the channel closed, it means the proxy is closed, so just return
-		case <-closeCh:
+		case <-ctx.Done():
 			log.Info("ProxyName [%s], connectionPoolManager exit", p.Name)
 			return
-		default:
-			curWorkConnNum := int64(len(p.workConnChan))
-			diff := p.PoolCount - curWorkConnNum
-			if diff > 0 {
-				if diff < p.PoolCount/5 {
-					diff = p.PoolCount*4/5 + 1
-				} else if diff < p.PoolCount/2 {
-					diff = p.PoolCount/4 + 1
-				} else if diff < p.PoolCount*4/5 {
-					diff = p.PoolCount/5 + 1
-				} else {
-					diff = p.PoolCount/10 + 1
-				}
-				if diff+curWorkConnNum > p.PoolCount {
-					diff = p.PoolCount - curWorkConnNum
-				}
-				for i := 0; i < int(diff); i++ {
-					p.ctlMsgChan <- 1
-				}
+		case <-ticker.C:
+		}
+
+		curWorkConnNum := int64(len(p.workConnChan))
+		diff := p.PoolCount - curWorkConnNum
+		if diff <= 0 {
+			continue
+		}
+		if diff < p.PoolCount/5 {
+			diff = p.PoolCount*4/5 + 1
+		} else if diff < p.PoolCount/2 {
+			diff = p.PoolCount/4 + 1
+		} else if diff < p.PoolCount*4/5 {
+			diff = p.PoolCount/5 + 1
+		} else {
+			diff = p.PoolCount/10 + 1
+		}
+		if diff+curWorkConnNum > p.PoolCount {
+			diff = p.PoolCount - curWorkConnNum
+		}
+		for i := 0; i < int(diff); i++ {
+			select {
+			case p.ctlMsgChan <- "":
+			case <-ctx.Done():
+				return
 			}
 		}
 	}
@@ -0,0 +1,245 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/conn"
+)
+
+// wait until goroutines started in the test exit
+func checkGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("%d goroutines are leaked\n%s", runtime.NumGoroutine()-n, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func freePort(t *testing.T) int64 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return int64(l.Addr().(*net.TCPAddr).Port)
+}
+
+func newTestProxy(t *testing.T, proxyType string) *ProxyServer {
+	p := NewProxyServer()
+	p.Name = "test_" + proxyType
+	p.Type = proxyType
+	p.BindAddr = "127.0.0.1"
+	if proxyType == "tcp" {
+		p.ListenPort = freePort(t)
+	}
+	p.AuthToken = "123"
+	return p
+}
+
+// work like noticeUserConn in frps, a work connection is registered for every request
+func serveCtlConn(p *ProxyServer, ctlConn *conn.Conn, done chan struct{}) {
+	defer close(done)
+	for {
+		_, closeFlag := p.WaitUserConn(ctlConn)
+		if closeFlag {
+			return
+		}
+		workConn, frpcConn := net.Pipe()
+		go func() {
+			// a local service echoing data
+			defer frpcConn.Close()
+			buf := make([]byte, 1024)
+			for {
+				n, err := frpcConn.Read(buf)
+				if err != nil {
+					return
+				}
+				frpcConn.Write(buf[:n])
+			}
+		}()
+		p.RegisterNewWorkConn(conn.NewConn(workConn))
+	}
+}
+
+func TestProxyServerClose(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+
+	p := newTestProxy(t, "tcp")
+	p.PoolCount = 1
+	ctlConn, frpcCtlConn := net.Pipe()
+	defer frpcCtlConn.Close()
+	c := conn.NewConn(ctlConn)
+	assert.NoError(p.Start(c))
+	noticeDone := make(chan struct{})
+	go serveCtlConn(p, c, noticeDone)
+
+	// user connections are joined with work connections
+	userConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", p.ListenPort))
+	assert.NoError(err)
+	userConn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	userConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = userConn.Read(buf)
+	assert.NoError(err)
+	assert.Equal("hello", string(buf))
+	userConn.Close()
+
+	p.Close()
+	assert.Equal(int64(consts.Closed), p.GetStatus())
+	<-noticeDone
+	// work connections registered after closed are closed
+	workConn, frpcConn := net.Pipe()
+	p.RegisterNewWorkConn(conn.NewConn(workConn))
+	_, err = frpcConn.Read(buf)
+	assert.Error(err)
+	checkGoroutines(t, n)
+}
+
+func TestProxyServerReconnect(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+
+	p := newTestProxy(t, "tcp")
+	p.PoolCount = 1
+	ctlConn1, _ := net.Pipe()
+	c1 := conn.NewConn(ctlConn1)
+	assert.NoError(p.Start(c1))
+	noticeDone := make(chan struct{})
+	go serveCtlConn(p, c1, noticeDone)
+
+	// goroutines for the broken control connection stop, listeners are kept
+	p.disconnect(c1, time.Minute)
+	assert.Equal(int64(consts.Reconnecting), p.GetStatus())
+	<-noticeDone
+
+	ctlConn2, _ := net.Pipe()
+	c2 := conn.NewConn(ctlConn2)
+	assert.NoError(p.Resume(c2))
+	assert.Equal(int64(consts.Working), p.GetStatus())
+	noticeDone = make(chan struct{})
+	go serveCtlConn(p, c2, noticeDone)
+	// the old control connection can't stop the proxy any more
+	p.disconnect(c1, time.Minute)
+	assert.Equal(int64(consts.Working), p.GetStatus())
+
+	p.Close()
+	<-noticeDone
+	checkGoroutines(t, n)
+}
+
+func TestCreateProxy(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+
+	p1 := newTestProxy(t, "tcp")
+	p1.PrivilegeMode = true
+	assert.NoError(CreateProxy(p1))
+	ctlConn1, _ := net.Pipe()
+	c1 := conn.NewConn(ctlConn1)
+	assert.NoError(p1.Start(c1))
+	noticeDone := make(chan struct{})
+	go serveCtlConn(p1, c1, noticeDone)
+
+	p2 := newTestProxy(t, "tcp")
+	p2.PrivilegeMode = true
+	assert.Error(CreateProxy(p2))
+
+	// the proxy waiting for reconnection is replaced, closing it doesn't delete the new one
+	p1.disconnect(c1, time.Minute)
+	<-noticeDone
+	assert.NoError(CreateProxy(p2))
+	assert.Equal(int64(consts.Closed), p1.GetStatus())
+	p, ok := GetProxyServer(p2.Name)
+	assert.True(ok)
+	assert.True(p == p2)
+
+	p2.Close()
+	_, ok = GetProxyServer(p2.Name)
+	assert.False(ok)
+	checkGoroutines(t, n)
+}
+
+func TestCompareLogin(t *testing.T) {
+	assert := assert.New(t)
+	p := newTestProxy(t, "http")
+	p.CustomDomains = []string{"example.com"}
+	p.HttpUserName = "user"
+	p.OidcAllowedEmails = []string{"alice@example.com"}
+	assert.True(p.CompareLogin(p.CopyConf()))
+
+	for _, change := range []func(p2 *ProxyServer){
+		func(p2 *ProxyServer) { p2.HttpPassWord = "pwd" },
+		func(p2 *ProxyServer) { p2.OidcLogin = true },
+		func(p2 *ProxyServer) { p2.OidcAllowedEmails = []string{"bob@example.com"} },
+		func(p2 *ProxyServer) { p2.OidcAllowedGroups = []string{"dev"} },
+		func(p2 *ProxyServer) { p2.HttpCompression = []string{"gzip"} },
+		func(p2 *ProxyServer) { p2.SubDomain = "test.example.com" },
+		func(p2 *ProxyServer) { p2.UseEncryption = true },
+		func(p2 *ProxyServer) { p2.PoolCount = 1 },
+		func(p2 *ProxyServer) { p2.CustomDomains = []string{"example.org"} },
+	} {
+		p2 := p.CopyConf()
+		change(p2)
+		assert.False(p.CompareLogin(p2))
+	}
+}
+
+func TestUdpProxyClose(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+
+	p := newTestProxy(t, "udp")
+	ctlConn, _ := net.Pipe()
+	assert.NoError(p.Start(conn.NewConn(ctlConn)))
+
+	// the old work connection is closed after a new one is registered
+	workConn1, frpcConn1 := net.Pipe()
+	p.RegisterNewWorkConnUdp(conn.NewConn(workConn1))
+	workConn2, frpcConn2 := net.Pipe()
+	p.RegisterNewWorkConnUdp(conn.NewConn(workConn2))
+	_, err := frpcConn1.Read(make([]byte, 1))
+	assert.Error(err)
+
+	// packets from users are sent by the work connection
+	userConn, err := net.DialUDP("udp", nil, p.udpConn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(err)
+	defer userConn.Close()
+	userConn.Write([]byte("hello"))
+	buf, err := conn.NewConn(frpcConn2).ReadLine()
+	assert.NoError(err)
+	packet := &msg.UdpPacket{}
+	assert.NoError(packet.UnPack([]byte(buf)))
+	assert.Equal("hello", string(packet.Content))
+
+	p.Close()
+	_, err = frpcConn2.Read(make([]byte, 1))
+	assert.Error(err)
+	checkGoroutines(t, n)
+}
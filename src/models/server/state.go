@@ -0,0 +1,123 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/models/metric"
+	"github.com/fatedier/frp/src/models/msg"
+	"github.com/fatedier/frp/src/utils/log"
+)
+
+// version of the state file, it's increased when the format is changed incompatibly
+const StateVersion = 1
+
+// restored proxies wait at least this period for their frpc to connect to the new frps
+const minRestoreGracePeriod = 60 * time.Second
+
+// State is the runtime state of frps which is lost after restarting, active connections are not included
+type State struct {
+	Version    int64  `json:"version"`
+	ExportedAt string `json:"exported_at"`
+
+	// proxies created in privilege mode, their listen ports and domains are kept until frpc reconnects
+	Proxies []*msg.ControlReq `json:"proxies"`
+
+	Metrics     []*metric.ServerMetric `json:"metrics"`
+	HttpMetrics []*metric.HttpMetric   `json:"http_metrics"`
+}
+
+func ExportState() *State {
+	state := &State{
+		Version:     StateVersion,
+		ExportedAt:  time.Now().Format(time.RFC3339),
+		Proxies:     make([]*msg.ControlReq, 0),
+		Metrics:     metric.GetAllProxyMetrics(),
+		HttpMetrics: metric.GetAllHttpMetrics(),
+	}
+
+	ProxyServersMutex.RLock()
+	for _, p := range ProxyServers {
+		status := p.GetStatus()
+		// relayed proxies are synchronized from the core frps again
+		if !p.PrivilegeMode || p.relayed || (status != consts.Working && status != consts.Reconnecting) {
+			continue
+		}
+		// the identity is kept so that restored proxies are only taken over by the frpc owning custom domains
+		req := p.toCtlMsg()
+		req.DomainId = p.DomainId
+		state.Proxies = append(state.Proxies, req)
+	}
+	ProxyServersMutex.RUnlock()
+	sort.Sort(ctlMsgList(state.Proxies))
+	return state
+}
+
+// ImportState restores proxies which don't exist and adds statistics,
+// restored proxies are listening and waiting for frpc like ones whose frpc is reconnecting
+func ImportState(state *State) (restored int, err error) {
+	if state.Version <= 0 || state.Version > StateVersion {
+		return 0, fmt.Errorf("state version [%d] is not supported, the latest version is %d", state.Version, StateVersion)
+	}
+	if !PrivilegeMode && len(state.Proxies) > 0 {
+		return 0, fmt.Errorf("privilege_mode must be enabled to restore proxies")
+	}
+
+	gracePeriod := time.Duration(ReconnectGracePeriod) * time.Second
+	if gracePeriod < minRestoreGracePeriod {
+		gracePeriod = minRestoreGracePeriod
+	}
+	for _, req := range state.Proxies {
+		if err := restoreProxy(req, gracePeriod); err != nil {
+			log.Warn("ProxyName [%s], restore proxy error: %v", req.ProxyName, err)
+			continue
+		}
+		restored++
+	}
+
+	metric.ImportProxyMetrics(state.Metrics)
+	metric.ImportHttpMetrics(state.HttpMetrics)
+	log.Info("State exported at [%s] is imported, %d of %d proxies are restored", state.ExportedAt, restored, len(state.Proxies))
+	return restored, nil
+}
+
+func restoreProxy(req *msg.ControlReq, gracePeriod time.Duration) error {
+	if _, ok := GetProxyServer(req.ProxyName); ok {
+		return fmt.Errorf("proxy already exists")
+	}
+	req.PrivilegeMode = true
+	p, err := NewProxyServerFromCtlMsg(req)
+	if err != nil {
+		return err
+	}
+	p.SubDomain = req.SubDomain
+	p.PoolCount = req.PoolCount
+	if err = p.Check(); err != nil {
+		return err
+	}
+	if err = CreateProxy(p); err != nil {
+		return err
+	}
+	if err = p.Start(nil); err != nil {
+		p.Close()
+		return err
+	}
+	p.disconnect(nil, gracePeriod)
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fatedier/frp/src/models/consts"
+	"github.com/fatedier/frp/src/utils/conn"
+)
+
+func TestImportStateVersion(t *testing.T) {
+	assert := assert.New(t)
+	_, err := ImportState(&State{Version: 0})
+	assert.Error(err)
+	_, err = ImportState(&State{Version: StateVersion + 1})
+	assert.Error(err)
+	restored, err := ImportState(&State{Version: StateVersion})
+	assert.NoError(err)
+	assert.Equal(0, restored)
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	n := runtime.NumGoroutine()
+	PrivilegeMode = true
+	defer func() { PrivilegeMode = false }()
+
+	p := newTestProxy(t, "tcp")
+	p.Name = "state_tcp"
+	p.PrivilegeMode = true
+	p.PoolCount = 1
+	p.DomainId = "id"
+	assert.NoError(CreateProxy(p))
+	ctlConn, _ := net.Pipe()
+	assert.NoError(p.Start(conn.NewConn(ctlConn)))
+
+	state := ExportState()
+	if !assert.Len(state.Proxies, 1) {
+		p.Close()
+		return
+	}
+	req := state.Proxies[0]
+	assert.Equal("state_tcp", req.ProxyName)
+	assert.Equal(p.ListenPort, req.RemotePort)
+	assert.Equal("id", req.DomainId)
+
+	// existing proxies are skipped
+	restored, err := ImportState(state)
+	assert.NoError(err)
+	assert.Equal(0, restored)
+	p.Close()
+
+	// the restored proxy listens on the same port and waits for frpc
+	restored, err = ImportState(state)
+	assert.NoError(err)
+	assert.Equal(1, restored)
+	old, ok := GetProxyServer("state_tcp")
+	if !assert.True(ok) {
+		return
+	}
+	assert.Equal(int64(consts.Reconnecting), old.GetStatus())
+	assert.Equal(p.ListenPort, old.ListenPort)
+	assert.Equal("id", old.DomainId)
+
+	// it's taken over by a login with the same options like in doLogin
+	n2, err := NewProxyServerFromCtlMsg(req)
+	assert.NoError(err)
+	n2.PoolCount = req.PoolCount
+	assert.True(old.CompareLogin(n2))
+	n2.PoolCount = 0
+	assert.False(old.CompareLogin(n2))
+
+	ctlConn, _ = net.Pipe()
+	c := conn.NewConn(ctlConn)
+	assert.NoError(old.Resume(c))
+	noticeDone := make(chan struct{})
+	go serveCtlConn(old, c, noticeDone)
+
+	userConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", old.ListenPort))
+	if assert.NoError(err) {
+		userConn.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		userConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = userConn.Read(buf)
+		assert.NoError(err)
+		assert.Equal("hello", string(buf))
+		userConn.Close()
+	}
+
+	old.Close()
+	<-noticeDone
+	checkGoroutines(t, n)
+}
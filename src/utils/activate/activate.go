@@ -0,0 +1,214 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activate
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interval of dialing the local service while waiting for it to be ready
+const dialInterval = 100 * time.Millisecond
+
+// max time to wait for the command to exit after interrupted
+const stopTimeout = 5 * time.Second
+
+// Activator starts a local service when a connection comes and the service is not listening,
+// by running Command or sending Request to Socket. If IdleTimeout is not zero,
+// the service started by Activator is stopped after no connections for IdleTimeout.
+type Activator struct {
+	Addr        string // address of the local service
+	Command     string // arguments are separated by spaces
+	Socket      string // unix socket path if it starts with "/", otherwise tcp address
+	Request     string
+	StopRequest string // sent to Socket when stopping the service
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+
+	started   bool // the service is started by Activator
+	cmd       *exec.Cmd
+	exited    chan struct{} // closed when cmd exits
+	conns     int64
+	idleTimer *time.Timer
+	mutex     sync.Mutex
+	startLock sync.Mutex
+}
+
+func (a *Activator) Check() error {
+	if strings.TrimSpace(a.Command) == "" && a.Socket == "" {
+		return fmt.Errorf("activate_command or activate_socket should be set")
+	}
+	if a.Socket != "" && a.Request == "" {
+		return fmt.Errorf("activate_request should be set with activate_socket")
+	}
+	return nil
+}
+
+// Dial connects to the local service and starts it if needed,
+// Done must be called after the connection is closed if no error returned.
+func (a *Activator) Dial() (c net.Conn, err error) {
+	a.mutex.Lock()
+	a.conns++
+	if a.idleTimer != nil {
+		a.idleTimer.Stop()
+		a.idleTimer = nil
+	}
+	a.mutex.Unlock()
+	defer func() {
+		if err != nil {
+			a.Done()
+		}
+	}()
+
+	c, err = net.Dial("tcp", a.Addr)
+	if err == nil {
+		return c, nil
+	}
+	if err = a.start(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(a.Timeout)
+	for {
+		c, err = net.Dial("tcp", a.Addr)
+		if err == nil {
+			return c, nil
+		}
+		if time.Now().After(deadline) {
+			// send the request again for the next connection, a running command is not started twice
+			a.mutex.Lock()
+			if a.cmd == nil {
+				a.started = false
+			}
+			a.mutex.Unlock()
+			return nil, fmt.Errorf("local service [%s] is not ready after %v: %v", a.Addr, a.Timeout, err)
+		}
+		time.Sleep(dialInterval)
+	}
+}
+
+// Done is called when one connection returned by Dial is closed
+func (a *Activator) Done() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.conns--
+	if a.conns == 0 && a.started && a.IdleTimeout > 0 {
+		a.idleTimer = time.AfterFunc(a.IdleTimeout, a.stopIfIdle)
+	}
+}
+
+func (a *Activator) IsStarted() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.started
+}
+
+// only one connection starts the service, others wait for it to be ready
+func (a *Activator) start() (err error) {
+	a.startLock.Lock()
+	defer a.startLock.Unlock()
+	a.mutex.Lock()
+	started := a.started
+	a.mutex.Unlock()
+	if started {
+		return nil
+	}
+
+	if args := strings.Fields(a.Command); len(args) > 0 {
+		cmd := exec.Command(args[0], args[1:]...)
+		if err = cmd.Start(); err != nil {
+			return fmt.Errorf("run activate_command error: %v", err)
+		}
+		exited := make(chan struct{})
+		a.mutex.Lock()
+		a.cmd, a.exited = cmd, exited
+		a.mutex.Unlock()
+		go func() {
+			cmd.Wait()
+			close(exited)
+			a.mutex.Lock()
+			if a.cmd == cmd {
+				a.cmd = nil
+				a.started = false
+			}
+			a.mutex.Unlock()
+		}()
+	}
+	if a.Socket != "" {
+		if err = a.send(a.Request); err != nil {
+			return fmt.Errorf("send activate_request error: %v", err)
+		}
+	}
+
+	a.mutex.Lock()
+	a.started = true
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *Activator) stopIfIdle() {
+	a.mutex.Lock()
+	if a.conns > 0 || !a.started {
+		a.mutex.Unlock()
+		return
+	}
+	a.started = false
+	a.idleTimer = nil
+	cmd, exited := a.cmd, a.exited
+	a.cmd = nil
+	a.mutex.Unlock()
+
+	if cmd != nil {
+		stopCommand(cmd, exited)
+	}
+	if a.Socket != "" && a.StopRequest != "" {
+		a.send(a.StopRequest)
+	}
+}
+
+// interrupt the command and kill it if it doesn't exit in time
+func stopCommand(cmd *exec.Cmd, exited chan struct{}) {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+		return
+	}
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(stopTimeout):
+			cmd.Process.Kill()
+		}
+	}()
+}
+
+// send one line to Socket
+func (a *Activator) send(request string) error {
+	network := "tcp"
+	if strings.HasPrefix(a.Socket, "/") {
+		network = "unix"
+	}
+	c, err := net.DialTimeout(network, a.Socket, a.Timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(request + "\n"))
+	return err
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activate
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testManager listens on socketAddr, the service on serviceAddr is started by "start" and stopped by "stop"
+type testManager struct {
+	socket      net.Listener
+	serviceAddr string
+	starts      int64
+	service     net.Listener
+	mutex       sync.Mutex
+}
+
+func newTestManager(t *testing.T) *testManager {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reserve an address for the service
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &testManager{
+		socket:      socket,
+		serviceAddr: l.Addr().String(),
+	}
+	l.Close()
+
+	go func() {
+		for {
+			c, err := socket.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			c.Close()
+			switch line {
+			case "start\n":
+				atomic.AddInt64(&m.starts, 1)
+				// the service is ready a moment later
+				time.Sleep(200 * time.Millisecond)
+				l, err := net.Listen("tcp", m.serviceAddr)
+				if err != nil {
+					continue
+				}
+				go func() {
+					for {
+						c, err := l.Accept()
+						if err != nil {
+							return
+						}
+						c.Close()
+					}
+				}()
+				m.mutex.Lock()
+				m.service = l
+				m.mutex.Unlock()
+			case "stop\n":
+				m.mutex.Lock()
+				if m.service != nil {
+					m.service.Close()
+					m.service = nil
+				}
+				m.mutex.Unlock()
+			}
+		}
+	}()
+	return m
+}
+
+func (m *testManager) running() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.service != nil
+}
+
+func (m *testManager) Close() {
+	m.socket.Close()
+	m.mutex.Lock()
+	if m.service != nil {
+		m.service.Close()
+	}
+	m.mutex.Unlock()
+}
+
+func TestActivatorSocket(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestManager(t)
+	defer m.Close()
+
+	a := &Activator{
+		Addr:        m.serviceAddr,
+		Socket:      m.socket.Addr().String(),
+		Request:     "start",
+		StopRequest: "stop",
+		Timeout:     2 * time.Second,
+		IdleTimeout: 300 * time.Millisecond,
+	}
+	assert.NoError(a.Check())
+
+	// concurrent connections start the service only once
+	var wait sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			c, err := a.Dial()
+			if assert.NoError(err) {
+				c.Close()
+				a.Done()
+			}
+		}()
+	}
+	wait.Wait()
+	assert.Equal(int64(1), atomic.LoadInt64(&m.starts))
+	assert.True(m.running())
+
+	// connections in the idle period keep the service running
+	time.Sleep(150 * time.Millisecond)
+	c, err := a.Dial()
+	if assert.NoError(err) {
+		c.Close()
+		a.Done()
+	}
+	time.Sleep(150 * time.Millisecond)
+	assert.True(m.running())
+
+	// stopped after idle timeout
+	time.Sleep(400 * time.Millisecond)
+	assert.False(m.running())
+	assert.False(a.IsStarted())
+
+	// started again
+	c, err = a.Dial()
+	if assert.NoError(err) {
+		c.Close()
+		a.Done()
+	}
+	assert.Equal(int64(2), atomic.LoadInt64(&m.starts))
+}
+
+func TestActivatorTimeout(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestManager(t)
+	defer m.Close()
+
+	a := &Activator{
+		Addr:    m.serviceAddr,
+		Socket:  m.socket.Addr().String(),
+		Request: "unknown",
+		Timeout: 300 * time.Millisecond,
+	}
+	_, err := a.Dial()
+	assert.Error(err)
+	assert.False(a.IsStarted())
+
+	assert.Error((&Activator{}).Check())
+	assert.Error((&Activator{Socket: "/tmp/a.sock"}).Check())
+}
@@ -16,30 +16,60 @@ package conn
 
 import (
 	"bufio"
-	"encoding/base64"
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/fatedier/frp/src/utils/trace"
 )
 
+var ErrHalfCloseUnsupported = fmt.Errorf("half-close is not supported")
+
+// CloseWrite shuts down the writing side of c if it supports half-close like *net.TCPConn
+func CloseWrite(c interface{}) error {
+	if cw, ok := c.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+	return ErrHalfCloseUnsupported
+}
+
+// CloseWhenDone closes c when ctx is done, the returned function stops watching ctx
+func CloseWhenDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
 type Listener struct {
 	addr      net.Addr
-	l         *net.TCPListener
+	l         net.Listener
 	accept    chan *Conn
 	closeFlag bool
 }
 
 func Listen(bindAddr string, bindPort int64) (l *Listener, err error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", bindAddr, bindPort))
-	if err != nil {
-		return l, err
-	}
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	return ListenByTransport(&TcpTransport{}, fmt.Sprintf("%s:%d", bindAddr, bindPort))
+}
+
+func ListenByTransport(t Transport, addr string) (l *Listener, err error) {
+	listener, err := t.Listen(addr)
 	if err != nil {
 		return l, err
 	}
@@ -53,11 +83,15 @@ func Listen(bindAddr string, bindPort int64) (l *Listener, err error) {
 
 	go func() {
 		for {
-			conn, err := l.l.AcceptTCP()
+			conn, err := l.l.Accept()
 			if err != nil {
 				if l.closeFlag {
 					return
 				}
+				// listeners of other transports won't recover after returning an error
+				if _, ok := err.(net.Error); !ok {
+					return
+				}
 				continue
 			}
 
@@ -95,6 +129,7 @@ func (l *Listener) Close() error {
 type Conn struct {
 	TcpConn   net.Conn
 	Reader    *bufio.Reader
+	Trace     *trace.Span // span of the user conn, nil if tracing is disabled
 	closeFlag bool
 	mutex     sync.RWMutex
 }
@@ -108,62 +143,19 @@ func NewConn(conn net.Conn) (c *Conn) {
 }
 
 func ConnectServer(addr string) (c *Conn, err error) {
-	c = &Conn{}
-	servertAddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return
-	}
-	conn, err := net.DialTCP("tcp", nil, servertAddr)
-	if err != nil {
-		return
-	}
-	c.TcpConn = conn
-	c.Reader = bufio.NewReader(c.TcpConn)
-	c.closeFlag = false
-	return c, nil
+	return ConnectServerByTransport(&TcpTransport{}, addr)
 }
 
 func ConnectServerByHttpProxy(httpProxy string, serverAddr string) (c *Conn, err error) {
-	var proxyUrl *url.URL
-	if proxyUrl, err = url.Parse(httpProxy); err != nil {
-		return
-	}
-
-	var proxyAuth string
-	if proxyUrl.User != nil {
-		proxyAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.String()))
-	}
-
-	if proxyUrl.Scheme != "http" {
-		err = fmt.Errorf("Proxy URL scheme must be http, not [%s]", proxyUrl.Scheme)
-		return
-	}
-
-	if c, err = ConnectServer(proxyUrl.Host); err != nil {
-		return
-	}
-
-	req, err := http.NewRequest("CONNECT", "http://"+serverAddr, nil)
-	if err != nil {
-		return
-	}
-	if proxyAuth != "" {
-		req.Header.Set("Proxy-Authorization", proxyAuth)
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Write(c.TcpConn)
+	return ConnectServerByTransport(&TcpTransport{HttpProxy: httpProxy}, serverAddr)
+}
 
-	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+func ConnectServerByTransport(t Transport, addr string) (c *Conn, err error) {
+	conn, err := t.Dial(addr)
 	if err != nil {
 		return
 	}
-	resp.Body.Close()
-	if resp.StatusCode != 200 {
-		err = fmt.Errorf("ConnectServer using proxy error, StatusCode [%d]", resp.StatusCode)
-		return
-	}
-
-	return
+	return NewConn(conn), nil
 }
 
 // if the tcpConn is different with c.TcpConn
@@ -230,6 +222,11 @@ func (c *Conn) Close() error {
 	return nil
 }
 
+// CloseWrite shuts down the writing side, the peer reads EOF but it can still send data
+func (c *Conn) CloseWrite() error {
+	return CloseWrite(c.TcpConn)
+}
+
 func (c *Conn) IsClosed() (closeFlag bool) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -0,0 +1,106 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PipeTransport connects frpc and frps in the same process by in-memory pipes,
+// it's mainly used for testing. Connections are created by net.Pipe, the address is the name of listener.
+type PipeTransport struct {
+	listeners map[string]*pipeListener
+	mutex     sync.Mutex
+}
+
+// DefaultPipeTransport is used when transport is set to pipe in configure file
+var DefaultPipeTransport = NewPipeTransport()
+
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		listeners: make(map[string]*pipeListener),
+	}
+}
+
+func (t *PipeTransport) Dial(addr string) (net.Conn, error) {
+	t.mutex.Lock()
+	l, ok := t.listeners[addr]
+	t.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial pipe [%s]: connection refused", addr)
+	}
+
+	c1, c2 := net.Pipe()
+	select {
+	case l.accept <- c2:
+		return c1, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("dial pipe [%s]: connection refused", addr)
+	}
+}
+
+func (t *PipeTransport) Listen(addr string) (net.Listener, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.listeners[addr]; ok {
+		return nil, fmt.Errorf("listen pipe [%s]: address already in use", addr)
+	}
+	l := &pipeListener{
+		transport: t,
+		addr:      pipeAddr(addr),
+		accept:    make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	t.listeners[addr] = l
+	return l, nil
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+type pipeListener struct {
+	transport *PipeTransport
+	addr      pipeAddr
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.transport.mutex.Lock()
+		delete(l.transport.listeners, string(l.addr))
+		l.transport.mutex.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
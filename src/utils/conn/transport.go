@@ -0,0 +1,164 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Transport creates connections between frpc and frps
+type Transport interface {
+	Dial(addr string) (net.Conn, error)
+	Listen(addr string) (net.Listener, error)
+}
+
+// TcpTransport connects by tcp directly, or through a http proxy by CONNECT method if HttpProxy is set
+type TcpTransport struct {
+	HttpProxy string
+}
+
+func (t *TcpTransport) Dial(addr string) (net.Conn, error) {
+	if t.HttpProxy != "" {
+		return dialByHttpProxy(t.HttpProxy, addr)
+	}
+	serverAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTCP("tcp", nil, serverAddr)
+}
+
+func (t *TcpTransport) Listen(addr string) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+func dialByHttpProxy(httpProxy string, serverAddr string) (c net.Conn, err error) {
+	var proxyUrl *url.URL
+	if proxyUrl, err = url.Parse(httpProxy); err != nil {
+		return
+	}
+
+	var proxyAuth string
+	if proxyUrl.User != nil {
+		proxyAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(proxyUrl.User.String()))
+	}
+
+	if proxyUrl.Scheme != "http" {
+		err = fmt.Errorf("Proxy URL scheme must be http, not [%s]", proxyUrl.Scheme)
+		return
+	}
+
+	if c, err = (&TcpTransport{}).Dial(proxyUrl.Host); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	req, err := http.NewRequest("CONNECT", "http://"+serverAddr, nil)
+	if err != nil {
+		return
+	}
+	if proxyAuth != "" {
+		req.Header.Set("Proxy-Authorization", proxyAuth)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Write(c)
+
+	// read byte by byte, so nothing after the response is consumed
+	resp, err := http.ReadResponse(bufio.NewReaderSize(&byteReader{c}, 16), req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("ConnectServer using proxy error, StatusCode [%d]", resp.StatusCode)
+		return
+	}
+	return c, nil
+}
+
+type byteReader struct {
+	c net.Conn
+}
+
+func (br *byteReader) Read(p []byte) (n int, err error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return br.c.Read(p)
+}
+
+// TlsTransport encrypts connections created by Transport with TLS
+type TlsTransport struct {
+	Transport
+	Config *tls.Config
+}
+
+func (t *TlsTransport) Dial(addr string) (net.Conn, error) {
+	c, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := t.Config
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(c, config)
+	if err = tlsConn.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (t *TlsTransport) Listen(addr string) (net.Listener, error) {
+	l, err := t.Transport.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, t.Config), nil
+}
+
+// UnixTransport uses unix domain socket Path and the address is ignored
+type UnixTransport struct {
+	Path string
+}
+
+func (t *UnixTransport) Dial(addr string) (net.Conn, error) {
+	return net.Dial("unix", t.Path)
+}
+
+// socket file left by last process is removed before listening
+func (t *UnixTransport) Listen(addr string) (net.Listener, error) {
+	if fi, err := os.Stat(t.Path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(t.Path)
+	}
+	return net.Listen("unix", t.Path)
+}
@@ -0,0 +1,192 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echo lines back through conn.Listener and conn.Conn
+func testTransport(t *testing.T, tr Transport, addr string) {
+	assert := assert.New(t)
+	l, err := ListenByTransport(tr, addr)
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				for {
+					line, err := c.ReadLine()
+					if err != nil {
+						return
+					}
+					c.WriteString(line)
+				}
+			}()
+		}
+	}()
+
+	dialAddr := l.addr.String()
+	c, err := ConnectServerByTransport(tr, dialAddr)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+
+	long := strings.Repeat("x", 100000) + "\n"
+	for _, content := range []string{"hello\n", "frp\n", long} {
+		err = c.WriteString(content)
+		assert.NoError(err)
+		line, err := c.ReadLine()
+		assert.NoError(err)
+		assert.Equal(content, line)
+	}
+}
+
+func TestTcpTransport(t *testing.T) {
+	testTransport(t, &TcpTransport{}, "127.0.0.1:0")
+}
+
+func TestTlsTransport(t *testing.T) {
+	cert, pool := newTestCert(t)
+	tr := &TlsTransport{
+		Transport: &TcpTransport{},
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			ServerName:   "frp.test",
+		},
+	}
+	testTransport(t, tr, "127.0.0.1:0")
+}
+
+func TestWebsocketTransport(t *testing.T) {
+	testTransport(t, &WebsocketTransport{Transport: &TcpTransport{}, Path: "/frp"}, "127.0.0.1:0")
+
+	// other requests are refused
+	assert := assert.New(t)
+	tr := &WebsocketTransport{Transport: &TcpTransport{}, Path: "/frp"}
+	l, err := tr.Listen("127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+	_, err = (&WebsocketTransport{Transport: &TcpTransport{}, Path: "/other"}).Dial(l.Addr().String())
+	assert.Error(err)
+}
+
+func TestUnixTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frp")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	testTransport(t, &UnixTransport{Path: filepath.Join(dir, "frps.sock")}, "")
+}
+
+func TestPipeTransport(t *testing.T) {
+	assert := assert.New(t)
+	tr := NewPipeTransport()
+	testTransport(t, tr, "frps")
+
+	// listener is removed after closing
+	_, err := tr.Dial("frps")
+	assert.Error(err)
+
+	l, err := tr.Listen("frps")
+	assert.NoError(err)
+	_, err = tr.Listen("frps")
+	assert.Error(err)
+	l.Close()
+	_, err = l.Accept()
+	assert.Error(err)
+}
+
+func TestWsConnControlFrames(t *testing.T) {
+	assert := assert.New(t)
+	c1, c2 := net.Pipe()
+	client := newWsConn(c1, bufio.NewReader(c1), true)
+	server := newWsConn(c2, bufio.NewReader(c2), false)
+
+	// ping is answered by pong and control frames are skipped by Read
+	errCh := make(chan error)
+	go func() {
+		client.writeFrame(wsOpPing, []byte("ping"))
+		client.Write([]byte("data"))
+	}()
+	go func() {
+		_, err := client.Read(make([]byte, 10))
+		errCh <- err
+	}()
+	buf := make([]byte, 10)
+	n, err := server.Read(buf)
+	assert.NoError(err)
+	assert.Equal("data", string(buf[:n]))
+
+	// peer gets EOF after close frame
+	server.Close()
+	assert.Equal(io.EOF, <-errCh)
+}
+
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "frp.test"},
+		DNSNames:              []string{"frp.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -0,0 +1,364 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	websocketGuid             = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketHandshakeTimeout = 10 * time.Second
+
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xa
+
+	// max payload length of control frames
+	wsMaxControlPayload = 125
+)
+
+// WebsocketTransport carries connections created by Transport in binary websocket messages,
+// so frpc can connect frps through proxies and CDNs which only allow websocket
+type WebsocketTransport struct {
+	Transport
+	Path string
+}
+
+func (t *WebsocketTransport) path() string {
+	if t.Path == "" {
+		return "/"
+	}
+	return t.Path
+}
+
+func (t *WebsocketTransport) Dial(addr string) (_ net.Conn, err error) {
+	c, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+	c.SetDeadline(time.Now().Add(websocketHandshakeTimeout))
+
+	keyBuf := make([]byte, 16)
+	rand.Read(keyBuf)
+	key := base64.StdEncoding.EncodeToString(keyBuf)
+	req, err := http.NewRequest("GET", "http://"+addr+t.path(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err = req.Write(c); err != nil {
+		return nil, err
+	}
+
+	rd := bufio.NewReader(c)
+	res, err := http.ReadResponse(rd, req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket handshake error, status code [%d]", res.StatusCode)
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, fmt.Errorf("websocket handshake error, invalid Sec-WebSocket-Accept")
+	}
+	c.SetDeadline(time.Time{})
+	return newWsConn(c, rd, true), nil
+}
+
+func (t *WebsocketTransport) Listen(addr string) (net.Listener, error) {
+	l, err := t.Transport.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	wl := &wsListener{
+		Listener: l,
+		path:     t.path(),
+		accept:   make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go wl.run()
+	return wl, nil
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGuid))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+type wsListener struct {
+	net.Listener
+	path      string
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (wl *wsListener) run() {
+	for {
+		c, err := wl.Listener.Accept()
+		if err != nil {
+			wl.Close()
+			return
+		}
+		// handshake in another goroutine, so slow clients won't block others
+		go func(c net.Conn) {
+			wc, err := wl.handshake(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			select {
+			case wl.accept <- wc:
+			case <-wl.closed:
+				wc.Close()
+			}
+		}(c)
+	}
+}
+
+func (wl *wsListener) handshake(c net.Conn) (net.Conn, error) {
+	c.SetDeadline(time.Now().Add(websocketHandshakeTimeout))
+	rd := bufio.NewReader(c)
+	req, err := http.ReadRequest(rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" || req.URL.Path != wl.path || key == "" ||
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		res := &http.Response{
+			StatusCode: http.StatusNotFound,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Close:      true,
+		}
+		res.Write(c)
+		return nil, fmt.Errorf("not a websocket request")
+	}
+
+	resStr := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err = c.Write([]byte(resStr)); err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return newWsConn(c, rd, false), nil
+}
+
+func (wl *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-wl.accept:
+		return c, nil
+	case <-wl.closed:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+func (wl *wsListener) Close() error {
+	wl.closeOnce.Do(func() {
+		close(wl.closed)
+		wl.Listener.Close()
+	})
+	return nil
+}
+
+// wsConn reads payloads of data frames as a stream and writes every buffer as one binary frame,
+// frames sent by client are masked as RFC 6455 requires
+type wsConn struct {
+	net.Conn
+	rd         *bufio.Reader
+	client     bool
+	remaining  int64 // payload bytes left in current frame
+	masked     bool
+	mask       [4]byte
+	maskPos    int
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+}
+
+func newWsConn(c net.Conn, rd *bufio.Reader, client bool) *wsConn {
+	return &wsConn{
+		Conn:   c,
+		rd:     rd,
+		client: client,
+	}
+}
+
+func (wc *wsConn) Read(p []byte) (n int, err error) {
+	for wc.remaining == 0 {
+		if err = wc.readFrameHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > wc.remaining {
+		p = p[:wc.remaining]
+	}
+	n, err = wc.rd.Read(p)
+	if wc.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= wc.mask[wc.maskPos%4]
+			wc.maskPos++
+		}
+	}
+	wc.remaining -= int64(n)
+	return n, err
+}
+
+// read header of next frame, control frames are handled here
+func (wc *wsConn) readFrameHeader() error {
+	var header [2]byte
+	if _, err := io.ReadFull(wc.rd, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(wc.rd, buf[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(wc.rd, buf[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(buf[:]))
+		if length < 0 {
+			return fmt.Errorf("websocket frame length error")
+		}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(wc.rd, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	if opcode >= wsOpClose {
+		if length > wsMaxControlPayload {
+			return fmt.Errorf("websocket control frame length error")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(wc.rd, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			if masked {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		switch opcode {
+		case wsOpClose:
+			wc.writeFrame(wsOpClose, nil)
+			return io.EOF
+		case wsOpPing:
+			return wc.writeFrame(wsOpPong, payload)
+		}
+		return nil
+	}
+
+	// data frames, text frames are treated as binary
+	wc.remaining = length
+	wc.masked = masked
+	wc.mask = mask
+	wc.maskPos = 0
+	return nil
+}
+
+func (wc *wsConn) Write(p []byte) (n int, err error) {
+	if err = wc.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (wc *wsConn) writeFrame(opcode byte, payload []byte) error {
+	buf := make([]byte, 0, len(payload)+14)
+	buf = append(buf, 0x80|opcode)
+
+	var maskBit byte
+	if wc.client {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskBit|126, byte(length>>8), byte(length))
+	default:
+		buf = append(buf, maskBit|127)
+		var lenBuf [8]byte
+		binary.BigEndian.PutUint64(lenBuf[:], uint64(length))
+		buf = append(buf, lenBuf[:]...)
+	}
+
+	if wc.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		for i, b := range payload {
+			buf = append(buf, b^mask[i%4])
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	wc.writeMutex.Lock()
+	defer wc.writeMutex.Unlock()
+	_, err := wc.Conn.Write(buf)
+	return err
+}
+
+// send a close frame before closing the underlying connection
+func (wc *wsConn) Close() error {
+	wc.closeOnce.Do(func() {
+		wc.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		wc.writeFrame(wsOpClose, nil)
+	})
+	return wc.Conn.Close()
+}
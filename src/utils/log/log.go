@@ -16,15 +16,43 @@ package log
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/astaxie/beego/logs"
 )
 
+const (
+	levelError = 3
+	levelWarn  = 4
+	levelInfo  = 6
+	levelDebug = 7
+
+	// max number of recent lines kept for every proxy
+	ProxyLogLines = 200
+
+	// lines with this prefix belong to the proxy which name is the first argument
+	proxyPrefix = "ProxyName [%s]"
+)
+
 var Log *logs.BeeLogger
 
+var (
+	// levels are filtered here and Log always outputs all levels,
+	// so debug logs of selected proxies can be printed
+	level        int                 = levelWarn
+	debugProxies map[string]struct{} = make(map[string]struct{})
+	proxyLogs    map[string]*ring    = make(map[string]*ring)
+	mutex        sync.RWMutex
+)
+
 func init() {
 	Log = logs.NewLogger(1000)
 	Log.EnableFuncCallDepth(true)
 	Log.SetLogFuncCallDepth(Log.GetLogFuncCallDepth() + 1)
+	Log.SetLevel(levelDebug)
 }
 
 func InitLog(logWay string, logFile string, logLevel string, maxdays int64) {
@@ -44,35 +72,151 @@ func SetLogFile(logWay string, logFile string, maxdays int64) {
 
 // value: error, warning, info, debug
 func SetLogLevel(logLevel string) {
-	level := 4 // warning
+	l := levelWarn
 	switch logLevel {
 	case "error":
-		level = 3
+		l = levelError
 	case "warn":
-		level = 4
+		l = levelWarn
 	case "info":
-		level = 6
+		l = levelInfo
 	case "debug":
-		level = 7
+		l = levelDebug
+	default:
+		l = levelWarn
+	}
+	mutex.Lock()
+	level = l
+	mutex.Unlock()
+}
+
+func GetLogLevel() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	switch level {
+	case levelError:
+		return "error"
+	case levelInfo:
+		return "info"
+	case levelDebug:
+		return "debug"
 	default:
-		level = 4
+		return "warn"
+	}
+}
+
+func IsValidLevel(logLevel string) bool {
+	switch logLevel {
+	case "error", "warn", "info", "debug":
+		return true
+	}
+	return false
+}
+
+// SetProxyDebug enables debug logs of one proxy whatever the global log level is
+func SetProxyDebug(proxyName string, enable bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if enable {
+		debugProxies[proxyName] = struct{}{}
+	} else {
+		delete(debugProxies, proxyName)
+	}
+}
+
+func IsProxyDebug(proxyName string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	_, ok := debugProxies[proxyName]
+	return ok
+}
+
+func GetDebugProxies() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(debugProxies))
+	for name := range debugProxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProxyLogs returns at most n recent lines of the proxy, the oldest first
+func GetProxyLogs(proxyName string, n int) []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	r, ok := proxyLogs[proxyName]
+	if !ok {
+		return []string{}
+	}
+	return r.last(n)
+}
+
+// AddProxyLogs starts keeping recent lines of the proxy, it's called when the proxy is created,
+// lines of unknown proxies are only printed
+func AddProxyLogs(proxyName string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := proxyLogs[proxyName]; !ok {
+		proxyLogs[proxyName] = newRing(ProxyLogLines)
+	}
+}
+
+// ClearProxyLogs removes recent lines of the proxy, it's called when the proxy is deleted
+func ClearProxyLogs(proxyName string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(proxyLogs, proxyName)
+}
+
+// check if the line should be printed, printed lines of proxies are also kept in memory
+func enabled(l int, levelTag string, format string, v []interface{}) bool {
+	var proxyName string
+	if strings.HasPrefix(format, proxyPrefix) && len(v) > 0 {
+		proxyName, _ = v[0].(string)
+	}
+
+	mutex.RLock()
+	ok := l <= level
+	if !ok && proxyName != "" {
+		_, ok = debugProxies[proxyName]
+	}
+	mutex.RUnlock()
+	if !ok || proxyName == "" {
+		return ok
 	}
-	Log.SetLevel(level)
+
+	line := fmt.Sprintf("%s [%s] %s", time.Now().Format("2006/01/02 15:04:05"), levelTag, fmt.Sprintf(format, v...))
+	mutex.Lock()
+	if r, exist := proxyLogs[proxyName]; exist {
+		r.add(line)
+	}
+	mutex.Unlock()
+	return true
 }
 
 // wrap log
 func Error(format string, v ...interface{}) {
-	Log.Error(format, v...)
+	if enabled(levelError, "E", format, v) {
+		Log.Error(format, v...)
+	}
 }
 
 func Warn(format string, v ...interface{}) {
-	Log.Warn(format, v...)
+	if enabled(levelWarn, "W", format, v) {
+		Log.Warn(format, v...)
+	}
 }
 
 func Info(format string, v ...interface{}) {
-	Log.Info(format, v...)
+	if enabled(levelInfo, "I", format, v) {
+		Log.Info(format, v...)
+	}
 }
 
 func Debug(format string, v ...interface{}) {
-	Log.Debug(format, v...)
+	if enabled(levelDebug, "D", format, v) {
+		Log.Debug(format, v...)
+	}
 }
@@ -0,0 +1,90 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	assert := assert.New(t)
+	r := newRing(3)
+	assert.Equal([]string{}, r.last(0))
+	r.add("a")
+	r.add("b")
+	assert.Equal([]string{"a", "b"}, r.last(0))
+	r.add("c")
+	r.add("d")
+	assert.Equal([]string{"b", "c", "d"}, r.last(0))
+	assert.Equal([]string{"c", "d"}, r.last(2))
+	assert.Equal([]string{"b", "c", "d"}, r.last(10))
+}
+
+func TestProxyDebug(t *testing.T) {
+	assert := assert.New(t)
+	SetLogLevel("info")
+	defer SetLogLevel("warn")
+	assert.Equal("info", GetLogLevel())
+
+	AddProxyLogs("web")
+	AddProxyLogs("ssh")
+	Info("ProxyName [%s], start", "web")
+	Debug("ProxyName [%s], get heartbeat", "web")
+	Info("ProxyName [%s], start", "ssh")
+	Info("other line")
+	lines := GetProxyLogs("web", 0)
+	if assert.Equal(1, len(lines)) {
+		assert.True(strings.HasSuffix(lines[0], "[I] ProxyName [web], start"))
+	}
+
+	SetProxyDebug("web", true)
+	assert.Equal([]string{"web"}, GetDebugProxies())
+	Debug("ProxyName [%s], get heartbeat", "web")
+	Debug("ProxyName [%s], get heartbeat", "ssh")
+	lines = GetProxyLogs("web", 0)
+	if assert.Equal(2, len(lines)) {
+		assert.True(strings.HasSuffix(lines[1], "[D] ProxyName [web], get heartbeat"))
+	}
+	assert.Equal(1, len(GetProxyLogs("ssh", 0)))
+
+	SetProxyDebug("web", false)
+	Debug("ProxyName [%s], get heartbeat", "web")
+	assert.Equal(2, len(GetProxyLogs("web", 0)))
+
+	// only recent lines are kept
+	for i := 0; i < ProxyLogLines+10; i++ {
+		Warn("ProxyName [%s], line %d", "web", i)
+	}
+	lines = GetProxyLogs("web", 0)
+	assert.Equal(ProxyLogLines, len(lines))
+	assert.True(strings.HasSuffix(lines[len(lines)-1], fmt.Sprintf("line %d", ProxyLogLines+9)))
+
+	ClearProxyLogs("web")
+	assert.Equal([]string{}, GetProxyLogs("web", 0))
+
+	// lines of deleted or unknown proxies are not kept
+	Warn("ProxyName [%s], line", "web")
+	Warn("ProxyName [%s], line", "unknown")
+	mutex.RLock()
+	_, ok := proxyLogs["web"]
+	_, ok2 := proxyLogs["unknown"]
+	mutex.RUnlock()
+	assert.False(ok)
+	assert.False(ok2)
+}
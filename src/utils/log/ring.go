@@ -0,0 +1,53 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+// ring keeps the last lines added, it's not safe for concurrent use
+type ring struct {
+	lines []string
+	next  int // position of the next line
+	full  bool
+}
+
+func newRing(capacity int) *ring {
+	return &ring{
+		lines: make([]string, capacity),
+	}
+}
+
+func (r *ring) add(line string) {
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// last returns at most n lines, the oldest first, all lines are returned if n <= 0
+func (r *ring) last(n int) []string {
+	length := r.next
+	if r.full {
+		length = len(r.lines)
+	}
+	if n <= 0 || n > length {
+		n = length
+	}
+	ret := make([]string, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		ret = append(ret, r.lines[(i+len(r.lines))%len(r.lines)])
+	}
+	return ret
+}
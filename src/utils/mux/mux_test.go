@@ -0,0 +1,212 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSessions(config *Config) (client *Session, server *Session) {
+	c1, c2 := net.Pipe()
+	return Client(c1, config), Server(c2, config)
+}
+
+func TestStream(t *testing.T) {
+	assert := assert.New(t)
+	client, server := newTestSessions(nil)
+	defer client.Close()
+	defer server.Close()
+
+	st1, err := client.Open()
+	assert.NoError(err)
+	st2, err := server.Accept()
+	assert.NoError(err)
+	assert.Equal(st1.Id(), st2.Id())
+
+	go st1.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(st2, buf)
+	assert.NoError(err)
+	assert.Equal("hello", string(buf))
+
+	// streams can be opened by both sides
+	st3, err := server.Open()
+	assert.NoError(err)
+	st4, err := client.Accept()
+	assert.NoError(err)
+	assert.NotEqual(st1.Id(), st3.Id())
+	go st4.Write([]byte("world"))
+	_, err = io.ReadFull(st3, buf)
+	assert.NoError(err)
+	assert.Equal("world", string(buf))
+
+	// the peer reads EOF after the stream is closed
+	st1.Close()
+	_, err = st2.Read(buf)
+	assert.Equal(io.EOF, err)
+	_, err = st2.Write(buf)
+	assert.Error(err)
+	st2.Close()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(2, client.NumStreams()+server.NumStreams())
+}
+
+func TestStreamCloseWrite(t *testing.T) {
+	assert := assert.New(t)
+	client, server := newTestSessions(nil)
+	defer client.Close()
+	defer server.Close()
+
+	st1, err := client.Open()
+	assert.NoError(err)
+	st2, err := server.Accept()
+	assert.NoError(err)
+
+	st1.Write([]byte("request"))
+	assert.NoError(st1.CloseWrite())
+	_, err = st1.Write([]byte("more"))
+	assert.Equal(ErrWriteClosed, err)
+
+	// the peer reads all data and EOF, then it can still reply
+	buf, err := ioutil.ReadAll(st2)
+	assert.NoError(err)
+	assert.Equal("request", string(buf))
+	_, err = st2.Write([]byte("response"))
+	assert.NoError(err)
+	assert.NoError(st2.CloseWrite())
+
+	buf, err = ioutil.ReadAll(st1)
+	assert.NoError(err)
+	assert.Equal("response", string(buf))
+
+	st1.Close()
+	st2.Close()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(0, client.NumStreams()+server.NumStreams())
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	assert := assert.New(t)
+	config := DefaultConfig()
+	config.Window = 64 * 1024
+	client, server := newTestSessions(config)
+	defer client.Close()
+	defer server.Close()
+
+	slow, _ := client.Open()
+	slowPeer, _ := server.Accept()
+	fast, _ := client.Open()
+	fastPeer, _ := server.Accept()
+
+	// the slow stream is blocked when the window is used up
+	data := bytes.Repeat([]byte("a"), 1024*1024)
+	written := make(chan int)
+	go func() {
+		n, _ := slow.Write(data)
+		written <- n
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-written:
+		assert.Fail("write should be blocked")
+	default:
+	}
+
+	// other streams are not blocked
+	go fast.Write([]byte("fast"))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(fastPeer, buf)
+	assert.NoError(err)
+	assert.Equal("fast", string(buf))
+
+	received := make(chan []byte)
+	go func() {
+		content, _ := ioutil.ReadAll(slowPeer)
+		received <- content
+	}()
+	assert.Equal(len(data), <-written)
+	slow.Close()
+	assert.Equal(data, <-received)
+}
+
+func TestStreamDeadline(t *testing.T) {
+	assert := assert.New(t)
+	client, server := newTestSessions(nil)
+	defer client.Close()
+	defer server.Close()
+
+	st, _ := client.Open()
+	server.Accept()
+	st.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := st.Read(make([]byte, 1))
+	if assert.Error(err) {
+		assert.True(err.(net.Error).Timeout())
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	assert := assert.New(t)
+	client, server := newTestSessions(nil)
+
+	st, _ := client.Open()
+	peer, _ := server.Accept()
+	go peer.Write([]byte("x"))
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+
+	// buffered data can be read after the session is closed
+	buf := make([]byte, 1)
+	_, err := st.Read(buf)
+	assert.NoError(err)
+	_, err = st.Read(buf)
+	assert.Error(err)
+	select {
+	case <-client.CloseChan():
+	case <-time.After(time.Second):
+		assert.Fail("client session should be closed")
+	}
+	_, err = client.Open()
+	assert.Equal(ErrSessionClosed, err)
+	_, err = client.Accept()
+	assert.Equal(ErrSessionClosed, err)
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	assert := assert.New(t)
+	c1, c2 := net.Pipe()
+	config := &Config{
+		Window:            64 * 1024,
+		KeepAliveInterval: 10 * time.Millisecond,
+		KeepAliveTimeout:  50 * time.Millisecond,
+	}
+	client := Client(c1, config)
+	// the peer never answers pings
+	go ioutil.ReadAll(c2)
+
+	select {
+	case <-client.CloseChan():
+		assert.Error(client.Err())
+	case <-time.After(time.Second):
+		assert.Fail("session should be closed by keepalive timeout")
+	}
+	c2.Close()
+}
@@ -0,0 +1,335 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// frame types
+const (
+	frameOpen   uint8 = iota // open a new stream
+	frameData                // payload of a stream
+	frameWindow              // receiver consumed payload, the sender can send more
+	frameClose               // the stream is closed
+	framePing
+	framePong
+	frameFin // the peer won't send more data on the stream, but still receives
+)
+
+const (
+	headerSize   = 9 // type(1) + stream id(4) + length(4)
+	maxFrameSize = 16 * 1024
+	maxBacklog   = 256 // streams opened by peer but not accepted yet
+)
+
+var (
+	ErrSessionClosed = fmt.Errorf("mux: session is closed")
+	ErrStreamClosed  = fmt.Errorf("mux: stream is closed")
+	ErrWriteClosed   = fmt.Errorf("mux: writing side of stream is closed")
+	ErrTimeout       = &timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "mux: i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+type Config struct {
+	// bytes every stream can receive before they are read
+	Window uint32
+	// a ping is sent every KeepAliveInterval, the session is closed if nothing is received in KeepAliveTimeout
+	KeepAliveInterval time.Duration
+	KeepAliveTimeout  time.Duration
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Window:            256 * 1024,
+		KeepAliveInterval: 10 * time.Second,
+		KeepAliveTimeout:  30 * time.Second,
+	}
+}
+
+// Session multiplexes streams over one connection, both sides can open streams
+type Session struct {
+	conn   net.Conn
+	config *Config
+
+	streams  map[uint32]*Stream
+	nextId   uint32 // odd for clients and even for servers
+	accept   chan *Stream
+	lastRecv time.Time
+	mutex    sync.Mutex
+
+	writeMutex sync.Mutex
+	closed     chan struct{}
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+// Client creates a session on the side which dialed c
+func Client(c net.Conn, config *Config) *Session {
+	return newSession(c, config, 1)
+}
+
+// Server creates a session on the side which accepted c
+func Server(c net.Conn, config *Config) *Session {
+	return newSession(c, config, 2)
+}
+
+func newSession(c net.Conn, config *Config, firstId uint32) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	s := &Session{
+		conn:     c,
+		config:   config,
+		streams:  make(map[uint32]*Stream),
+		nextId:   firstId,
+		accept:   make(chan *Stream, maxBacklog),
+		lastRecv: time.Now(),
+		closed:   make(chan struct{}),
+	}
+	go s.recvLoop()
+	if config.KeepAliveInterval > 0 {
+		go s.keepAlive()
+	}
+	return s
+}
+
+// Open creates a new stream, the peer gets it by Accept
+func (s *Session) Open() (*Stream, error) {
+	s.mutex.Lock()
+	if s.IsClosed() {
+		s.mutex.Unlock()
+		return nil, ErrSessionClosed
+	}
+	id := s.nextId
+	s.nextId += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mutex.Unlock()
+
+	if err := s.writeFrame(frameOpen, id, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept blocks until the peer opens a new stream or the session is closed
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.closed:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Close closes the connection and all streams
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+func (s *Session) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mutex.Lock()
+		s.closeErr = err
+		close(s.closed)
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.mutex.Unlock()
+
+		s.conn.Close()
+		for _, st := range streams {
+			st.remoteClose(ErrSessionClosed)
+		}
+	})
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan is closed when the session is closed
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closed
+}
+
+// Err returns the reason why the session is closed
+func (s *Session) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeErr
+}
+
+func (s *Session) NumStreams() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) writeFrame(t uint8, id uint32, payload []byte) error {
+	buf := make([]byte, headerSize+len(payload))
+	buf[0] = t
+	binary.BigEndian.PutUint32(buf[1:5], id)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(payload)))
+	copy(buf[headerSize:], payload)
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	if _, err := s.conn.Write(buf); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mutex.Lock()
+	delete(s.streams, id)
+	s.mutex.Unlock()
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) recvLoop() {
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.closeWithError(err)
+			return
+		}
+		t := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+		if length > maxFrameSize {
+			s.closeWithError(fmt.Errorf("mux: frame size %d is too large", length))
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			s.closeWithError(err)
+			return
+		}
+		s.mutex.Lock()
+		s.lastRecv = time.Now()
+		s.mutex.Unlock()
+
+		if err := s.handleFrame(t, id, payload); err != nil {
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(t uint8, id uint32, payload []byte) error {
+	switch t {
+	case frameOpen:
+		s.mutex.Lock()
+		if _, ok := s.streams[id]; ok {
+			s.mutex.Unlock()
+			return fmt.Errorf("mux: stream %d is opened twice", id)
+		}
+		st := newStream(s, id)
+		s.streams[id] = st
+		s.mutex.Unlock()
+		select {
+		case s.accept <- st:
+		default:
+			// too many streams are not accepted
+			s.removeStream(id)
+			go s.writeFrame(frameClose, id, nil)
+		}
+	case frameData:
+		// data of streams closed locally is dropped
+		if st := s.getStream(id); st != nil {
+			if err := st.pushData(payload); err != nil {
+				return err
+			}
+		}
+	case frameWindow:
+		if len(payload) != 4 {
+			return fmt.Errorf("mux: invalid window frame")
+		}
+		if st := s.getStream(id); st != nil {
+			st.addWindow(binary.BigEndian.Uint32(payload))
+		}
+	case frameClose:
+		if st := s.getStream(id); st != nil {
+			s.removeStream(id)
+			st.remoteClose(io.EOF)
+		}
+	case frameFin:
+		if st := s.getStream(id); st != nil {
+			st.remoteFin()
+		}
+	case framePing:
+		go s.writeFrame(framePong, 0, nil)
+	case framePong:
+	default:
+		return fmt.Errorf("mux: unknown frame type %d", t)
+	}
+	return nil
+}
+
+func (s *Session) keepAlive() {
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			idle := time.Since(s.lastRecv)
+			s.mutex.Unlock()
+			if s.config.KeepAliveTimeout > 0 && idle > s.config.KeepAliveTimeout {
+				s.closeWithError(fmt.Errorf("mux: keepalive timeout"))
+				return
+			}
+			s.writeFrame(framePing, 0, nil)
+		case <-s.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is one connection in a session, it implements net.Conn
+type Stream struct {
+	id      uint32
+	session *Session
+
+	recvBuf    bytes.Buffer
+	consumed   uint32 // bytes read but not reported to the peer by a window frame
+	readErr    error  // returned after recvBuf is drained, set when the peer closes the stream or its writing side
+	sendWindow uint32
+	closed     bool // closed locally
+	finSent    bool // writing side is closed locally
+	peerClosed bool // closed by the peer or the session, nothing can be written
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+	mutex       sync.Mutex
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:          id,
+		session:     s,
+		sendWindow:  s.config.Window,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait until notified, the deadline is exceeded or the session is closed
+func (st *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	case <-st.session.closed:
+		return nil
+	}
+}
+
+func (st *Stream) Read(p []byte) (n int, err error) {
+	for {
+		st.mutex.Lock()
+		if st.closed {
+			st.mutex.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if st.recvBuf.Len() > 0 {
+			n, _ = st.recvBuf.Read(p)
+			st.consumed += uint32(n)
+			// report consumed bytes when half of the window is used, so the peer is not blocked
+			var update uint32
+			if st.readErr == nil && st.consumed >= st.session.config.Window/2 {
+				update = st.consumed
+				st.consumed = 0
+			}
+			st.mutex.Unlock()
+			if update > 0 {
+				buf := make([]byte, 4)
+				binary.BigEndian.PutUint32(buf, update)
+				st.session.writeFrame(frameWindow, st.id, buf)
+			}
+			return n, nil
+		}
+		if st.readErr != nil {
+			err = st.readErr
+			st.mutex.Unlock()
+			return 0, err
+		}
+		if st.session.IsClosed() {
+			st.mutex.Unlock()
+			return 0, ErrSessionClosed
+		}
+		deadline := st.readDeadline
+		st.mutex.Unlock()
+
+		if err = st.wait(st.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *Stream) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		st.mutex.Lock()
+		if st.closed {
+			st.mutex.Unlock()
+			return n, ErrStreamClosed
+		}
+		if st.finSent {
+			st.mutex.Unlock()
+			return n, ErrWriteClosed
+		}
+		if st.peerClosed {
+			st.mutex.Unlock()
+			return n, fmt.Errorf("mux: stream is closed by peer")
+		}
+		if st.session.IsClosed() {
+			st.mutex.Unlock()
+			return n, ErrSessionClosed
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mutex.Unlock()
+			if err = st.wait(st.writeNotify, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		size := len(p) - n
+		if size > maxFrameSize {
+			size = maxFrameSize
+		}
+		if uint32(size) > st.sendWindow {
+			size = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(size)
+		st.mutex.Unlock()
+
+		if err = st.session.writeFrame(frameData, st.id, p[n:n+size]); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// Close closes both directions of the stream, unread data is dropped
+func (st *Stream) Close() error {
+	st.mutex.Lock()
+	if st.closed {
+		st.mutex.Unlock()
+		return nil
+	}
+	st.closed = true
+	remoteClosed := st.peerClosed
+	st.recvBuf.Reset()
+	st.mutex.Unlock()
+	notify(st.readNotify)
+	notify(st.writeNotify)
+
+	if !remoteClosed {
+		st.session.removeStream(st.id)
+		st.session.writeFrame(frameClose, st.id, nil)
+	}
+	return nil
+}
+
+// CloseWrite closes the writing side of the stream, the peer reads io.EOF after received data
+func (st *Stream) CloseWrite() error {
+	st.mutex.Lock()
+	if st.closed || st.peerClosed {
+		st.mutex.Unlock()
+		return ErrStreamClosed
+	}
+	if st.finSent {
+		st.mutex.Unlock()
+		return nil
+	}
+	st.finSent = true
+	st.mutex.Unlock()
+	notify(st.writeNotify)
+	return st.session.writeFrame(frameFin, st.id, nil)
+}
+
+func (st *Stream) pushData(p []byte) error {
+	st.mutex.Lock()
+	if st.closed {
+		st.mutex.Unlock()
+		return nil
+	}
+	if uint32(st.recvBuf.Len()+len(p)) > st.session.config.Window {
+		st.mutex.Unlock()
+		return fmt.Errorf("mux: stream %d receives more data than the window", st.id)
+	}
+	st.recvBuf.Write(p)
+	st.mutex.Unlock()
+	notify(st.readNotify)
+	return nil
+}
+
+func (st *Stream) addWindow(n uint32) {
+	st.mutex.Lock()
+	st.sendWindow += n
+	st.mutex.Unlock()
+	notify(st.writeNotify)
+}
+
+func (st *Stream) remoteClose(err error) {
+	st.mutex.Lock()
+	if st.readErr == nil {
+		st.readErr = err
+	}
+	st.peerClosed = true
+	st.mutex.Unlock()
+	notify(st.readNotify)
+	notify(st.writeNotify)
+}
+
+func (st *Stream) remoteFin() {
+	st.mutex.Lock()
+	if st.readErr == nil {
+		st.readErr = io.EOF
+	}
+	st.mutex.Unlock()
+	notify(st.readNotify)
+}
+
+func (st *Stream) Id() uint32 {
+	return st.id
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.LocalAddr()
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.RemoteAddr()
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mutex.Lock()
+	st.readDeadline = t
+	st.mutex.Unlock()
+	notify(st.readNotify)
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mutex.Lock()
+	st.writeDeadline = t
+	st.mutex.Unlock()
+	notify(st.writeNotify)
+	return nil
+}
+
+var _ net.Conn = &Stream{}
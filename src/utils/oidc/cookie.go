@@ -0,0 +1,88 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sign encodes v with an expiry time and signs it with HMAC-SHA256, the result can be used as cookie value
+func Sign(secret []byte, v interface{}, expiry time.Time) (string, error) {
+	payload := struct {
+		Expiry int64       `json:"exp"`
+		Value  interface{} `json:"v"`
+	}{
+		Expiry: expiry.Unix(),
+		Value:  v,
+	}
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(buf)
+	return data + "." + base64.RawURLEncoding.EncodeToString(mac(secret, data)), nil
+}
+
+// Verify checks the signature and expiry time of value generated by Sign and decodes it into v
+func Verify(secret []byte, value string, v interface{}) error {
+	pos := strings.LastIndex(value, ".")
+	if pos < 0 {
+		return fmt.Errorf("signed value format error")
+	}
+	data := value[:pos]
+	sig, err := base64.RawURLEncoding.DecodeString(value[pos+1:])
+	if err != nil {
+		return fmt.Errorf("signed value format error")
+	}
+	if !hmac.Equal(sig, mac(secret, data)) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("signed value format error")
+	}
+	payload := struct {
+		Expiry int64           `json:"exp"`
+		Value  json.RawMessage `json:"v"`
+	}{}
+	if err = json.Unmarshal(buf, &payload); err != nil {
+		return err
+	}
+	if payload.Expiry < time.Now().Unix() {
+		return fmt.Errorf("signed value expired")
+	}
+	return json.Unmarshal(payload.Value, v)
+}
+
+func mac(secret []byte, data string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// RandomString returns a url safe random string for state and nonce
+func RandomString() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
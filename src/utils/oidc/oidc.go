@@ -0,0 +1,336 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidc implements the parts of OpenID Connect authorization code flow
+// used by frps: provider discovery, code exchange and id token verification.
+// Only RS256 signed id tokens are supported.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Claims struct {
+	Issuer   string      `json:"iss"`
+	Subject  string      `json:"sub"`
+	Audience interface{} `json:"aud"` // string or []string
+	Expiry   int64       `json:"exp"`
+	Nonce    string      `json:"nonce"`
+	Email    string      `json:"email"`
+	Name     string      `json:"name"`
+
+	// groups claim can be renamed, see Provider.GroupsClaim
+	Groups []string `json:"-"`
+
+	// some providers send it as a string
+	EmailVerified bool `json:"-"`
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	GroupsClaim  string
+
+	client *http.Client
+	meta   *discovery
+	keys   map[string]*rsa.PublicKey
+	mutex  sync.Mutex
+}
+
+func NewProvider(issuer, clientId, clientSecret string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		GroupsClaim:  "groups",
+		client:       &http.Client{Timeout: 10 * time.Second},
+		keys:         make(map[string]*rsa.PublicKey),
+	}
+}
+
+// discover endpoints of the provider, the result is cached after the first success
+func (p *Provider) discover() (*discovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	meta := &discovery{}
+	err := p.getJson(p.Issuer+"/.well-known/openid-configuration", meta)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery error: %v", err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery error: issuer [%s] does not match [%s]", meta.Issuer, p.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JwksUri == "" {
+		return nil, fmt.Errorf("oidc discovery error: endpoints are incomplete")
+	}
+	p.meta = meta
+	return meta, nil
+}
+
+func (p *Provider) getJson(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("get [%s] response code [%d]", u, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// AuthCodeURL returns the url of provider's login page
+func (p *Provider) AuthCodeURL(redirectUri, state, nonce string) (string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientId)
+	v.Set("redirect_uri", redirectUri)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange gets the id token by authorization code and verifies it
+func (p *Provider) Exchange(code, redirectUri, nonce string) (*Claims, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectUri)
+	req, err := http.NewRequest("POST", meta.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request error: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("oidc token request response code [%d]: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	token := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc token response error: %v", err)
+	}
+	if token.IdToken == "" {
+		return nil, fmt.Errorf("oidc token response error: no id_token")
+	}
+	return p.Verify(token.IdToken, nonce)
+}
+
+// Verify checks signature, issuer, audience, expiry and nonce of an id token
+func (p *Provider) Verify(rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("id token format error")
+	}
+	headerBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("id token header error: %v", err)
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err = json.Unmarshal(headerBuf, &header); err != nil {
+		return nil, fmt.Errorf("id token header error: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("id token alg [%s] is not supported", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id token signature error: %v", err)
+	}
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return nil, fmt.Errorf("id token signature verification failed")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("id token payload error: %v", err)
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("id token payload error: %v", err)
+	}
+	claims.Groups = groupsClaim(payload, p.GroupsClaim)
+	claims.EmailVerified = emailVerifiedClaim(payload)
+
+	if strings.TrimRight(claims.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("id token issuer [%s] mismatch", claims.Issuer)
+	}
+	if !audienceContains(claims.Audience, p.ClientId) {
+		return nil, fmt.Errorf("id token audience mismatch")
+	}
+	if claims.Expiry < time.Now().Unix() {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func groupsClaim(payload []byte, name string) []string {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil
+	}
+	groups := make([]string, 0)
+	switch v := raw[name].(type) {
+	case string:
+		groups = append(groups, v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	return groups
+}
+
+func emailVerifiedClaim(payload []byte) bool {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return false
+	}
+	switch v := raw["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func audienceContains(aud interface{}, clientId string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientId
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// get public key by kid, keys are fetched again if kid is unknown for supporting key rotation
+func (p *Provider) getKey(kid string) (*rsa.PublicKey, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err = p.getJson(meta.JwksUri, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc get jwks error: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("id token key [%s] not found", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a local stand-in identity provider which issues id tokens for any authorization code,
+// the code is used as the nonce of the issued id token
+type testProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key error: %v", err)
+	}
+	tp := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.server.URL,
+			"authorization_endpoint": tp.server.URL + "/authorize",
+			"token_endpoint":         tp.server.URL + "/token",
+			"jwks_uri":               tp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pwd, _ := r.BasicAuth()
+		if user != "client" || pwd != "secret" || r.PostFormValue("grant_type") != "authorization_code" {
+			http.Error(w, "invalid_client", 401)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": tp.sign("k1", r.PostFormValue("code")),
+		})
+	})
+	tp.server = httptest.NewServer(mux)
+	tp.claims = map[string]interface{}{
+		"iss":            tp.server.URL,
+		"sub":            "user1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "user1@example.com",
+		"email_verified": true,
+		"groups":         []string{"dev", "ops"},
+	}
+	return tp
+}
+
+func (tp *testProvider) sign(kid string, nonce string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	tp.claims["nonce"] = nonce
+	payload, _ := json.Marshal(tp.claims)
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(data))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, tp.key, crypto.SHA256, hashed[:])
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	assert := assert.New(t)
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+
+	p := NewProvider(tp.server.URL, "client", "secret")
+	authUrl, err := p.AuthCodeURL("http://app.example.com/cb", "state1", "nonce1")
+	assert.NoError(err)
+	u, err := url.Parse(authUrl)
+	assert.NoError(err)
+	assert.Equal("/authorize", u.Path)
+	assert.Equal("client", u.Query().Get("client_id"))
+	assert.Equal("http://app.example.com/cb", u.Query().Get("redirect_uri"))
+	assert.Equal("state1", u.Query().Get("state"))
+	assert.Equal("nonce1", u.Query().Get("nonce"))
+	assert.True(strings.Contains(u.Query().Get("scope"), "openid"))
+}
+
+func TestExchange(t *testing.T) {
+	assert := assert.New(t)
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+
+	p := NewProvider(tp.server.URL, "client", "secret")
+	claims, err := p.Exchange("nonce1", "http://app.example.com/cb", "nonce1")
+	assert.NoError(err)
+	assert.Equal("user1", claims.Subject)
+	assert.Equal("user1@example.com", claims.Email)
+	assert.True(claims.EmailVerified)
+	assert.Equal([]string{"dev", "ops"}, claims.Groups)
+
+	// nonce of id token is different from the one we sent
+	_, err = p.Exchange("nonce2", "http://app.example.com/cb", "nonce1")
+	assert.Error(err)
+
+	// wrong client secret
+	p2 := NewProvider(tp.server.URL, "client", "wrong")
+	_, err = p2.Exchange("nonce1", "http://app.example.com/cb", "nonce1")
+	assert.Error(err)
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+	tp := newTestProvider(t)
+	defer tp.server.Close()
+	p := NewProvider(tp.server.URL, "client", "secret")
+
+	token := tp.sign("k1", "n")
+	_, err := p.Verify(token, "n")
+	assert.NoError(err)
+
+	// tampered payload
+	parts := strings.Split(token, ".")
+	tp.claims["email"] = "admin@example.com"
+	parts[1] = strings.Split(tp.sign("k1", "n"), ".")[1]
+	_, err = p.Verify(strings.Join(parts, "."), "n")
+	assert.Error(err)
+
+	// unknown key
+	_, err = p.Verify(tp.sign("k2", "n"), "n")
+	assert.Error(err)
+
+	// wrong audience
+	tp.claims["aud"] = []string{"other"}
+	_, err = p.Verify(tp.sign("k1", "n"), "n")
+	assert.Error(err)
+	tp.claims["aud"] = []string{"other", "client"}
+	_, err = p.Verify(tp.sign("k1", "n"), "n")
+	assert.NoError(err)
+
+	// expired
+	tp.claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	_, err = p.Verify(tp.sign("k1", "n"), "n")
+	assert.Error(err)
+}
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+	secret := []byte("secret")
+	value, err := Sign(secret, map[string]string{"sub": "user1"}, time.Now().Add(time.Minute))
+	assert.NoError(err)
+
+	v := make(map[string]string)
+	assert.NoError(Verify(secret, value, &v))
+	assert.Equal("user1", v["sub"])
+
+	assert.Error(Verify([]byte("other"), value, &v))
+	assert.Error(Verify(secret, "x"+value, &v))
+
+	value, _ = Sign(secret, v, time.Now().Add(-time.Second))
+	assert.Error(Verify(secret, value, &v))
+}
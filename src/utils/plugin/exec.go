@@ -0,0 +1,126 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("exec", NewExecPlugin)
+}
+
+// ExecPlugin runs Command for every connection like inetd, stdin and stdout are connected to it
+type ExecPlugin struct {
+	Command  []string
+	Env      bool // pass ConnInfo to the command by environment variables FRP_*
+	MaxConns int64
+	Timeout  time.Duration // the command is killed if it runs longer, no limit if zero
+
+	conns int64
+}
+
+func NewExecPlugin(params map[string]string) (Plugin, error) {
+	p := &ExecPlugin{
+		Command:  strings.Fields(params["plugin_command"]),
+		Env:      params["plugin_env"] == "true",
+		MaxConns: 10,
+	}
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("plugin_command is required by exec plugin")
+	}
+	if tmpStr, ok := params["plugin_max_conns"]; ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("plugin_max_conns error")
+		}
+		p.MaxConns = v
+	}
+	if tmpStr, ok := params["plugin_timeout"]; ok {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("plugin_timeout error")
+		}
+		p.Timeout = time.Duration(v) * time.Second
+	}
+	return p, nil
+}
+
+func (p *ExecPlugin) Handle(c io.ReadWriteCloser, info *ConnInfo) error {
+	defer c.Close()
+	if atomic.AddInt64(&p.conns, 1) > p.MaxConns {
+		atomic.AddInt64(&p.conns, -1)
+		return fmt.Errorf("too many connections, max is %d", p.MaxConns)
+	}
+	defer atomic.AddInt64(&p.conns, -1)
+
+	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	if p.Env {
+		cmd.Env = append(os.Environ(),
+			"FRP_PROXY_NAME="+info.ProxyName,
+			"FRP_PROXY_TYPE="+info.ProxyType,
+			"FRP_SERVER_ADDR="+info.ServerAddr,
+			fmt.Sprintf("FRP_CONN_ID=%d", info.ConnId),
+		)
+	}
+	// stdout is read by ourselves, so the command can be stopped even if its children hold the pipe
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdoutReader.Close()
+	cmd.Stdout = stdoutWriter
+	// stdin is copied by ourselves, or Wait blocks until c is closed by the other side
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return err
+	}
+	err = cmd.Start()
+	stdoutWriter.Close()
+	if err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdin, c)
+		stdin.Close()
+	}()
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(c, stdoutReader)
+		close(copied)
+	}()
+
+	if p.Timeout > 0 {
+		timer := time.AfterFunc(p.Timeout, func() {
+			cmd.Process.Kill()
+			stdoutReader.Close()
+		})
+		defer timer.Stop()
+	}
+	err = cmd.Wait()
+	<-copied
+	if err != nil {
+		return fmt.Errorf("command exits: %v", err)
+	}
+	return nil
+}
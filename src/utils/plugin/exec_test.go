@@ -0,0 +1,92 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeScript(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is required")
+	}
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frp_plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	echo := writeScript(t, dir, "echo.sh", "read line\necho \"$line $FRP_PROXY_NAME $FRP_CONN_ID\"\n")
+	sleep := writeScript(t, dir, "sleep.sh", "sleep 5\n")
+
+	_, err = Create("exec", map[string]string{})
+	assert.Error(err)
+	_, err = Create("unknown", map[string]string{"plugin_command": echo})
+	assert.Error(err)
+	_, err = Create("exec", map[string]string{"plugin_command": echo, "plugin_max_conns": "0"})
+	assert.Error(err)
+
+	// stdin and stdout are connected to the connection, metadata is passed by environment variables
+	p, err := Create("exec", map[string]string{"plugin_command": echo, "plugin_env": "true"})
+	if !assert.NoError(err) {
+		return
+	}
+	user, c := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Handle(c, &ConnInfo{ProxyName: "test", ConnId: 3})
+	}()
+	go user.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(user).ReadString('\n')
+	assert.NoError(err)
+	assert.Equal("hello test 3\n", line)
+	assert.NoError(<-errCh)
+	user.Close()
+
+	// the command is killed after timeout and other connections are refused when it's running
+	p, err = Create("exec", map[string]string{"plugin_command": sleep, "plugin_timeout": "1", "plugin_max_conns": "1"})
+	if !assert.NoError(err) {
+		return
+	}
+	user, c = net.Pipe()
+	defer user.Close()
+	start := time.Now()
+	go func() {
+		errCh <- p.Handle(c, &ConnInfo{})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	user2, c2 := net.Pipe()
+	defer user2.Close()
+	assert.Error(p.Handle(c2, &ConnInfo{}))
+	assert.Error(<-errCh)
+	assert.True(time.Since(start) < 3*time.Second)
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Plugin serves connections from users in frpc instead of the local service
+type Plugin interface {
+	// serve one connection and close it when finished, it blocks
+	Handle(c io.ReadWriteCloser, info *ConnInfo) error
+}
+
+// ConnInfo describes one connection passed to plugins
+type ConnInfo struct {
+	ProxyName  string
+	ProxyType  string
+	ServerAddr string
+	ConnId     int64
+}
+
+// create a plugin by options of the proxy which names start with "plugin_"
+type Creator func(params map[string]string) (Plugin, error)
+
+var (
+	creators      map[string]Creator = make(map[string]Creator)
+	creatorsMutex sync.RWMutex
+)
+
+func Register(name string, fn Creator) {
+	creatorsMutex.Lock()
+	defer creatorsMutex.Unlock()
+	if _, ok := creators[name]; ok {
+		panic(fmt.Sprintf("plugin [%s] is already registered", name))
+	}
+	creators[name] = fn
+}
+
+func Create(name string, params map[string]string) (Plugin, error) {
+	creatorsMutex.RLock()
+	fn, ok := creators[name]
+	creatorsMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("plugin [%s] is not supported", name)
+	}
+	return fn(params)
+}
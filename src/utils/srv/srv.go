@@ -0,0 +1,134 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package srv
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LookupFunc returns SRV records of name, it's replaced by a local DNS stand-in in tests
+type LookupFunc func(name string) ([]*net.SRV, error)
+
+func lookupSRV(name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	return addrs, err
+}
+
+// IsSrvName returns true if host is a SRV record name like _frp._tcp.example.com
+func IsSrvName(host string) bool {
+	return strings.HasPrefix(host, "_")
+}
+
+// Resolver resolves a SRV record name into addresses ordered by priority and weight,
+// the last successful result is used when DNS is unavailable.
+type Resolver struct {
+	Name   string
+	Lookup LookupFunc
+
+	cached []*net.SRV
+	rand   *rand.Rand
+	mutex  sync.Mutex
+}
+
+func NewResolver(name string) *Resolver {
+	return &Resolver{
+		Name:   name,
+		Lookup: lookupSRV,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Resolve looks up the records every time it's called and returns addresses to try in order,
+// if the lookup fails, cached addresses are returned with the error
+func (r *Resolver) Resolve() (addrs []string, err error) {
+	records, err := r.Lookup(r.Name)
+	if err == nil && len(records) == 0 {
+		err = fmt.Errorf("no SRV records found for [%s]", r.Name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		if r.cached == nil {
+			return nil, err
+		}
+		records = r.cached
+	} else {
+		r.cached = records
+	}
+
+	// a single record with target "." means the service is not available
+	if len(records) == 1 && records[0].Target == "." {
+		return nil, fmt.Errorf("service [%s] is not available", r.Name)
+	}
+	for _, record := range r.order(records) {
+		host := strings.TrimSuffix(record.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return addrs, err
+}
+
+type byPriority []*net.SRV
+
+func (s byPriority) Len() int           { return len(s) }
+func (s byPriority) Less(i, j int) bool { return s[i].Priority < s[j].Priority }
+func (s byPriority) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// order records as RFC 2782: lower priority first, records with the same priority
+// are chosen randomly with probability proportional to their weights
+func (r *Resolver) order(records []*net.SRV) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.Stable(byPriority(sorted))
+
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+			j++
+		}
+		r.shuffleByWeight(sorted[i:j])
+		i = j
+	}
+	return sorted
+}
+
+func (r *Resolver) shuffleByWeight(records []*net.SRV) {
+	// records with weight 0 have a very small chance to be chosen before others
+	sum := 0
+	for _, record := range records {
+		sum += int(record.Weight)
+	}
+	for i := 0; i < len(records); i++ {
+		if sum == 0 {
+			return
+		}
+		n := r.rand.Intn(sum + 1)
+		for j := i; j < len(records); j++ {
+			n -= int(records[j].Weight)
+			if n <= 0 {
+				records[i], records[j] = records[j], records[i]
+				break
+			}
+		}
+		sum -= int(records[i].Weight)
+	}
+}
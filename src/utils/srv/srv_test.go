@@ -0,0 +1,118 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package srv
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a local DNS stand-in, records can be changed and lookups can fail
+type testDns struct {
+	records []*net.SRV
+	down    bool
+}
+
+func (d *testDns) lookup(name string) ([]*net.SRV, error) {
+	if d.down {
+		return nil, fmt.Errorf("lookup %s: no such host", name)
+	}
+	if name != "_frp._tcp.example.com" {
+		return nil, nil
+	}
+	return d.records, nil
+}
+
+func newTestResolver(dns *testDns) *Resolver {
+	r := NewResolver("_frp._tcp.example.com")
+	r.Lookup = dns.lookup
+	return r
+}
+
+func TestIsSrvName(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsSrvName("_frp._tcp.example.com"))
+	assert.False(IsSrvName("example.com"))
+	assert.False(IsSrvName("127.0.0.1"))
+}
+
+func TestResolvePriority(t *testing.T) {
+	assert := assert.New(t)
+	dns := &testDns{records: []*net.SRV{
+		{Target: "backup.example.com.", Port: 7001, Priority: 20, Weight: 1},
+		{Target: "a.example.com.", Port: 7000, Priority: 10, Weight: 1},
+	}}
+	r := newTestResolver(dns)
+	for i := 0; i < 10; i++ {
+		addrs, err := r.Resolve()
+		assert.NoError(err)
+		assert.Equal([]string{"a.example.com:7000", "backup.example.com:7001"}, addrs)
+	}
+
+	// re-resolved on every call
+	dns.records = []*net.SRV{{Target: "b.example.com.", Port: 7002, Priority: 10, Weight: 1}}
+	addrs, err := r.Resolve()
+	assert.NoError(err)
+	assert.Equal([]string{"b.example.com:7002"}, addrs)
+}
+
+func TestResolveWeight(t *testing.T) {
+	assert := assert.New(t)
+	dns := &testDns{records: []*net.SRV{
+		{Target: "a.example.com.", Port: 7000, Priority: 10, Weight: 90},
+		{Target: "b.example.com.", Port: 7000, Priority: 10, Weight: 10},
+		{Target: "c.example.com.", Port: 7000, Priority: 10, Weight: 0},
+	}}
+	r := newTestResolver(dns)
+	first := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		addrs, err := r.Resolve()
+		assert.NoError(err)
+		assert.Len(addrs, 3)
+		first[addrs[0]]++
+	}
+	assert.True(first["a.example.com:7000"] > 800, "%v", first)
+	assert.True(first["b.example.com:7000"] > 50, "%v", first)
+	assert.True(first["c.example.com:7000"] < 20, "%v", first)
+}
+
+func TestResolveCached(t *testing.T) {
+	assert := assert.New(t)
+	dns := &testDns{down: true}
+	r := newTestResolver(dns)
+	_, err := r.Resolve()
+	assert.Error(err)
+
+	dns.down = false
+	dns.records = []*net.SRV{{Target: "a.example.com.", Port: 7000, Priority: 10, Weight: 1}}
+	addrs, err := r.Resolve()
+	assert.NoError(err)
+	assert.Equal([]string{"a.example.com:7000"}, addrs)
+
+	// cached result is used but the error is still returned
+	dns.down = true
+	addrs, err = r.Resolve()
+	assert.Error(err)
+	assert.Equal([]string{"a.example.com:7000"}, addrs)
+
+	// service is not available
+	dns.down = false
+	dns.records = []*net.SRV{{Target: ".", Priority: 0, Weight: 0}}
+	_, err = r.Resolve()
+	assert.Error(err)
+}
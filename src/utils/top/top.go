@@ -0,0 +1,313 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	clearScreen = "\033[H\033[2J"
+	boldOn      = "\033[1m"
+	boldOff     = "\033[0m"
+)
+
+type Column struct {
+	Title   string
+	Width   int
+	Numeric bool // numeric columns are sorted by Row.Values and in descending order by default
+}
+
+type Row struct {
+	Cells  []string
+	Values []float64 // only used for sorting numeric columns, same length as Cells
+}
+
+type Snapshot struct {
+	Title   string
+	Columns []Column
+	Rows    []Row
+	Events  []string // recent events, newest last
+}
+
+// FetchFunc is called once every refresh interval to get the newest data
+type FetchFunc func() (*Snapshot, error)
+
+type View struct {
+	fetch    FetchFunc
+	interval time.Duration
+	sortCol  int
+	desc     bool
+	out      io.Writer
+}
+
+func NewView(fetch FetchFunc, interval time.Duration, out io.Writer) *View {
+	return &View{
+		fetch:    fetch,
+		interval: interval,
+		out:      out,
+	}
+}
+
+// SortBy sets the sort column by its index or its title, case insensitive
+func (v *View) SortBy(col string, columns []Column) error {
+	if n, err := strconv.Atoi(col); err == nil {
+		if n < 1 || n > len(columns) {
+			return fmt.Errorf("sort column [%d] out of range", n)
+		}
+		v.setSortCol(n-1, columns)
+		return nil
+	}
+	for i, c := range columns {
+		if strings.EqualFold(c.Title, col) {
+			v.setSortCol(i, columns)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort column [%s]", col)
+}
+
+func (v *View) setSortCol(i int, columns []Column) {
+	if v.sortCol == i {
+		v.desc = !v.desc
+		return
+	}
+	v.sortCol = i
+	v.desc = columns[i].Numeric
+}
+
+// Run refreshes the view until "q" is read from in or in is closed.
+// Every line read from in is a command:
+//
+//	<n> or <title>  sort by column, choose it again for reversing the order
+//	q               quit
+func (v *View) Run(in io.Reader) error {
+	cmdCh := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			cmdCh <- strings.TrimSpace(scanner.Text())
+		}
+		close(cmdCh)
+	}()
+
+	var (
+		snapshot *Snapshot
+		err      error
+		errMsg   string
+	)
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		snapshot, err = v.fetch()
+		if err != nil {
+			errMsg = err.Error()
+		} else {
+			errMsg = ""
+		}
+		v.render(snapshot, errMsg)
+
+		// wait for next refresh, commands only redraw the current snapshot
+		refresh := false
+		for !refresh {
+			select {
+			case <-ticker.C:
+				refresh = true
+			case cmd, ok := <-cmdCh:
+				if !ok || cmd == "q" {
+					return nil
+				}
+				if cmd == "" || snapshot == nil {
+					continue
+				}
+				if err := v.SortBy(cmd, snapshot.Columns); err != nil {
+					v.render(snapshot, err.Error())
+				} else {
+					v.render(snapshot, errMsg)
+				}
+			}
+		}
+	}
+}
+
+func (v *View) render(s *Snapshot, errMsg string) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(clearScreen)
+	if s != nil {
+		Render(buf, s, v.sortCol, v.desc)
+	}
+	if errMsg != "" {
+		fmt.Fprintf(buf, "\nerror: %s\n", errMsg)
+	}
+	buf.WriteString("\n<column number or name> + Enter: sort, q + Enter: quit\n")
+	v.out.Write(buf.Bytes())
+}
+
+// for sort by one column
+type rowList struct {
+	rows    []Row
+	col     int
+	numeric bool
+	desc    bool
+}
+
+func (l *rowList) Len() int      { return len(l.rows) }
+func (l *rowList) Swap(i, j int) { l.rows[i], l.rows[j] = l.rows[j], l.rows[i] }
+func (l *rowList) Less(i, j int) bool {
+	if l.desc {
+		i, j = j, i
+	}
+	if l.numeric {
+		return l.rows[i].Values[l.col] < l.rows[j].Values[l.col]
+	}
+	return l.rows[i].Cells[l.col] < l.rows[j].Cells[l.col]
+}
+
+// Render writes snapshot as a table sorted by column sortCol
+func Render(w io.Writer, s *Snapshot, sortCol int, desc bool) {
+	rows := make([]Row, len(s.Rows))
+	copy(rows, s.Rows)
+	if sortCol >= 0 && sortCol < len(s.Columns) {
+		sort.Stable(&rowList{
+			rows:    rows,
+			col:     sortCol,
+			numeric: s.Columns[sortCol].Numeric,
+			desc:    desc,
+		})
+	}
+
+	fmt.Fprintf(w, "%s    %s\n\n", s.Title, time.Now().Format("2006-01-02 15:04:05"))
+	header := make([]string, len(s.Columns))
+	widths := make([]int, len(s.Columns))
+	for i, c := range s.Columns {
+		title := fmt.Sprintf("%d:%s", i+1, c.Title)
+		// column is never narrower than its title with the sort mark
+		widths[i] = c.Width
+		if len(title)+2 > widths[i] {
+			widths[i] = len(title) + 2
+		}
+		if i == sortCol {
+			if desc {
+				title += "v"
+			} else {
+				title += "^"
+			}
+		}
+		header[i] = pad(title, widths[i])
+	}
+	fmt.Fprintf(w, "%s%s%s\n", boldOn, strings.Join(header, " "), boldOff)
+	for _, r := range rows {
+		cells := make([]string, len(s.Columns))
+		for i := range s.Columns {
+			cells[i] = pad(r.Cells[i], widths[i])
+		}
+		fmt.Fprintln(w, strings.Join(cells, " "))
+	}
+
+	if len(s.Events) > 0 {
+		fmt.Fprintf(w, "\n%sRecent events%s\n", boldOn, boldOff)
+		for _, e := range s.Events {
+			fmt.Fprintln(w, e)
+		}
+	}
+}
+
+func pad(s string, width int) string {
+	if len(s) > width {
+		if width <= 1 {
+			return s[:width]
+		}
+		return s[:width-1] + "~"
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// FormatBytes formats bytes count like 1.5KB, 20.0MB
+func FormatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+// GetJson requests url with basic auth and decodes the response body into v
+func GetJson(url string, user string, pwd string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if user != "" || pwd != "" {
+		req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+pwd)))
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("http response code [%d]: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// EventLog keeps a fixed number of recent events
+type EventLog struct {
+	max    int
+	events []string
+}
+
+func NewEventLog(max int) *EventLog {
+	return &EventLog{
+		max:    max,
+		events: make([]string, 0, max),
+	}
+}
+
+func (l *EventLog) Add(format string, v ...interface{}) {
+	e := time.Now().Format("15:04:05") + " " + fmt.Sprintf(format, v...)
+	if len(l.events) == l.max {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:l.max-1]
+	}
+	l.events = append(l.events, e)
+}
+
+func (l *EventLog) Events() []string {
+	res := make([]string, len(l.events))
+	copy(res, l.events)
+	return res
+}
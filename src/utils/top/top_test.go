@@ -0,0 +1,80 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSnapshot = &Snapshot{
+	Title: "test",
+	Columns: []Column{
+		{Title: "Name", Width: 8},
+		{Title: "Conns", Width: 6, Numeric: true},
+	},
+	Rows: []Row{
+		{Cells: []string{"b", "2"}, Values: []float64{0, 2}},
+		{Cells: []string{"a", "10"}, Values: []float64{0, 10}},
+		{Cells: []string{"c", "1"}, Values: []float64{0, 1}},
+	},
+}
+
+func renderNames(sortCol int, desc bool) []string {
+	buf := new(bytes.Buffer)
+	Render(buf, testSnapshot, sortCol, desc)
+	names := make([]string, 0)
+	for _, line := range strings.Split(buf.String(), "\n")[3:] {
+		if line == "" {
+			break
+		}
+		names = append(names, strings.Fields(line)[0])
+	}
+	return names
+}
+
+func TestRenderSort(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"a", "b", "c"}, renderNames(0, false))
+	assert.Equal([]string{"a", "b", "c"}, renderNames(1, true))
+	assert.Equal([]string{"c", "b", "a"}, renderNames(1, false))
+}
+
+func TestSortBy(t *testing.T) {
+	assert := assert.New(t)
+	v := NewView(nil, 0, nil)
+	assert.NoError(v.SortBy("conns", testSnapshot.Columns))
+	assert.Equal(1, v.sortCol)
+	assert.True(v.desc)
+	assert.NoError(v.SortBy("2", testSnapshot.Columns))
+	assert.False(v.desc)
+	assert.Error(v.SortBy("3", testSnapshot.Columns))
+	assert.Error(v.SortBy("unknown", testSnapshot.Columns))
+}
+
+func TestEventLog(t *testing.T) {
+	assert := assert.New(t)
+	l := NewEventLog(2)
+	l.Add("1")
+	l.Add("2")
+	l.Add("3")
+	events := l.Events()
+	assert.Equal(2, len(events))
+	assert.True(strings.HasSuffix(events[0], " 2"))
+	assert.True(strings.HasSuffix(events[1], " 3"))
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package topk finds heavy hitters with bounded memory by space-saving counting.
+package topk
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Item struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	// Count may be overestimated by at most Error
+	Error int64 `json:"error"`
+}
+
+type ItemList []*Item
+
+func (l ItemList) Len() int { return len(l) }
+func (l ItemList) Less(i, j int) bool {
+	if l[i].Count == l[j].Count {
+		return l[i].Key < l[j].Key
+	}
+	return l[i].Count > l[j].Count
+}
+func (l ItemList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+type counterItem struct {
+	Item
+	index int // index in heap
+}
+
+// min heap by count
+type counterHeap []*counterItem
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *counterHeap) Push(x interface{}) {
+	item := x.(*counterItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *counterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[0 : n-1]
+	return item
+}
+
+// Counter keeps at most capacity keys, when it's full, the key with minimum count is replaced by the new one
+// and the new key inherits its count as error. It's not safe for concurrent use.
+type Counter struct {
+	capacity int
+	items    map[string]*counterItem
+	h        counterHeap
+}
+
+func NewCounter(capacity int) *Counter {
+	return &Counter{
+		capacity: capacity,
+		items:    make(map[string]*counterItem),
+		h:        make(counterHeap, 0),
+	}
+}
+
+func (c *Counter) Add(key string, weight int64) {
+	if item, ok := c.items[key]; ok {
+		item.Count += weight
+		heap.Fix(&c.h, item.index)
+		return
+	}
+
+	if len(c.h) < c.capacity {
+		item := &counterItem{Item: Item{Key: key, Count: weight}}
+		c.items[key] = item
+		heap.Push(&c.h, item)
+		return
+	}
+
+	// replace the minimum one
+	item := c.h[0]
+	delete(c.items, item.Key)
+	item.Key = key
+	item.Error = item.Count
+	item.Count += weight
+	c.items[key] = item
+	heap.Fix(&c.h, 0)
+}
+
+// Top returns at most n items with largest counts, all items are returned if n <= 0
+func (c *Counter) Top(n int) []*Item {
+	result := make(ItemList, 0, len(c.h))
+	for _, item := range c.h {
+		tmpItem := item.Item
+		result = append(result, &tmpItem)
+	}
+	sort.Sort(result)
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func (c *Counter) Reset() {
+	c.items = make(map[string]*counterItem)
+	c.h = c.h[:0]
+}
+
+// Window counts heavy hitters in a sliding window which is divided into buckets,
+// the oldest bucket is dropped when time goes on. It's safe for concurrent use.
+type Window struct {
+	span      time.Duration // span of one bucket
+	buckets   []*Counter
+	bucketIds []int64 // bucket id is the number of spans since epoch
+
+	// for testing
+	now func() time.Time
+
+	mutex sync.Mutex
+}
+
+// NewWindow creates a window with length span*bucketNum
+func NewWindow(capacity int, span time.Duration, bucketNum int) *Window {
+	w := &Window{
+		span:      span,
+		buckets:   make([]*Counter, bucketNum),
+		bucketIds: make([]int64, bucketNum),
+		now:       time.Now,
+	}
+	for i := range w.buckets {
+		w.buckets[i] = NewCounter(capacity)
+		w.bucketIds[i] = -1
+	}
+	return w
+}
+
+func (w *Window) Length() time.Duration {
+	return w.span * time.Duration(len(w.buckets))
+}
+
+func (w *Window) Add(key string, weight int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	id := w.now().UnixNano() / int64(w.span)
+	index := int(id % int64(len(w.buckets)))
+	if w.bucketIds[index] != id {
+		w.buckets[index].Reset()
+		w.bucketIds[index] = id
+	}
+	w.buckets[index].Add(key, weight)
+}
+
+// Top merges all buckets in the window by summing counts and errors of the same key
+func (w *Window) Top(n int) []*Item {
+	w.mutex.Lock()
+	id := w.now().UnixNano() / int64(w.span)
+	merged := make(map[string]*Item)
+	for i, c := range w.buckets {
+		if w.bucketIds[i] <= id-int64(len(w.buckets)) || w.bucketIds[i] > id {
+			continue
+		}
+		for _, item := range c.h {
+			if m, ok := merged[item.Key]; ok {
+				m.Count += item.Count
+				m.Error += item.Error
+			} else {
+				tmpItem := item.Item
+				merged[item.Key] = &tmpItem
+			}
+		}
+	}
+	w.mutex.Unlock()
+
+	result := make(ItemList, 0, len(merged))
+	for _, item := range merged {
+		result = append(result, item)
+	}
+	sort.Sort(result)
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package topk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	assert := assert.New(t)
+	c := NewCounter(3)
+	c.Add("a", 10)
+	c.Add("b", 5)
+	c.Add("c", 1)
+	c.Add("a", 10)
+
+	top := c.Top(2)
+	assert.Equal(2, len(top))
+	assert.Equal(&Item{Key: "a", Count: 20}, top[0])
+	assert.Equal(&Item{Key: "b", Count: 5}, top[1])
+
+	// c has the minimum count and is replaced
+	c.Add("d", 2)
+	top = c.Top(0)
+	assert.Equal(3, len(top))
+	assert.Equal(&Item{Key: "d", Count: 3, Error: 1}, top[2])
+}
+
+func TestCounterHeavyHitters(t *testing.T) {
+	assert := assert.New(t)
+	c := NewCounter(10)
+	// heavy hitters must be found among lots of light keys
+	for i := 0; i < 1000; i++ {
+		c.Add(fmt.Sprintf("light%d", i), 1)
+		if i%10 == 0 {
+			c.Add("heavy1", 10)
+			c.Add("heavy2", 5)
+		}
+	}
+	top := c.Top(2)
+	assert.Equal("heavy1", top[0].Key)
+	assert.Equal("heavy2", top[1].Key)
+	assert.True(top[0].Count-top[0].Error <= 1000 && top[0].Count >= 1000)
+}
+
+func TestWindow(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	w := NewWindow(10, time.Second, 3)
+	w.now = func() time.Time { return now }
+
+	w.Add("a", 1)
+	now = now.Add(time.Second)
+	w.Add("a", 2)
+	w.Add("b", 1)
+	now = now.Add(time.Second)
+	w.Add("b", 5)
+
+	top := w.Top(0)
+	assert.Equal([]*Item{{Key: "b", Count: 6}, {Key: "a", Count: 3}}, top)
+
+	// the first bucket is out of window
+	now = now.Add(time.Second)
+	top = w.Top(0)
+	assert.Equal([]*Item{{Key: "b", Count: 6}, {Key: "a", Count: 2}}, top)
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(0, len(w.Top(0)))
+}
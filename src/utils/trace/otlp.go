@@ -0,0 +1,162 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OtlpExporter sends spans to an OpenTelemetry collector by OTLP/HTTP with JSON encoding
+type OtlpExporter struct {
+	Endpoint    string // such as http://127.0.0.1:4318/v1/traces
+	ServiceName string
+	Client      *http.Client
+}
+
+func NewOtlpExporter(endpoint string, serviceName string) *OtlpExporter {
+	return &OtlpExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// status codes and span kinds defined by OTLP
+const (
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+	otlpKindInternal = 1
+)
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (e *OtlpExporter) Export(spans []*Span) error {
+	scopeSpans := &otlpScopeSpans{
+		Scope: otlpScope{Name: "frp"},
+		Spans: make([]*otlpSpan, 0, len(spans)),
+	}
+	for _, s := range spans {
+		s.mutex.Lock()
+		span := &otlpSpan{
+			TraceId:           s.TraceId,
+			SpanId:            s.SpanId,
+			ParentSpanId:      s.ParentId,
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: unixNano(s.StartTime),
+			EndTimeUnixNano:   unixNano(s.EndTime),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+		for _, attr := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: attr.Key, Value: otlpValue{StringValue: attr.Value}})
+		}
+		for _, event := range s.Events {
+			span.Events = append(span.Events, otlpEvent{TimeUnixNano: unixNano(event.Time), Name: event.Name})
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		s.mutex.Unlock()
+		scopeSpans.Spans = append(scopeSpans.Spans, span)
+	}
+
+	req := &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.ServiceName}}},
+				},
+				ScopeSpans: []*otlpScopeSpans{scopeSpans},
+			},
+		},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := e.Client.Post(e.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("export spans error: %v", err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("export spans error, response code [%d]", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTracer is used by Start, tracing is disabled if it's nil
+var DefaultTracer *Tracer
+
+// Start creates a span with DefaultTracer, parent is a W3C traceparent and a new trace is started if it's invalid.
+// It returns nil if tracing is disabled, all methods of a nil span do nothing.
+func Start(name string, parent string) *Span {
+	if DefaultTracer == nil {
+		return nil
+	}
+	return DefaultTracer.Start(name, parent)
+}
+
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+type Event struct {
+	Name string
+	Time time.Time
+}
+
+// Span records one operation and the time of its phases by events
+type Span struct {
+	TraceId    string // 32 hex digits
+	SpanId     string // 16 hex digits
+	ParentId   string
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes []Attribute
+	Events     []Event
+	Error      string
+
+	tracer *Tracer
+	ended  bool
+	mutex  sync.Mutex
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+func (s *Span) AddEvent(name string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Events = append(s.Events, Event{Name: name, Time: time.Now()})
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Error = err.Error()
+}
+
+// Child creates a span in the same trace
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(name, s.Traceparent())
+}
+
+// Traceparent returns the W3C trace context of the span, it's passed to frpc or frps in messages
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + s.TraceId + "-" + s.SpanId + "-01"
+}
+
+// End finishes the span and exports it, it can be called many times
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+	s.tracer.export(s)
+}
+
+func parseTraceparent(traceparent string) (traceId string, spanId string, ok bool) {
+	fields := strings.Split(traceparent, "-")
+	if len(fields) != 4 || len(fields[1]) != 32 || len(fields[2]) != 16 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(fields[1]); err != nil {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(fields[2]); err != nil {
+		return "", "", false
+	}
+	if strings.Trim(fields[1], "0") == "" || strings.Trim(fields[2], "0") == "" {
+		return "", "", false
+	}
+	return fields[1], fields[2], true
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type firstByteConn struct {
+	io.ReadWriteCloser
+	span  *Span
+	event string
+	once  sync.Once
+}
+
+// WatchFirstByte adds an event to span when the first byte is read from c
+func WatchFirstByte(c io.ReadWriteCloser, span *Span, event string) io.ReadWriteCloser {
+	if span == nil {
+		return c
+	}
+	return &firstByteConn{
+		ReadWriteCloser: c,
+		span:            span,
+		event:           event,
+	}
+}
+
+// CloseWrite keeps half-close of the wrapped connection available
+func (c *firstByteConn) CloseWrite() error {
+	if cw, ok := c.ReadWriteCloser.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("half-close is not supported")
+}
+
+func (c *firstByteConn) Read(p []byte) (n int, err error) {
+	n, err = c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.once.Do(func() {
+			c.span.AddEvent(c.event)
+		})
+	}
+	return
+}
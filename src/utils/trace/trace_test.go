@@ -0,0 +1,127 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceparent(t *testing.T) {
+	assert := assert.New(t)
+	tracer := NewTracer(&OtlpExporter{}, nil)
+
+	root := tracer.Start("root", "")
+	assert.Len(root.TraceId, 32)
+	assert.Len(root.SpanId, 16)
+	assert.Equal("", root.ParentId)
+
+	child := tracer.Start("child", root.Traceparent())
+	assert.Equal(root.TraceId, child.TraceId)
+	assert.Equal(root.SpanId, child.ParentId)
+	assert.Equal(root.TraceId, root.Child("child").TraceId)
+
+	// invalid traceparent starts a new trace
+	for _, tp := range []string{"abc", "00-xyz-123-01", "00-00000000000000000000000000000000-0000000000000001-01"} {
+		s := tracer.Start("s", tp)
+		assert.Equal("", s.ParentId)
+		assert.NotEqual(root.TraceId, s.TraceId)
+	}
+
+	// nil spans do nothing when tracing is disabled
+	DefaultTracer = nil
+	var s *Span = Start("disabled", "")
+	assert.Nil(s)
+	s.AddEvent("e")
+	s.SetAttribute("k", "v")
+	s.SetError(errors.New("err"))
+	s.End()
+	assert.Equal("", s.Traceparent())
+	assert.Nil(s.Child("c"))
+}
+
+func TestOtlpExporter(t *testing.T) {
+	assert := assert.New(t)
+	requests := make(chan *otlpRequest, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &otlpRequest{}
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, req) != nil {
+			w.WriteHeader(400)
+			return
+		}
+		requests <- req
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOtlpExporter(collector.URL+"/v1/traces", "frps"), nil)
+	root := tracer.Start("frps.user_conn", "")
+	root.SetAttribute("proxy_name", "web")
+	root.AddEvent("work_conn_ready")
+
+	// first byte is recorded once
+	c1, c2 := net.Pipe()
+	go c1.Write([]byte("ab"))
+	rwc := WatchFirstByte(c2, root, "first_byte")
+	buf := make([]byte, 1)
+	rwc.Read(buf)
+	rwc.Read(buf)
+	c1.Close()
+	c2.Close()
+
+	child := root.Child("frpc.user_conn")
+	child.SetError(errors.New("connect to local port error"))
+	child.End()
+	root.End()
+	root.End()
+	tracer.Flush()
+
+	req := <-requests
+	if !assert.Len(req.ResourceSpans, 1) || !assert.Len(req.ResourceSpans[0].ScopeSpans, 1) {
+		return
+	}
+	assert.Equal("frps", req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if !assert.Len(spans, 2) {
+		return
+	}
+	assert.Equal("frpc.user_conn", spans[0].Name)
+	assert.Equal(root.SpanId, spans[0].ParentSpanId)
+	assert.Equal(otlpStatusError, spans[0].Status.Code)
+	assert.Equal("frps.user_conn", spans[1].Name)
+	assert.Equal(root.TraceId, spans[1].TraceId)
+	assert.Equal(otlpStatusOk, spans[1].Status.Code)
+	assert.Equal([]otlpAttribute{{Key: "proxy_name", Value: otlpValue{StringValue: "web"}}}, spans[1].Attributes)
+	if assert.Len(spans[1].Events, 2) {
+		assert.Equal("work_conn_ready", spans[1].Events[0].Name)
+		assert.Equal("first_byte", spans[1].Events[1].Name)
+	}
+
+	// errors of exporting are reported
+	errs := make(chan error, 1)
+	tracer = NewTracer(NewOtlpExporter(collector.URL+"/wrong", "frps"), func(err error) {
+		errs <- err
+	})
+	tracer.Start("s", "").End()
+	tracer.Flush()
+	assert.Error(<-errs)
+}
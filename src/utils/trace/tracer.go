@@ -0,0 +1,110 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"time"
+)
+
+const (
+	maxQueuedSpans = 4096
+	maxBatchSpans  = 256
+	exportInterval = 2 * time.Second
+)
+
+// Exporter sends finished spans to a collector
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer creates spans and exports them in batches by another goroutine, spans are dropped if the queue is full
+type Tracer struct {
+	exporter Exporter
+	onError  func(error) // called when exporting failed if it's not nil
+	spans    chan *Span
+	flush    chan chan struct{}
+}
+
+func NewTracer(exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		spans:    make(chan *Span, maxQueuedSpans),
+		flush:    make(chan chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) Start(name string, parent string) *Span {
+	s := &Span{
+		SpanId:    randomHex(8),
+		Name:      name,
+		StartTime: time.Now(),
+		tracer:    t,
+	}
+	if traceId, spanId, ok := parseTraceparent(parent); ok {
+		s.TraceId, s.ParentId = traceId, spanId
+	} else {
+		s.TraceId = randomHex(16)
+	}
+	return s
+}
+
+// Flush exports all finished spans and waits until they are sent
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	t.flush <- done
+	<-done
+}
+
+func (t *Tracer) export(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, maxBatchSpans)
+	send := func() {
+		if len(batch) > 0 {
+			if err := t.exporter.Export(batch); err != nil && t.onError != nil {
+				t.onError(err)
+			}
+			batch = make([]*Span, 0, maxBatchSpans)
+		}
+	}
+
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSpans {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-t.flush:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			send()
+			close(done)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verify checks the ownership of domains claimed by clients.
+// The token of a domain is derived from a secret, the client identity and the domain,
+// so it never changes and the owner can publish it once:
+//
+//	dns:  TXT record of _frp-challenge.<domain> contains the token
+//	http: http://<domain>/.well-known/frp-challenge/<token> responds the token
+//
+// The identity is derived from a secret of the client by Identity, so the secret isn't sent to the server,
+// tokens are public and other clients can't get the same ones.
+// The http challenge is served by the current web server of the domain, it can't be served through frps
+// before the domain is verified, so dns is used if the domain is already pointed to frps.
+// Domains which aren't valid host names or resolve to private addresses are never requested.
+package verify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MethodDns  = "dns"
+	MethodHttp = "http"
+
+	DnsPrefix = "_frp-challenge."
+	HttpPath  = "/.well-known/frp-challenge/"
+)
+
+type DomainVerifier struct {
+	Methods []string
+	Ttl     time.Duration
+
+	// they can be replaced in tests
+	LookupTXT func(name string) ([]string, error)
+	HttpGet   func(url string) (*http.Response, error)
+	AllowIP   func(ip net.IP) bool // addresses which http challenges can be requested from
+
+	secret []byte
+	cache  map[string]time.Time // token => expiration time
+	mutex  sync.Mutex
+}
+
+func NewDomainVerifier(secret string, methods []string, ttl time.Duration) (*DomainVerifier, error) {
+	for _, method := range methods {
+		if method != MethodDns && method != MethodHttp {
+			return nil, fmt.Errorf("unsupported verification method [%s]", method)
+		}
+	}
+	v := &DomainVerifier{
+		Methods:   methods,
+		Ttl:       ttl,
+		LookupTXT: net.LookupTXT,
+		AllowIP:   IsPublicIP,
+		secret:    []byte(secret),
+		cache:     make(map[string]time.Time),
+	}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		// environment proxies aren't used, addresses are checked by dial
+		Transport: &http.Transport{DialContext: v.dial},
+		// the response of the domain itself is checked, redirections may lead to other hosts
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	v.HttpGet = client.Get
+	return v, nil
+}
+
+// Identity is sent by the client instead of the secret, it doesn't change so tokens are kept
+func Identity(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("frp domain identity"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dial connects to the resolved address after all addresses of the host are allowed,
+// so the host can't be resolved to another address between the check and the connection
+func (v *DomainVerifier) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address of [%s]", host)
+	}
+	for _, a := range addrs {
+		if !v.AllowIP(a.IP) {
+			return nil, fmt.Errorf("address [%s] of [%s] is not allowed", a.IP, host)
+		}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, s := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(s)
+		privateNets = append(privateNets, n)
+	}
+}
+
+// IsPublicIP returns false for loopback, link-local, private, shared and multicast addresses
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHostName returns an error if domain isn't a host name like "www.example.com", IP addresses and ports aren't allowed
+func checkHostName(domain string) error {
+	if len(domain) == 0 || len(domain) > 253 || net.ParseIP(domain) != nil {
+		return fmt.Errorf("[%s] is not a valid host name", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("[%s] is not a valid host name", domain)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("[%s] is not a valid host name", domain)
+			}
+		}
+	}
+	return nil
+}
+
+func (v *DomainVerifier) Token(identity string, domain string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(identity + "\n" + domain))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Verify returns nil if the domain is verified by any method,
+// results are cached for Ttl and failures are never cached
+func (v *DomainVerifier) Verify(identity string, domain string) error {
+	token := v.Token(identity, domain)
+	v.mutex.Lock()
+	expire, ok := v.cache[token]
+	v.mutex.Unlock()
+	if ok && time.Now().Before(expire) {
+		return nil
+	}
+
+	for _, method := range v.Methods {
+		var verified bool
+		switch method {
+		case MethodDns:
+			verified = v.verifyDns(domain, token)
+		case MethodHttp:
+			verified = v.verifyHttp(domain, token)
+		}
+		if verified {
+			v.mutex.Lock()
+			v.cache[token] = time.Now().Add(v.Ttl)
+			v.mutex.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("custom domain [%s] is not verified, %s", domain, v.instructions(domain, token))
+}
+
+func (v *DomainVerifier) instructions(domain string, token string) string {
+	ways := make([]string, 0, len(v.Methods))
+	for _, method := range v.Methods {
+		switch method {
+		case MethodDns:
+			ways = append(ways, fmt.Sprintf("add TXT record [%s] to [%s%s]", token, DnsPrefix, domain))
+		case MethodHttp:
+			ways = append(ways, fmt.Sprintf("serve [%s] at [http://%s%s%s] by the web server of the domain", token, domain, HttpPath, token))
+		}
+	}
+	return strings.Join(ways, " or ")
+}
+
+func (v *DomainVerifier) verifyDns(domain string, token string) bool {
+	records, err := v.LookupTXT(DnsPrefix + domain)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *DomainVerifier) verifyHttp(domain string, token string) bool {
+	if checkHostName(domain) != nil {
+		return false
+	}
+	res, err := v.HttpGet("http://" + domain + HttpPath + token)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(body)) == token
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyDns(t *testing.T) {
+	assert := assert.New(t)
+	v, err := NewDomainVerifier("secret", []string{MethodDns}, time.Hour)
+	assert.NoError(err)
+
+	records := make(map[string][]string)
+	lookups := 0
+	v.LookupTXT = func(name string) ([]string, error) {
+		lookups++
+		if r, ok := records[name]; ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("no such host")
+	}
+
+	err = v.Verify("web", "a.example.com")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "_frp-challenge.a.example.com")
+		assert.Contains(err.Error(), v.Token("web", "a.example.com"))
+	}
+
+	records["_frp-challenge.a.example.com"] = []string{"other", v.Token("web", "a.example.com")}
+	assert.NoError(v.Verify("web", "a.example.com"))
+	// token is bound to client identity
+	assert.Error(v.Verify("ssh", "a.example.com"))
+	assert.NotEqual(v.Token("web", "a.example.com"), v.Token("ssh", "a.example.com"))
+
+	// cached
+	delete(records, "_frp-challenge.a.example.com")
+	lookups = 0
+	assert.NoError(v.Verify("web", "a.example.com"))
+	assert.Equal(0, lookups)
+
+	// expired
+	v.Ttl = 0
+	v.cache = make(map[string]time.Time)
+	records["_frp-challenge.a.example.com"] = []string{v.Token("web", "a.example.com")}
+	assert.NoError(v.Verify("web", "a.example.com"))
+	delete(records, "_frp-challenge.a.example.com")
+	assert.Error(v.Verify("web", "a.example.com"))
+}
+
+func TestVerifyHttp(t *testing.T) {
+	assert := assert.New(t)
+	v, err := NewDomainVerifier("secret", []string{MethodDns, MethodHttp}, time.Hour)
+	assert.NoError(err)
+	v.LookupTXT = func(name string) ([]string, error) {
+		return nil, fmt.Errorf("no such host")
+	}
+
+	token := v.Token("web", "b.example.com")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "b.example.com" && r.URL.Path == HttpPath+token {
+			fmt.Fprintln(w, token)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	// send requests of all domains to the test server
+	v.HttpGet = func(url string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.Host = strings.TrimPrefix(ts.URL, "http://")
+		return http.DefaultClient.Do(req)
+	}
+
+	assert.NoError(v.Verify("web", "b.example.com"))
+	err = v.Verify("web", "c.example.com")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), " or ")
+	}
+
+	_, err = NewDomainVerifier("secret", []string{"smtp"}, time.Hour)
+	assert.Error(err)
+}
+
+// requests of the domain are sent to the test server by the http client of the verifier
+func redirectToServer(v *DomainVerifier, domain string, ts *httptest.Server) {
+	get := v.HttpGet
+	v.HttpGet = func(url string) (*http.Response, error) {
+		return get(strings.Replace(url, domain, strings.TrimPrefix(ts.URL, "http://"), 1))
+	}
+}
+
+func TestVerifyHttpRedirect(t *testing.T) {
+	assert := assert.New(t)
+	v, err := NewDomainVerifier("secret", []string{MethodHttp}, time.Hour)
+	assert.NoError(err)
+	v.AllowIP = func(ip net.IP) bool { return true }
+
+	token := v.Token("web", "d.example.com")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			fmt.Fprintln(w, token)
+			return
+		}
+		http.Redirect(w, r, "/token", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	redirectToServer(v, "d.example.com", ts)
+	assert.Error(v.Verify("web", "d.example.com"))
+}
+
+func TestVerifyHttpPrivateAddress(t *testing.T) {
+	assert := assert.New(t)
+	v, err := NewDomainVerifier("secret", []string{MethodHttp}, time.Hour)
+	assert.NoError(err)
+
+	token := v.Token("web", "e.example.com")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, token)
+	}))
+	defer ts.Close()
+
+	// the test server is on a loopback address
+	redirectToServer(v, "e.example.com", ts)
+	assert.Error(v.Verify("web", "e.example.com"))
+	v.AllowIP = func(ip net.IP) bool { return true }
+	assert.NoError(v.Verify("web", "e.example.com"))
+
+	// ip addresses and ports are never requested
+	requested := false
+	v.HttpGet = func(url string) (*http.Response, error) {
+		requested = true
+		return nil, fmt.Errorf("unexpected request")
+	}
+	for _, domain := range []string{"127.0.0.1", "[::1]", "169.254.169.254", "example.com:8080", "a..b", "-a.example.com", "a_b.example.com"} {
+		assert.Error(v.Verify("web", domain), domain)
+	}
+	assert.False(requested)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	assert := assert.New(t)
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "224.0.0.1"} {
+		assert.False(IsPublicIP(net.ParseIP(s)), s)
+	}
+	for _, s := range []string{"8.8.8.8", "172.32.0.1", "2001:4860:4860::8888"} {
+		assert.True(IsPublicIP(net.ParseIP(s)), s)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(Identity("alice"), Identity("alice"))
+	assert.NotEqual(Identity("alice"), Identity("bob"))
+	assert.NotContains(Identity("alice"), "alice")
+}
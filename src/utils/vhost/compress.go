@@ -0,0 +1,334 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+)
+
+// max time to wait for writing buffered responses when closing
+const compressCloseTimeout = 10 * time.Second
+
+var DefaultCompressionTypes = []string{"text/*", "application/javascript", "application/json", "application/xml", "image/svg+xml"}
+
+// HttpCompression compresses responses for users who send Accept-Encoding,
+// responses which are encoded already, too small or not in ContentTypes are passed through
+type HttpCompression struct {
+	Encodings    []string // in order of preference
+	MinSize      int64    // responses without Content-Length are always compressed
+	ContentTypes []string // type/* matches all subtypes
+}
+
+func CheckEncodings(encodings []string) error {
+	for _, encoding := range encodings {
+		if encoding != EncodingGzip && encoding != EncodingBrotli {
+			return fmt.Errorf("unsupported compression encoding [%s]", encoding)
+		}
+	}
+	return nil
+}
+
+// choose the first encoding in hc.Encodings which is accepted by header Accept-Encoding
+func (hc *HttpCompression) chooseEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		accepted[name] = q > 0
+	}
+
+	for _, encoding := range hc.Encodings {
+		if ok, exist := accepted[encoding]; exist {
+			if ok {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+func (hc *HttpCompression) matchContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range hc.ContentTypes {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(mediaType, t[:len(t)-1]) {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (hc *HttpCompression) compressible(res *http.Response) bool {
+	if res.StatusCode < 200 || res.StatusCode == http.StatusNoContent ||
+		res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if res.Request.Method == "HEAD" {
+		return false
+	}
+	if ce := res.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return false
+	}
+	if res.Header.Get("Content-Range") != "" ||
+		strings.Contains(strings.ToLower(res.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	if res.ContentLength >= 0 && res.ContentLength < hc.MinSize {
+		return false
+	}
+	return hc.matchContentType(res.Header.Get("Content-Type"))
+}
+
+type compressRequest struct {
+	method   string
+	encoding string // empty if the response should not be compressed
+}
+
+// compressConn reads requests from users to know encodings they accept,
+// responses written to it are parsed in another goroutine and compressed if possible,
+// parsing stops on errors or protocol switching and the left bytes are passed through
+type compressConn struct {
+	net.Conn
+	hc        *HttpCompression
+	reqWriter *io.PipeWriter
+	resWriter *io.PipeWriter
+	pending   chan *compressRequest
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newCompressConn(c net.Conn, hc *HttpCompression) net.Conn {
+	reqReader, reqWriter := io.Pipe()
+	resReader, resWriter := io.Pipe()
+	cc := &compressConn{
+		Conn:      c,
+		hc:        hc,
+		reqWriter: reqWriter,
+		resWriter: resWriter,
+		pending:   make(chan *compressRequest, maxPendingRequests),
+		done:      make(chan struct{}),
+	}
+	go cc.readRequests(reqReader)
+	go cc.writeResponses(resReader)
+	return cc
+}
+
+func (cc *compressConn) readRequests(pr *io.PipeReader) {
+	defer close(cc.pending)
+	rd := bufio.NewReader(pr)
+	for {
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			pr.CloseWithError(err)
+			return
+		}
+		cr := &compressRequest{
+			method: req.Method,
+		}
+		// chunked encoding is required by compressed responses
+		if req.ProtoAtLeast(1, 1) {
+			cr.encoding = cc.hc.chooseEncoding(req.Header.Get("Accept-Encoding"))
+		}
+		select {
+		case cc.pending <- cr:
+		case <-cc.done:
+			pr.CloseWithError(io.EOF)
+			return
+		}
+
+		_, err = io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+		if err != nil || req.Header.Get("Upgrade") != "" {
+			pr.CloseWithError(io.EOF)
+			return
+		}
+	}
+}
+
+func (cc *compressConn) writeResponses(pr *io.PipeReader) {
+	defer close(cc.done)
+	rd := bufio.NewReader(pr)
+	for {
+		cr, ok := <-cc.pending
+		if !ok {
+			break
+		}
+
+		var res *http.Response
+		var err error
+		for {
+			res, err = http.ReadResponse(rd, &http.Request{Method: cr.method})
+			if err != nil {
+				pr.CloseWithError(err)
+				return
+			}
+			if res.StatusCode >= 200 || res.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+			// informational responses like 100 Continue
+			if err = res.Write(cc.Conn); err != nil {
+				pr.CloseWithError(err)
+				return
+			}
+		}
+
+		if cr.encoding != "" && cc.hc.compressible(res) {
+			err = cc.writeCompressed(res, rd, cr.encoding)
+		} else {
+			err = res.Write(cc.Conn)
+		}
+		res.Body.Close()
+		if err != nil {
+			pr.CloseWithError(err)
+			return
+		}
+		if res.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+
+	// pass through the left bytes
+	_, err := io.Copy(cc.Conn, rd)
+	pr.CloseWithError(err)
+}
+
+// body is compressed in another goroutine and written as chunks,
+// compressed data is flushed when no more bytes are buffered, so streaming responses are not delayed
+func (cc *compressConn) writeCompressed(res *http.Response, rd *bufio.Reader, encoding string) error {
+	body := res.Body
+	bodyReader, bodyWriter := io.Pipe()
+	go func() {
+		var w interface {
+			io.WriteCloser
+			Flush() error
+		}
+		if encoding == EncodingBrotli {
+			w = brotli.NewWriterLevel(bodyWriter, 5)
+		} else {
+			w = gzip.NewWriter(bodyWriter)
+		}
+
+		buf := make([]byte, 32*1024)
+		var err error
+		for {
+			var n int
+			n, err = body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					err = werr
+					break
+				}
+				if rd.Buffered() == 0 {
+					if werr := w.Flush(); werr != nil {
+						err = werr
+						break
+					}
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == io.EOF {
+			err = w.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+
+	// users accept HTTP/1.1, it's required by chunked encoding even if the backend uses HTTP/1.0
+	res.Proto = "HTTP/1.1"
+	res.ProtoMajor, res.ProtoMinor = 1, 1
+	res.Body = bodyReader
+	res.ContentLength = -1
+	res.TransferEncoding = []string{"chunked"}
+	res.Header.Del("Content-Length")
+	res.Header.Set("Content-Encoding", encoding)
+	res.Header.Add("Vary", "Accept-Encoding")
+	// the representation is changed, so a strong validator becomes weak
+	if etag := res.Header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		res.Header.Set("ETag", "W/"+etag)
+	}
+	err := res.Write(cc.Conn)
+	bodyReader.CloseWithError(io.ErrClosedPipe)
+	body.Close()
+	return err
+}
+
+// requests are passed through and copied into a pipe for parsing
+func (cc *compressConn) Read(p []byte) (n int, err error) {
+	n, err = cc.Conn.Read(p)
+	if n > 0 {
+		cc.reqWriter.Write(p[:n])
+	}
+	if err != nil {
+		cc.reqWriter.Close()
+	}
+	return
+}
+
+func (cc *compressConn) Write(p []byte) (n int, err error) {
+	return cc.resWriter.Write(p)
+}
+
+// responses accepted by Write may be not written yet, wait for them before closing
+func (cc *compressConn) Close() error {
+	cc.closeOnce.Do(func() {
+		cc.reqWriter.Close()
+		cc.resWriter.Close()
+		select {
+		case <-cc.done:
+		case <-time.After(compressCloseTimeout):
+		}
+	})
+	return cc.Conn.Close()
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	assert := assert.New(t)
+	hc := &HttpCompression{Encodings: []string{"br", "gzip"}}
+	assert.Equal("br", hc.chooseEncoding("gzip, deflate, br"))
+	assert.Equal("gzip", hc.chooseEncoding("gzip;q=0.8, br;q=0"))
+	assert.Equal("gzip", hc.chooseEncoding("GZIP"))
+	assert.Equal("br", hc.chooseEncoding("*"))
+	assert.Equal("gzip", hc.chooseEncoding("br;q=0, *"))
+	assert.Equal("", hc.chooseEncoding("deflate"))
+	assert.Equal("", hc.chooseEncoding(""))
+
+	assert.NoError(CheckEncodings([]string{"gzip", "br"}))
+	assert.Error(CheckEncodings([]string{"deflate"}))
+}
+
+// user sends requests to c1, responses from backend are written to the compressConn
+func newTestCompressConn() (user net.Conn, cc net.Conn) {
+	c1, c2 := net.Pipe()
+	cc = newCompressConn(c2, &HttpCompression{
+		Encodings:    []string{"br", "gzip"},
+		MinSize:      10,
+		ContentTypes: DefaultCompressionTypes,
+	})
+	return c1, cc
+}
+
+func TestCompressConn(t *testing.T) {
+	assert := assert.New(t)
+	user, cc := newTestCompressConn()
+	defer cc.Close()
+
+	// requests are passed through
+	requests := "GET /a HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"GET /b HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip, br\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"GET /d HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"HEAD /e HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"GET /f HTTP/1.1\r\nHost: a\r\n\r\n" +
+		"GET /g HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n"
+	go user.Write([]byte(requests))
+	buf := make([]byte, len(requests))
+	_, err := io.ReadFull(cc, buf)
+	assert.NoError(err)
+	assert.Equal(requests, string(buf))
+
+	text := strings.Repeat("hello frp ", 100)
+	responses := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nETag: \"v1\"\r\n\r\n%s", len(text), text) +
+		fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(text), text) +
+		"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nsmall" +
+		fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: %d\r\n\r\n%s", len(text), text) +
+		fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n", len(text)) +
+		fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n%s", len(text), text) +
+		fmt.Sprintf("HTTP/1.0 200 OK\r\nContent-Type: text/html\r\n\r\n%s", text)
+	// the last response ends when backend closes the connection, buffered bytes are written before closing
+	go func() {
+		cc.Write([]byte(responses))
+		cc.Close()
+	}()
+
+	rd := bufio.NewReader(user)
+	readResponse := func(method string) (*http.Response, string) {
+		res, err := http.ReadResponse(rd, &http.Request{Method: method})
+		if !assert.NoError(err) {
+			return nil, ""
+		}
+		var body io.Reader = res.Body
+		switch res.Header.Get("Content-Encoding") {
+		case "gzip":
+			body, err = gzip.NewReader(res.Body)
+			assert.NoError(err)
+		case "br":
+			body = brotli.NewReader(res.Body)
+		}
+		content, err := ioutil.ReadAll(body)
+		assert.NoError(err)
+		return res, string(content)
+	}
+
+	// gzip
+	res, content := readResponse("GET")
+	assert.Equal("gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(int64(-1), res.ContentLength)
+	assert.Equal("Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(`W/"v1"`, res.Header.Get("ETag"))
+	assert.Equal(text, content)
+
+	// brotli is preferred
+	res, content = readResponse("GET")
+	assert.Equal("br", res.Header.Get("Content-Encoding"))
+	assert.Equal(text, content)
+
+	// too small
+	res, content = readResponse("GET")
+	assert.Equal("", res.Header.Get("Content-Encoding"))
+	assert.Equal("small", content)
+
+	// content type is not matched
+	res, content = readResponse("GET")
+	assert.Equal("", res.Header.Get("Content-Encoding"))
+	assert.Equal(int64(len(text)), res.ContentLength)
+	assert.Equal(text, content)
+
+	// no body for HEAD
+	res, _ = readResponse("HEAD")
+	assert.Equal("", res.Header.Get("Content-Encoding"))
+
+	// no Accept-Encoding
+	res, content = readResponse("GET")
+	assert.Equal("", res.Header.Get("Content-Encoding"))
+	assert.Equal(text, content)
+
+	// responses of HTTP/1.0 backends are compressed as HTTP/1.1
+	res, content = readResponse("GET")
+	assert.Equal("gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(1, res.ProtoMinor)
+	assert.Equal(text, content)
+}
+
+func TestCompressConnStreaming(t *testing.T) {
+	assert := assert.New(t)
+	user, cc := newTestCompressConn()
+	defer cc.Close()
+
+	request := "GET /events HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n"
+	go user.Write([]byte(request))
+	_, err := io.ReadFull(cc, make([]byte, len(request)))
+	assert.NoError(err)
+
+	go cc.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(user), &http.Request{Method: "GET"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("gzip", res.Header.Get("Content-Encoding"))
+
+	// every event is readable before the response is finished
+	events := make(chan string)
+	go func() {
+		gr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			close(events)
+			return
+		}
+		rd := bufio.NewReader(gr)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- line
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		event := fmt.Sprintf("data: %d\n", i)
+		go cc.Write([]byte(fmt.Sprintf("%x\r\n%s\r\n", len(event), event)))
+		assert.Equal(event, <-events)
+	}
+	go cc.Write([]byte("0\r\n\r\n"))
+	_, ok := <-events
+	assert.False(ok)
+}
+
+func TestCompressConnUpgrade(t *testing.T) {
+	assert := assert.New(t)
+	user, cc := newTestCompressConn()
+	defer cc.Close()
+
+	request := "GET /ws HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nAccept-Encoding: gzip\r\n\r\n"
+	go user.Write([]byte(request))
+	_, err := io.ReadFull(cc, make([]byte, len(request)))
+	assert.NoError(err)
+
+	// bytes after 101 response are passed through
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	go cc.Write([]byte(response + "raw bytes"))
+	rd := bufio.NewReader(user)
+	res, err := http.ReadResponse(rd, &http.Request{Method: "GET"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(http.StatusSwitchingProtocols, res.StatusCode)
+	buf := make([]byte, 9)
+	_, err = io.ReadFull(rd, buf)
+	assert.NoError(err)
+	assert.Equal("raw bytes", string(buf))
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// requestFilter is called for every http request read from users before it is passed to backend,
+// it can modify the request, or return a response which is sent to user directly and the request is dropped
+type requestFilter func(req *http.Request) *http.Response
+
+// filterConn parses http requests read from the connection and passes them to requestFilter
+type filterConn struct {
+	net.Conn
+	pr         *io.PipeReader
+	writeMutex sync.Mutex
+}
+
+func newFilterConn(c net.Conn, filter requestFilter) net.Conn {
+	pr, pw := io.Pipe()
+	fc := &filterConn{
+		Conn: c,
+		pr:   pr,
+	}
+
+	go func() {
+		rd := bufio.NewReader(c)
+		for {
+			req, err := http.ReadRequest(rd)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if res := filter(req); res != nil {
+				req.Body.Close()
+				fc.writeMutex.Lock()
+				err = res.Write(c)
+				fc.writeMutex.Unlock()
+				if err != nil || req.Close {
+					pw.CloseWithError(io.EOF)
+					c.Close()
+					return
+				}
+				continue
+			}
+
+			// don't let Request.Write add a default User-Agent
+			if _, ok := req.Header["User-Agent"]; !ok {
+				req.Header["User-Agent"] = []string{""}
+			}
+			err = req.Write(pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			// after protocol switching, the left bytes are not http requests
+			if req.Header.Get("Upgrade") != "" {
+				_, err = io.Copy(pw, rd)
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return fc
+}
+
+// headers with the same names sent by users are removed, so backends can trust them
+func newHeaderConn(c net.Conn, headers map[string]string) net.Conn {
+	return newFilterConn(c, func(req *http.Request) *http.Response {
+		for k, v := range headers {
+			req.Header.Del(k)
+			req.Header.Set(k, v)
+		}
+		return nil
+	})
+}
+
+func (fc *filterConn) Read(p []byte) (n int, err error) {
+	return fc.pr.Read(p)
+}
+
+func (fc *filterConn) Write(p []byte) (n int, err error) {
+	fc.writeMutex.Lock()
+	defer fc.writeMutex.Unlock()
+	return fc.Conn.Write(p)
+}
+
+func (fc *filterConn) Close() error {
+	fc.pr.Close()
+	return fc.Conn.Close()
+}
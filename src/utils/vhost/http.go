@@ -34,6 +34,13 @@ type HttpMuxer struct {
 	*VhostMuxer
 }
 
+// HttpAuthenticator checks every http request before it is passed to backend
+type HttpAuthenticator interface {
+	// Authenticate returns headers passed to backend if the request is allowed,
+	// otherwise a response sent to user directly, such as a redirection to login page
+	Authenticate(req *http.Request) (headers map[string]string, res *http.Response)
+}
+
 func GetHttpRequestInfo(c *conn.Conn) (_ net.Conn, _ map[string]string, err error) {
 	reqInfoMap := make(map[string]string, 0)
 	sc, rd := newShareConn(c.TcpConn)
@@ -57,6 +64,11 @@ func GetHttpRequestInfo(c *conn.Conn) (_ net.Conn, _ map[string]string, err erro
 
 func NewHttpMuxer(listener *conn.Listener, timeout time.Duration) (*HttpMuxer, error) {
 	mux, err := NewVhostMuxer(listener, GetHttpRequestInfo, HttpAuthFunc, HttpHostNameRewrite, timeout)
+	if mux != nil {
+		mux.mutex.Lock()
+		mux.plainHttp = true
+		mux.mutex.Unlock()
+	}
 	return &HttpMuxer{mux}, err
 }
 
@@ -200,6 +212,37 @@ func HttpAuthFunc(c *conn.Conn, userName, passWord, authorization string) (bAcce
 	return true, nil
 }
 
+// max size of the first request header read by httpAuthenticate
+const maxAuthHeaderSize = 64 * 1024
+
+// the first request is checked before the connection is accepted by listener,
+// so unauthenticated users won't cause any work connections,
+// only its header is read and kept in memory, at most maxAuthHeaderSize bytes
+func httpAuthenticate(c net.Conn, authenticator HttpAuthenticator) (_ net.Conn, err error) {
+	sc, rd := newShareConn(c)
+	req, err := http.ReadRequest(bufio.NewReader(io.LimitReader(rd, maxAuthHeaderSize)))
+	if err != nil {
+		return nil, err
+	}
+	if _, res := authenticator.Authenticate(req); res != nil {
+		res.Close = true
+		res.Write(c)
+		return nil, fmt.Errorf("http request is not authenticated")
+	}
+
+	return newFilterConn(sc, func(req *http.Request) *http.Response {
+		headers, res := authenticator.Authenticate(req)
+		if res != nil {
+			return res
+		}
+		for k, v := range headers {
+			req.Header.Del(k)
+			req.Header.Set(k, v)
+		}
+		return nil
+	}), nil
+}
+
 func noAuthResponse() *http.Response {
 	header := make(map[string][]string)
 	header["WWW-Authenticate"] = []string{`Basic realm="Restricted"`}
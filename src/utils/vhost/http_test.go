@@ -0,0 +1,86 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAuthenticator struct{}
+
+func (a *testAuthenticator) Authenticate(req *http.Request) (map[string]string, *http.Response) {
+	if req.Header.Get("Token") != "ok" {
+		res := noAuthResponse()
+		res.Header.Set("Content-Length", "0")
+		return nil, res
+	}
+	return map[string]string{"X-User": "alice"}, nil
+}
+
+func TestHttpAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+	user, c := net.Pipe()
+	defer user.Close()
+
+	// the body isn't read before the user is authenticated, it's sent after that
+	go user.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nToken: ok\r\nContent-Length: 5\r\n\r\n"))
+	ac, err := httpAuthenticate(c, &testAuthenticator{})
+	if !assert.NoError(err) {
+		return
+	}
+	defer ac.Close()
+	go user.Write([]byte("hello"))
+
+	rd := bufio.NewReader(ac)
+	req, err := http.ReadRequest(rd)
+	if assert.NoError(err) {
+		assert.Equal("alice", req.Header.Get("X-User"))
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(err)
+		assert.Equal("hello", string(body))
+	}
+}
+
+func TestHttpAuthenticateDenied(t *testing.T) {
+	assert := assert.New(t)
+	user, c := net.Pipe()
+	defer user.Close()
+
+	go user.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := httpAuthenticate(c, &testAuthenticator{})
+		errs <- err
+	}()
+	res, err := http.ReadResponse(bufio.NewReader(user), nil)
+	if assert.NoError(err) {
+		assert.Equal(401, res.StatusCode)
+	}
+	assert.Error(<-errs)
+
+	// header is too large
+	user2, c2 := net.Pipe()
+	defer user2.Close()
+	go user2.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\nX: " + strings.Repeat("a", maxAuthHeaderSize) + "\r\n\r\n"))
+	_, err = httpAuthenticate(c2, &testAuthenticator{})
+	assert.Error(err)
+}
@@ -15,9 +15,12 @@
 package vhost
 
 import (
+	"crypto/tls"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -43,6 +46,9 @@ const (
 	extensionRenegotiationInfo   uint16 = 0xff01
 )
 
+// subject of the verified client certificate is passed to backend in this header
+const ClientCertSubjectHeader = "X-Client-Cert-Subject"
+
 type HttpsMuxer struct {
 	*VhostMuxer
 }
@@ -188,3 +194,67 @@ func GetHttpsHostname(c *conn.Conn) (sc net.Conn, _ map[string]string, err error
 	reqInfoMap["Host"] = host
 	return sc, reqInfoMap, nil
 }
+
+// terminate TLS in frps, if client certificates are verified by tlsConfig,
+// users without a certificate get 403 and the verified subject is passed to backend
+func tlsHandshake(c net.Conn, tlsConfig *tls.Config) (_ net.Conn, err error) {
+	tlsConn := tls.Server(c, tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if tlsConfig.ClientCAs == nil {
+		return tlsConn, nil
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		res := forbiddenResponse()
+		res.Write(tlsConn)
+		return nil, fmt.Errorf("no verified client certificate")
+	}
+	headers := map[string]string{
+		ClientCertSubjectHeader: formatSubject(state.VerifiedChains[0][0].Subject),
+	}
+	return newHeaderConn(tlsConn, headers), nil
+}
+
+var subjectReplacer = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `+`, `\+`, `"`, `\"`, `<`, `\<`, `>`, `\>`, `;`, `\;`)
+
+// formatSubject returns the subject in RFC 2253 style like "CN=alice,O=example",
+// pkix.Name.String is not available before go1.10
+func formatSubject(name pkix.Name) string {
+	parts := make([]string, 0)
+	add := func(key string, values ...string) {
+		for _, v := range values {
+			parts = append(parts, key+"="+subjectReplacer.Replace(v))
+		}
+	}
+	if name.CommonName != "" {
+		add("CN", name.CommonName)
+	}
+	if name.SerialNumber != "" {
+		add("SERIALNUMBER", name.SerialNumber)
+	}
+	add("POSTALCODE", name.PostalCode...)
+	add("STREET", name.StreetAddress...)
+	add("L", name.Locality...)
+	add("ST", name.Province...)
+	add("OU", name.OrganizationalUnit...)
+	add("O", name.Organization...)
+	add("C", name.Country...)
+	return strings.Join(parts, ",")
+}
+
+func forbiddenResponse() *http.Response {
+	header := make(map[string][]string)
+	header["Content-Length"] = []string{"0"}
+	res := &http.Response{
+		Status:     "403 Forbidden",
+		StatusCode: 403,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+	}
+	return res
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a CA if parent is nil, otherwise a leaf certificate signed by parent
+func newTestCert(t *testing.T, subject pkix.Name, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer := &testCert{cert: tmpl, key: key}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// tlsHandshake is run by the server side of a tcp connection, the client side is returned as the user,
+// newClientCert returns the certificate of the user by the trusted CA, or nil
+func startTestTls(t *testing.T, newClientCert func(ca *testCert) *testCert) (user *tls.Conn, result chan net.Conn, errs chan error) {
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+	server := newTestCert(t, pkix.Name{CommonName: "example.com"}, ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCert()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	result = make(chan net.Conn, 1)
+	errs = make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		tc, err := tlsHandshake(c, serverConfig)
+		if err != nil {
+			c.Close()
+			errs <- err
+			return
+		}
+		result <- tc
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig := &tls.Config{RootCAs: pool, ServerName: "example.com"}
+	if clientCert := newClientCert(ca); clientCert != nil {
+		// send it even if it isn't signed by CAs accepted by the server
+		cert := clientCert.tlsCert()
+		clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	}
+	return tls.Client(c, clientConfig), result, errs
+}
+
+func TestTlsHandshakeNoClientCert(t *testing.T) {
+	assert := assert.New(t)
+	user, _, errs := startTestTls(t, func(ca *testCert) *testCert { return nil })
+	defer user.Close()
+
+	res, err := http.ReadResponse(bufio.NewReader(user), nil)
+	if assert.NoError(err) {
+		assert.Equal(403, res.StatusCode)
+	}
+	assert.Error(<-errs)
+}
+
+func TestTlsHandshakeUntrustedClientCert(t *testing.T) {
+	assert := assert.New(t)
+	user, _, errs := startTestTls(t, func(ca *testCert) *testCert {
+		other := newTestCert(t, pkix.Name{CommonName: "other ca"}, nil)
+		return newTestCert(t, pkix.Name{CommonName: "mallory"}, other)
+	})
+	defer user.Close()
+
+	// the server may reject the certificate after the client finished its part of the handshake
+	if err := user.Handshake(); err == nil {
+		_, err = user.Read(make([]byte, 1))
+		assert.Error(err)
+	}
+	assert.Error(<-errs)
+}
+
+func TestTlsHandshakeClientSubject(t *testing.T) {
+	assert := assert.New(t)
+	user, result, errs := startTestTls(t, func(ca *testCert) *testCert {
+		return newTestCert(t, pkix.Name{CommonName: "alice", Organization: []string{"example, inc"}}, ca)
+	})
+	defer user.Close()
+
+	// the header sent by the user is replaced
+	go user.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n" + ClientCertSubjectHeader + ": CN=admin\r\n\r\n"))
+	var c net.Conn
+	select {
+	case c = <-result:
+	case err := <-errs:
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if assert.NoError(err) {
+		assert.Equal([]string{`CN=alice,O=example\, inc`}, req.Header[ClientCertSubjectHeader])
+	}
+}
+
+func TestFormatSubject(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(`CN=bob,OU=dev,O=a\+b,C=US`, formatSubject(pkix.Name{
+		CommonName:         "bob",
+		OrganizationalUnit: []string{"dev"},
+		Organization:       []string{"a+b"},
+		Country:            []string{"US"},
+	}))
+	assert.Equal("", formatSubject(pkix.Name{}))
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// StaticResponder answers http requests in frps, with a redirect if Redirect is set or the fixed response
+type StaticResponder struct {
+	Redirect         string
+	RedirectCode     int
+	RedirectKeepPath bool // path and query of requests are appended to Redirect
+
+	Path        string // if it is not empty, requests for other paths get 404
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// Serve answers requests read from c until the connection is closed
+func (s *StaticResponder) Serve(c io.ReadWriteCloser) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	for {
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+		if err != nil {
+			return
+		}
+
+		res := s.response(req)
+		if err = res.Write(c); err != nil || res.Close {
+			return
+		}
+	}
+}
+
+func (s *StaticResponder) response(req *http.Request) *http.Response {
+	var (
+		code        int
+		contentType string
+		body        []byte
+	)
+	header := make(http.Header)
+	switch {
+	case s.Redirect != "":
+		code = s.RedirectCode
+		location := s.Redirect
+		if s.RedirectKeepPath {
+			location = strings.TrimSuffix(location, "/") + req.URL.RequestURI()
+		}
+		header.Set("Location", location)
+	case s.Path != "" && req.URL.Path != s.Path:
+		code = http.StatusNotFound
+		contentType = "text/plain; charset=utf-8"
+		body = []byte("404 page not found\n")
+	default:
+		code = s.StatusCode
+		contentType = s.ContentType
+		body = s.Body
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         req.Close,
+		Request:       req,
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticResponder(t *testing.T) {
+	assert := assert.New(t)
+	serve := func(s *StaticResponder, requests string) []*http.Response {
+		user, c := net.Pipe()
+		go s.Serve(c)
+		go user.Write([]byte(requests))
+		defer user.Close()
+
+		responses := make([]*http.Response, 0)
+		rd := bufio.NewReader(user)
+		for {
+			res, err := http.ReadResponse(rd, nil)
+			if err != nil {
+				return responses
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body = ioutil.NopCloser(nil)
+			res.Header.Set("X-Body", string(body))
+			responses = append(responses, res)
+			if res.Close {
+				return responses
+			}
+		}
+	}
+
+	// redirect with path and query kept
+	s := &StaticResponder{
+		Redirect:         "https://new.example.com/",
+		RedirectCode:     301,
+		RedirectKeepPath: true,
+	}
+	responses := serve(s, "GET /a/b?c=d HTTP/1.1\r\nHost: old.example.com\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: old.example.com\r\nConnection: close\r\n\r\n")
+	if assert.Len(responses, 2) {
+		assert.Equal(301, responses[0].StatusCode)
+		assert.Equal("https://new.example.com/a/b?c=d", responses[0].Header.Get("Location"))
+		assert.Equal("https://new.example.com/", responses[1].Header.Get("Location"))
+	}
+
+	// fixed response for one path
+	s = &StaticResponder{
+		Path:        "/robots.txt",
+		StatusCode:  200,
+		ContentType: "text/plain",
+		Body:        []byte("User-agent: *\nDisallow: /\n"),
+	}
+	responses = serve(s, "GET /robots.txt HTTP/1.1\r\nHost: a\r\n\r\n"+
+		"POST /robots.txt HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET /other HTTP/1.0\r\nHost: a\r\n\r\n")
+	if assert.Len(responses, 3) {
+		assert.Equal(200, responses[0].StatusCode)
+		assert.Equal("text/plain", responses[0].Header.Get("Content-Type"))
+		assert.Equal("User-agent: *\nDisallow: /\n", responses[0].Header.Get("X-Body"))
+		assert.Equal(200, responses[1].StatusCode)
+		assert.Equal(404, responses[2].StatusCode)
+	}
+}
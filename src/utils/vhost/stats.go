@@ -0,0 +1,180 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HttpStatsFunc is called once for every http request which got a final response,
+// latency is the time between receiving the request header and receiving the response header
+type HttpStatsFunc func(domain string, statusCode int, requestBytes, responseBytes int64, latency time.Duration)
+
+// max number of requests waiting for responses in one connection
+const maxPendingRequests = 64
+
+type pendingRequest struct {
+	method string
+	bytes  int64
+	start  time.Time
+}
+
+// statsConn watches http requests and responses passed through the connection without modifying them,
+// bytes are copied into pipes and parsed in other goroutines, parsing stops on errors or protocol switching
+type statsConn struct {
+	net.Conn
+	domain    string
+	statsFunc HttpStatsFunc
+	reqWriter *io.PipeWriter
+	resWriter *io.PipeWriter
+	pending   chan *pendingRequest
+	done      chan struct{} // closed when responses parsing stops
+}
+
+func newStatsConn(c net.Conn, domain string, statsFunc HttpStatsFunc) net.Conn {
+	reqReader, reqWriter := io.Pipe()
+	resReader, resWriter := io.Pipe()
+	sc := &statsConn{
+		Conn:      c,
+		domain:    domain,
+		statsFunc: statsFunc,
+		reqWriter: reqWriter,
+		resWriter: resWriter,
+		pending:   make(chan *pendingRequest, maxPendingRequests),
+		done:      make(chan struct{}),
+	}
+	go sc.readRequests(reqReader)
+	go sc.readResponses(resReader)
+	return sc
+}
+
+type countReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (n int, err error) {
+	n, err = cr.Reader.Read(p)
+	cr.n += int64(n)
+	return
+}
+
+func (sc *statsConn) readRequests(pr *io.PipeReader) {
+	defer close(sc.pending)
+	cr := &countReader{Reader: pr}
+	rd := bufio.NewReader(cr)
+	var consumed int64
+	for {
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			pr.CloseWithError(err)
+			return
+		}
+		p := &pendingRequest{
+			method: req.Method,
+			start:  time.Now(),
+		}
+		select {
+		case sc.pending <- p:
+		case <-sc.done:
+			pr.CloseWithError(io.EOF)
+			return
+		}
+
+		_, err = io.Copy(ioutil.Discard, req.Body)
+		req.Body.Close()
+		atomic.StoreInt64(&p.bytes, cr.n-int64(rd.Buffered())-consumed)
+		consumed = cr.n - int64(rd.Buffered())
+		if err != nil || req.Header.Get("Upgrade") != "" {
+			pr.CloseWithError(io.EOF)
+			return
+		}
+	}
+}
+
+func (sc *statsConn) readResponses(pr *io.PipeReader) {
+	defer close(sc.done)
+	cr := &countReader{Reader: pr}
+	rd := bufio.NewReader(cr)
+	var consumed int64
+	for {
+		// responses are returned in the same order as requests
+		p, ok := <-sc.pending
+		if !ok {
+			pr.CloseWithError(io.EOF)
+			return
+		}
+
+		var res *http.Response
+		var err error
+		for {
+			res, err = http.ReadResponse(rd, &http.Request{Method: p.method})
+			if err != nil {
+				pr.CloseWithError(err)
+				return
+			}
+			// skip informational responses like 100 Continue
+			if res.StatusCode >= 200 || res.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+		}
+		latency := time.Since(p.start)
+
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		resBytes := cr.n - int64(rd.Buffered()) - consumed
+		consumed = cr.n - int64(rd.Buffered())
+		sc.statsFunc(sc.domain, res.StatusCode, atomic.LoadInt64(&p.bytes), resBytes, latency)
+		if err != nil || res.StatusCode == http.StatusSwitchingProtocols {
+			pr.CloseWithError(io.EOF)
+			return
+		}
+	}
+}
+
+// errors of pipes are ignored, writing to a pipe whose reader is closed fails immediately
+func (sc *statsConn) Read(p []byte) (n int, err error) {
+	n, err = sc.Conn.Read(p)
+	if n > 0 {
+		sc.reqWriter.Write(p[:n])
+	}
+	if err != nil {
+		sc.reqWriter.Close()
+	}
+	return
+}
+
+func (sc *statsConn) Write(p []byte) (n int, err error) {
+	n, err = sc.Conn.Write(p)
+	if n > 0 {
+		sc.resWriter.Write(p[:n])
+	}
+	if err != nil {
+		sc.resWriter.Close()
+	}
+	return
+}
+
+func (sc *statsConn) Close() error {
+	sc.reqWriter.Close()
+	sc.resWriter.Close()
+	return sc.Conn.Close()
+}
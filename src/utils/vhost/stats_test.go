@@ -0,0 +1,115 @@
+// Copyright 2016 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type statsRecord struct {
+	domain        string
+	statusCode    int
+	requestBytes  int64
+	responseBytes int64
+}
+
+func TestStatsConn(t *testing.T) {
+	assert := assert.New(t)
+	records := make(chan *statsRecord, 10)
+	statsFunc := func(domain string, statusCode int, requestBytes, responseBytes int64, latency time.Duration) {
+		records <- &statsRecord{domain, statusCode, requestBytes, responseBytes}
+	}
+
+	user, server := net.Pipe()
+	sc := newStatsConn(server, "example.com", statsFunc)
+	defer sc.Close()
+
+	// a backend echoes pipelined requests and responds in order
+	go func() {
+		rd := bufio.NewReader(sc)
+		for {
+			req, err := http.ReadRequest(rd)
+			if err != nil {
+				return
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			code := 200
+			if req.URL.Path == "/missing" {
+				code = 404
+			}
+			res := &http.Response{
+				StatusCode:    code,
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				ContentLength: int64(len(body)),
+				Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			}
+			res.Write(sc)
+		}
+	}()
+
+	reqs := "POST /echo HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /missing HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	go user.Write([]byte(reqs))
+
+	rd := bufio.NewReader(user)
+	for _, code := range []int{200, 404} {
+		res, err := http.ReadResponse(rd, nil)
+		assert.NoError(err)
+		assert.Equal(code, res.StatusCode)
+		ioutil.ReadAll(res.Body)
+	}
+
+	r := <-records
+	assert.Equal("example.com", r.domain)
+	assert.Equal(200, r.statusCode)
+	assert.Equal(int64(len("POST /echo HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello")), r.requestBytes)
+	assert.Equal(int64(len("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")), r.responseBytes)
+
+	r = <-records
+	assert.Equal(404, r.statusCode)
+	assert.Equal(int64(len("GET /missing HTTP/1.1\r\nHost: example.com\r\n\r\n")), r.requestBytes)
+}
+
+func TestStatsConnNotHttp(t *testing.T) {
+	assert := assert.New(t)
+	statsFunc := func(domain string, statusCode int, requestBytes, responseBytes int64, latency time.Duration) {
+		t.Errorf("unexpected stats for status code %d", statusCode)
+	}
+
+	user, server := net.Pipe()
+	sc := newStatsConn(server, "example.com", statsFunc)
+	defer sc.Close()
+
+	// data which can't be parsed as http must be passed through without blocking
+	go user.Write([]byte("\x00\x01 not http\r\n\r\n"))
+	buf := make([]byte, 64)
+	n, err := sc.Read(buf)
+	assert.NoError(err)
+	assert.Equal("\x00\x01 not http\r\n\r\n", string(buf[:n]))
+
+	go sc.Write([]byte("pong"))
+	n, err = user.Read(buf)
+	assert.NoError(err)
+	assert.Equal("pong", string(buf[:n]))
+}
@@ -14,6 +14,7 @@ package vhost
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -22,6 +23,7 @@ import (
 	"time"
 
 	"github.com/fatedier/frp/src/utils/conn"
+	"github.com/fatedier/frp/src/utils/trace"
 )
 
 type muxFunc func(*conn.Conn) (net.Conn, map[string]string, error)
@@ -34,6 +36,8 @@ type VhostMuxer struct {
 	vhostFunc   muxFunc
 	authFunc    httpAuthFunc
 	rewriteFunc hostRewriteFunc
+	statsFunc   HttpStatsFunc
+	plainHttp   bool // traffic can be parsed as http without TLS termination
 	registryMap map[string]*Listener
 	mutex       sync.RWMutex
 }
@@ -51,23 +55,57 @@ func NewVhostMuxer(listener *conn.Listener, vhostFunc muxFunc, authFunc httpAuth
 	return mux, nil
 }
 
+// SetStatsFunc enables http statistics of all domains,
+// https traffic is only parsed for domains which have TLS terminated by frps
+func (v *VhostMuxer) SetStatsFunc(statsFunc HttpStatsFunc) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.statsFunc = statsFunc
+}
+
+func (v *VhostMuxer) getStatsFunc() (statsFunc HttpStatsFunc, plainHttp bool) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.statsFunc, v.plainHttp
+}
+
+type VhostRouteConfig struct {
+	Domain      string
+	RewriteHost string
+	Username    string
+	Password    string
+
+	// only for http, every request must be allowed by Authenticator if it is not nil
+	Authenticator HttpAuthenticator
+
+	// only for http, if it is not nil, responses are compressed by frps
+	Compression *HttpCompression
+
+	// only for https, if it is not nil, TLS is terminated by frps with this config
+	// and subject of the verified client certificate is passed to backend in header ClientCertSubjectHeader
+	TlsConfig *tls.Config
+}
+
 // listen for a new domain name, if rewriteHost is not empty  and rewriteFunc is not nil, then rewrite the host header to rewriteHost
-func (v *VhostMuxer) Listen(name string, rewriteHost, userName, passWord string) (l *Listener, err error) {
+func (v *VhostMuxer) Listen(cfg *VhostRouteConfig) (l *Listener, err error) {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
-	if _, exist := v.registryMap[name]; exist {
-		return nil, fmt.Errorf("domain name %s is already bound", name)
+	if _, exist := v.registryMap[cfg.Domain]; exist {
+		return nil, fmt.Errorf("domain name %s is already bound", cfg.Domain)
 	}
 
 	l = &Listener{
-		name:        name,
-		rewriteHost: rewriteHost,
-		userName:    userName,
-		passWord:    passWord,
-		mux:         v,
-		accept:      make(chan *conn.Conn),
-	}
-	v.registryMap[name] = l
+		name:          cfg.Domain,
+		rewriteHost:   cfg.RewriteHost,
+		userName:      cfg.Username,
+		passWord:      cfg.Password,
+		tlsConfig:     cfg.TlsConfig,
+		authenticator: cfg.Authenticator,
+		compression:   cfg.Compression,
+		mux:           v,
+		accept:        make(chan *conn.Conn),
+	}
+	v.registryMap[cfg.Domain] = l
 	return l, nil
 }
 
@@ -107,7 +145,18 @@ func (v *VhostMuxer) run() {
 }
 
 func (v *VhostMuxer) handle(c *conn.Conn) {
-	if err := c.SetDeadline(time.Now().Add(v.timeout)); err != nil {
+	// the span is ended by the proxy if the conn is accepted
+	span := trace.Start("frps.user_conn", "")
+	accepted := false
+	var err error
+	defer func() {
+		if !accepted {
+			span.SetError(err)
+			span.End()
+		}
+	}()
+
+	if err = c.SetDeadline(time.Now().Add(v.timeout)); err != nil {
 		c.Close()
 		return
 	}
@@ -119,9 +168,12 @@ func (v *VhostMuxer) handle(c *conn.Conn) {
 	}
 
 	name := strings.ToLower(reqInfoMap["Host"])
+	span.SetAttribute("domain", name)
+	span.AddEvent("vhost_parsed")
 	// get listener by hostname
 	l, ok := v.getListener(name)
 	if !ok {
+		err = fmt.Errorf("no proxy for domain [%s]", name)
 		c.Close()
 		return
 	}
@@ -130,8 +182,12 @@ func (v *VhostMuxer) handle(c *conn.Conn) {
 	// verify user access
 	if l.mux.authFunc != nil &&
 		l.userName != "" && l.passWord != "" {
-		bAccess, err := l.mux.authFunc(c, l.userName, l.passWord, reqInfoMap["Authorization"])
+		var bAccess bool
+		bAccess, err = l.mux.authFunc(c, l.userName, l.passWord, reqInfoMap["Authorization"])
 		if bAccess == false || err != nil {
+			if err == nil {
+				err = fmt.Errorf("http basic auth failed")
+			}
 			res := noAuthResponse()
 			res.Write(c.TcpConn)
 			c.Close()
@@ -139,22 +195,61 @@ func (v *VhostMuxer) handle(c *conn.Conn) {
 		}
 	}
 
+	statsFunc, plainHttp := v.getStatsFunc()
+	if statsFunc != nil && plainHttp {
+		sConn = newStatsConn(sConn, l.name, statsFunc)
+	}
+
+	if l.authenticator != nil {
+		sConn, err = httpAuthenticate(sConn, l.authenticator)
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+
+	// terminate TLS and verify client certificate if it's required
+	if l.tlsConfig != nil {
+		sConn, err = tlsHandshake(sConn, l.tlsConfig)
+		if err != nil {
+			c.Close()
+			return
+		}
+		if statsFunc != nil {
+			sConn = newStatsConn(sConn, l.name, statsFunc)
+		}
+	}
+
+	if (l.mux.authFunc != nil && l.userName != "" && l.passWord != "") || l.authenticator != nil || l.tlsConfig != nil {
+		span.AddEvent("authenticated")
+	}
+
+	// statistics are counted by compressed responses
+	if l.compression != nil && plainHttp {
+		sConn = newCompressConn(sConn, l.compression)
+	}
+
 	if err = sConn.SetDeadline(time.Time{}); err != nil {
 		c.Close()
 		return
 	}
 	c.SetTcpConn(sConn)
+	c.Trace = span
 
+	accepted = true
 	l.accept <- c
 }
 
 type Listener struct {
-	name        string
-	rewriteHost string
-	userName    string
-	passWord    string
-	mux         *VhostMuxer // for closing VhostMuxer
-	accept      chan *conn.Conn
+	name          string
+	rewriteHost   string
+	userName      string
+	passWord      string
+	tlsConfig     *tls.Config
+	authenticator HttpAuthenticator
+	compression   *HttpCompression
+	mux           *VhostMuxer // for closing VhostMuxer
+	accept        chan *conn.Conn
 }
 
 func (l *Listener) Accept() (*conn.Conn, error) {
@@ -220,6 +315,10 @@ func (sc *sharedConn) Read(p []byte) (n int, err error) {
 	return
 }
 
+func (sc *sharedConn) CloseWrite() error {
+	return conn.CloseWrite(sc.Conn)
+}
+
 func (sc *sharedConn) WriteBuff(buffer []byte) (err error) {
 	sc.buff.Reset()
 	_, err = sc.buff.Write(buffer)